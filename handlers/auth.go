@@ -0,0 +1,43 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/auth"
+)
+
+// openPaths are served without authentication, as with bootstrap.Serve
+var openPaths = []string{"/function/", "/healthz"}
+
+// MakeBasicAuthMiddleware requires basic auth for every route of a router apart from the
+// function proxy and health endpoint, so that routes added to bootstrap.Router() can not
+// be left unauthenticated
+func MakeBasicAuthMiddleware(credentials *auth.BasicAuthCredentials) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		protected := auth.DecorateWithBasicAuth(next.ServeHTTP, credentials)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isOpenPath(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			protected(w, r)
+		})
+	}
+}
+
+func isOpenPath(path string) bool {
+	for _, p := range openPaths {
+		if path == strings.TrimSuffix(p, "/") || strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,61 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/auth"
+)
+
+func Test_BasicAuthMiddleware(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	r := mux.NewRouter()
+	r.Use(MakeBasicAuthMiddleware(&auth.BasicAuthCredentials{User: "admin", Password: "secret"}))
+	r.HandleFunc("/system/functions", ok)
+	r.HandleFunc("/system/federation/providers", ok)
+	r.HandleFunc("/metrics", ok)
+	r.HandleFunc("/function/{name}", ok)
+	r.HandleFunc("/function/{name}/{params:.*}", ok)
+	r.HandleFunc("/healthz", ok)
+
+	tests := []struct {
+		name       string
+		path       string
+		user       string
+		password   string
+		wantStatus int
+	}{
+		{name: "system endpoint without credentials", path: "/system/functions", wantStatus: http.StatusUnauthorized},
+		{name: "federation endpoint without credentials", path: "/system/federation/providers", wantStatus: http.StatusUnauthorized},
+		{name: "federation endpoint with wrong credentials", path: "/system/federation/providers", user: "admin", password: "wrong", wantStatus: http.StatusUnauthorized},
+		{name: "federation endpoint with credentials", path: "/system/federation/providers", user: "admin", password: "secret", wantStatus: http.StatusOK},
+		{name: "metrics without credentials", path: "/metrics", wantStatus: http.StatusUnauthorized},
+		{name: "metrics with credentials", path: "/metrics", user: "admin", password: "secret", wantStatus: http.StatusOK},
+		{name: "function invocation is open", path: "/function/echo", wantStatus: http.StatusOK},
+		{name: "function invocation with path is open", path: "/function/echo/status", wantStatus: http.StatusOK},
+		{name: "health is open", path: "/healthz", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+			if len(tt.user) > 0 {
+				req.SetBasicAuth(tt.user, tt.password)
+			}
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want status %d, got %d", tt.wantStatus, rr.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas-incubator/faas-federation/routing"
+	types "github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/faas/gateway/requests"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// PermissionInvoke allows functions to be invoked
+	PermissionInvoke = "invoke"
+	// PermissionRead allows functions, replicas, logs, namespaces, the names of secrets and the federation events to be read
+	PermissionRead = "read"
+	// PermissionDeploy allows functions to be deployed, updated, scaled and deleted, and
+	// secrets to be changed, on any provider. "deploy:<selector>" limits this to the
+	// providers matching the selector
+	PermissionDeploy = "deploy"
+	// PermissionAdmin allows everything, including the federation API
+	PermissionAdmin = "admin"
+)
+
+// Permissions granted to a caller by the claims of its token
+type Permissions []string
+
+// Allows returns true when the permission, or admin, has been granted
+func (p Permissions) Allows(permission string) bool {
+	for _, v := range p {
+		if v == permission || v == PermissionAdmin {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsDeployTo returns true when deploy has been granted for any provider, or with a
+// selector matching the labels of the provider
+func (p Permissions) AllowsDeployTo(provider routing.Provider) bool {
+	if p.Allows(PermissionDeploy) {
+		return true
+	}
+
+	for _, v := range p {
+		if !strings.HasPrefix(v, PermissionDeploy+":") {
+			continue
+		}
+
+		matches, err := provider.MatchesSelector(strings.TrimPrefix(v, PermissionDeploy+":"))
+		if err != nil {
+			log.Warnf("ignoring invalid permission %q. %v", v, err)
+			continue
+		}
+
+		if matches {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MakeJWTAuthMiddleware requires a bearer token validated by the validator for every route
+// apart from the health endpoint. The permissions listed by permissionsClaim of the token
+// are checked against the request, deploy, update, delete, scale and secret requests are resolved
+// to their providers with providerLookup so that deploy permissions can be limited by label
+func MakeJWTAuthMiddleware(validator *JWTValidator, permissionsClaim string, providerLookup routing.ProviderLookup) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/healthz" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			header := r.Header.Get("Authorization")
+			token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+			if !strings.HasPrefix(header, "Bearer ") || len(token) == 0 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="faas-federation"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("a bearer token is required"))
+				return
+			}
+
+			claims, err := validator.Validate(token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="faas-federation", error="invalid_token"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(err.Error()))
+				return
+			}
+
+			permissions := Permissions(claimValues(claims[permissionsClaim]))
+			if err := authorizeRequest(providerLookup, permissions, r); err != nil {
+				log.Warnf("denied %s %s for %v. %v", r.Method, r.URL.Path, claims["sub"], err)
+
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(err.Error()))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authorizeRequest returns an error giving the reason when the permissions do not allow
+// the request, routes which are not listed require admin
+func authorizeRequest(providerLookup routing.ProviderLookup, permissions Permissions, r *http.Request) error {
+	path := r.URL.Path
+
+	switch {
+	case strings.HasPrefix(path, "/function/"):
+		return require(permissions, PermissionInvoke)
+	case path == "/system/functions" && r.Method == http.MethodGet,
+		strings.HasPrefix(path, "/system/function/") && r.Method == http.MethodGet,
+		path == "/system/info", path == "/system/logs", path == "/system/namespaces",
+		path == secretsPath && r.Method == http.MethodGet,
+		strings.HasPrefix(path, "/system/federation/replicas/") && r.Method == http.MethodGet,
+		path == "/system/federation/events":
+		return require(permissions, PermissionRead)
+	case path == secretsPath:
+		return authorizeSecret(providerLookup, permissions, r)
+	case path == "/system/functions" && (r.Method == http.MethodPost || r.Method == http.MethodPut):
+		return authorizeDeployment(providerLookup, permissions, r)
+	case path == "/system/functions" && r.Method == http.MethodDelete:
+		return authorizeDelete(providerLookup, permissions, r)
+	case strings.HasPrefix(path, "/system/scale-function/"):
+		return authorizeExisting(providerLookup, permissions, routing.FunctionKey(strings.TrimPrefix(path, "/system/scale-function/"), r.URL.Query().Get("namespace")))
+	}
+
+	return require(permissions, PermissionAdmin)
+}
+
+func require(permissions Permissions, permission string) error {
+	if !permissions.Allows(permission) {
+		return fmt.Errorf("permission %q is required", permission)
+	}
+
+	return nil
+}
+
+func authorizeDeployment(providerLookup routing.ProviderLookup, permissions Permissions, r *http.Request) error {
+	if permissions.Allows(PermissionDeploy) {
+		return nil
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	f := &types.FunctionDeployment{}
+	if err := json.Unmarshal(body, f); err != nil {
+		return fmt.Errorf("unable to authorize deployment, invalid request. %v", err)
+	}
+
+	// an update must also be allowed on the providers the function is currently deployed to
+	if existing, ok := providerLookup.GetFunction(routing.FunctionKey(f.Service, f.Namespace)); ok && r.Method == http.MethodPut {
+		if err := authorizeFunction(providerLookup, permissions, existing); err != nil {
+			return err
+		}
+	}
+
+	return authorizeFunction(providerLookup, permissions, f)
+}
+
+func authorizeDelete(providerLookup routing.ProviderLookup, permissions Permissions, r *http.Request) error {
+	if permissions.Allows(PermissionDeploy) {
+		return nil
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	f := requests.DeleteFunctionRequest{}
+	if err := json.Unmarshal(body, &f); err != nil {
+		return fmt.Errorf("unable to authorize delete, invalid request. %v", err)
+	}
+
+	return authorizeExisting(providerLookup, permissions, routing.FunctionKey(f.FunctionName, r.URL.Query().Get("namespace")))
+}
+
+// authorizeExisting checks deploy permissions for the providers a deployed function is on
+func authorizeExisting(providerLookup routing.ProviderLookup, permissions Permissions, functionName string) error {
+	if permissions.Allows(PermissionDeploy) {
+		return nil
+	}
+
+	if f, ok := providerLookup.GetFunction(functionName); ok {
+		return authorizeFunction(providerLookup, permissions, f)
+	}
+
+	providerURL, err := providerLookup.Resolve(functionName)
+	if err != nil {
+		return fmt.Errorf("unable to resolve the provider of function %s. %v", functionName, err)
+	}
+
+	return authorizeProviders(providerLookup, permissions, "function "+functionName, []string{providerLookup.ProviderName(providerURL)})
+}
+
+// authorizeFunction checks deploy permissions for every provider the function resolves to
+func authorizeFunction(providerLookup routing.ProviderLookup, permissions Permissions, f *types.FunctionDeployment) error {
+	providers, err := resolveProviders(providerLookup, f)
+	if err != nil {
+		return fmt.Errorf("unable to resolve the providers of function %s. %v", f.Service, err)
+	}
+
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+
+	return authorizeProviders(providerLookup, permissions, "function "+f.Service, names)
+}
+
+// authorizeSecret checks deploy permissions for every provider a secret is changed on
+func authorizeSecret(providerLookup routing.ProviderLookup, permissions Permissions, r *http.Request) error {
+	if permissions.Allows(PermissionDeploy) {
+		return nil
+	}
+
+	providers, err := selectProviders(providerLookup, r.URL.Query())
+	if err != nil {
+		return fmt.Errorf("unable to authorize secret request. %v", err)
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	secret := types.Secret{}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return fmt.Errorf("unable to authorize secret request, invalid request. %v", err)
+	}
+
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+
+	return authorizeProviders(providerLookup, permissions, "secret "+secret.Name, names)
+}
+
+// authorizeProviders checks deploy permissions for each provider, subject names what is
+// being changed in the reason of the error
+func authorizeProviders(providerLookup routing.ProviderLookup, permissions Permissions, subject string, names []string) error {
+	sort.Strings(names)
+
+	for _, name := range names {
+		provider, ok := providerLookup.GetProvider(name)
+		if !ok {
+			return fmt.Errorf("unable to authorize %s, provider %s does not exist", subject, name)
+		}
+
+		if !permissions.AllowsDeployTo(provider) {
+			return fmt.Errorf("permission %q is not granted for provider %s with labels {%s}, required by %s",
+				PermissionDeploy, name, routing.FormatLabels(provider.Labels), subject)
+		}
+	}
+
+	return nil
+}
+
+// readBody reads the body of the request and replaces it so that it can be read again
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, fmt.Errorf("unable to authorize an empty request")
+	}
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read request. %v", err)
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	return body, nil
+}
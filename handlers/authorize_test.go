@@ -0,0 +1,245 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas-incubator/faas-federation/routing"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+type testSigner struct {
+	rsaKey *rsa.PrivateKey
+	// ecKeys by kid
+	ecKeys map[string]*ecdsa.PrivateKey
+}
+
+func newTestSigner() *testSigner {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ec384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	return &testSigner{rsaKey: rsaKey, ecKeys: map[string]*ecdsa.PrivateKey{"ec": ecKey, "ec384": ec384Key}}
+}
+
+func (s *testSigner) jwks() []byte {
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks := map[string][]map[string]string{"keys": {
+		{"kid": "rsa", "kty": "RSA", "use": "sig", "n": encode(s.rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(s.rsaKey.E)).Bytes())},
+		{"kid": "ec", "kty": "EC", "crv": "P-256", "x": encode(s.ecKeys["ec"].X.Bytes()), "y": encode(s.ecKeys["ec"].Y.Bytes())},
+		{"kid": "ec384", "kty": "EC", "crv": "P-384", "x": encode(s.ecKeys["ec384"].X.Bytes()), "y": encode(s.ecKeys["ec384"].Y.Bytes())},
+	}}
+	data, _ := json.Marshal(jwks)
+
+	return data
+}
+
+// sign returns a token signed with RS256 for kid "rsa", ES256 for kid "ec" or ES384 for kid "ec384"
+func (s *testSigner) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	return s.signAs(t, kid, map[string]string{"rsa": "RS256", "ec": "ES256", "ec384": "ES384"}[kid], claims)
+}
+
+// signAs returns a token whose header claims alg, signed with the key of kid and the hash of alg
+func (s *testSigner) signAs(t *testing.T, kid string, alg string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	hash := crypto.SHA256
+	if strings.HasSuffix(alg, "384") {
+		hash = crypto.SHA384
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	var signature []byte
+	if ecKey, ok := s.ecKeys[kid]; ok {
+		r, sig, err := ecdsa.Sign(rand.Reader, ecKey, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		signature = append(r.FillBytes(make([]byte, size)), sig.FillBytes(make([]byte, size))...)
+	} else {
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, s.rsaKey, hash, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func Test_JWTValidator_Validate(t *testing.T) {
+	signer := newTestSigner()
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(signer.jwks())
+	}))
+	defer jwks.Close()
+
+	validator, err := NewJWTValidator(JWTConfig{JWKS: jwks.URL, Issuer: "https://issuer", Audience: "faas-federation"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{"iss": "https://issuer", "aud": []string{"faas-federation"}, "exp": time.Now().Add(time.Hour).Unix()}
+	}
+	with := func(k string, v interface{}) map[string]interface{} {
+		claims := valid()
+		claims[k] = v
+		return claims
+	}
+
+	other := newTestSigner()
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{name: "RS256", token: signer.sign(t, "rsa", valid())},
+		{name: "ES256", token: signer.sign(t, "ec", valid())},
+		{name: "ES384", token: signer.sign(t, "ec384", valid())},
+		{name: "ES256 signed with a P-384 key", token: signer.signAs(t, "ec384", "ES256", valid()), wantErr: "does not match signing algorithm"},
+		{name: "ES384 signed with a P-256 key", token: signer.signAs(t, "ec", "ES384", valid()), wantErr: "does not match signing algorithm"},
+		{name: "without expiry", token: signer.sign(t, "rsa", map[string]interface{}{"iss": "https://issuer", "aud": "faas-federation"}), wantErr: "no expiry"},
+		{name: "expired", token: signer.sign(t, "rsa", with("exp", time.Now().Add(-time.Hour).Unix())), wantErr: "expired"},
+		{name: "not valid yet", token: signer.sign(t, "rsa", with("nbf", time.Now().Add(time.Hour).Unix())), wantErr: "not valid yet"},
+		{name: "wrong issuer", token: signer.sign(t, "rsa", with("iss", "https://other")), wantErr: "issuer"},
+		{name: "wrong audience", token: signer.sign(t, "rsa", with("aud", "other")), wantErr: "audience"},
+		{name: "signed by another key", token: other.sign(t, "rsa", valid()), wantErr: "invalid token signature"},
+		{name: "unsigned", token: strings.Join(strings.Split(signer.sign(t, "rsa", valid()), ".")[:2], ".") + ".", wantErr: "invalid token signature"},
+		{name: "malformed", token: "not-a-token", wantErr: "malformed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validator.Validate(tt.token)
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Fatalf("want token to be valid, got %v", err)
+			}
+
+			if len(tt.wantErr) > 0 && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("want error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_JWTAuthMiddleware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	signer := newTestSigner()
+	jwksFile := path.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(jwksFile, signer.jwks(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	validator, err := NewJWTValidator(JWTConfig{JWKS: jwksFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{
+		{Name: "dev", URL: "http://dev:8080", Labels: map[string]string{"env": "dev"}, Default: true},
+		{Name: "prod", URL: "http://prod:8080", Labels: map[string]string{"env": "prod"}},
+	}, routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerLookup.AddFunction(&types.FunctionDeployment{Service: "billing", Annotations: &map[string]string{"com.openfaas.federation.gateway": "prod"}})
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}
+
+	r := mux.NewRouter()
+	r.Use(MakeJWTAuthMiddleware(validator, "permissions", providerLookup))
+	r.HandleFunc("/system/functions", ok)
+	r.HandleFunc("/system/scale-function/{name}", ok)
+	r.HandleFunc("/system/secrets", ok)
+	r.HandleFunc("/system/federation/providers", ok)
+	r.HandleFunc("/system/federation/events", ok)
+	r.HandleFunc("/function/{name}", ok)
+	r.HandleFunc("/healthz", ok)
+
+	token := func(permissions ...string) string {
+		return signer.sign(t, "rsa", map[string]interface{}{"sub": "test", "permissions": permissions, "exp": time.Now().Add(time.Hour).Unix()})
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		token      string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "health is open", method: http.MethodGet, path: "/healthz", wantStatus: http.StatusOK},
+		{name: "invoke without token", method: http.MethodGet, path: "/function/echo", wantStatus: http.StatusUnauthorized},
+		{name: "invoke with invalid token", method: http.MethodGet, path: "/function/echo", token: "a.b.c", wantStatus: http.StatusUnauthorized},
+		{name: "invoke", method: http.MethodGet, path: "/function/echo", token: token("invoke"), wantStatus: http.StatusOK},
+		{name: "invoke only can not list", method: http.MethodGet, path: "/system/functions", token: token("invoke"), wantStatus: http.StatusForbidden, wantBody: `permission "read" is required`},
+		{name: "invoke only can not deploy", method: http.MethodPost, path: "/system/functions", body: `{"service": "echo"}`, token: token("invoke"), wantStatus: http.StatusForbidden, wantBody: `permission "deploy" is not granted for provider dev`},
+		{name: "read lists functions", method: http.MethodGet, path: "/system/functions", token: token("read"), wantStatus: http.StatusOK},
+		{name: "deploy to any provider", method: http.MethodPost, path: "/system/functions", body: `{"service": "echo", "annotations": {"com.openfaas.federation.gateway": "prod"}}`, token: token("deploy"), wantStatus: http.StatusOK, wantBody: `"service": "echo"`},
+		{name: "deploy to matching provider", method: http.MethodPost, path: "/system/functions", body: `{"service": "echo", "annotations": {"com.openfaas.federation.selector": "env=prod"}}`, token: token("deploy:env=prod"), wantStatus: http.StatusOK, wantBody: `"service": "echo"`},
+		{name: "deploy to provider not matching", method: http.MethodPost, path: "/system/functions", body: `{"service": "echo"}`, token: token("deploy:env=prod"), wantStatus: http.StatusForbidden, wantBody: "provider dev with labels {env=dev}"},
+		{name: "replicated deploy needs every provider", method: http.MethodPost, path: "/system/functions", body: `{"service": "echo", "annotations": {"com.openfaas.federation.replicas-on": "dev,prod"}}`, token: token("deploy:env=dev"), wantStatus: http.StatusForbidden, wantBody: "provider prod"},
+		{name: "update can not move a function off a provider", method: http.MethodPut, path: "/system/functions", body: `{"service": "billing"}`, token: token("deploy:env=dev"), wantStatus: http.StatusForbidden, wantBody: "provider prod"},
+		{name: "delete on matching provider", method: http.MethodDelete, path: "/system/functions", body: `{"functionName": "billing"}`, token: token("deploy:env=prod"), wantStatus: http.StatusOK, wantBody: "billing"},
+		{name: "delete on provider not matching", method: http.MethodDelete, path: "/system/functions", body: `{"functionName": "billing"}`, token: token("deploy:env=dev"), wantStatus: http.StatusForbidden},
+		{name: "scale on provider not matching", method: http.MethodPost, path: "/system/scale-function/billing", body: `{"replicas": 2}`, token: token("deploy:env=dev"), wantStatus: http.StatusForbidden},
+		{name: "list secrets", method: http.MethodGet, path: "/system/secrets", token: token("read"), wantStatus: http.StatusOK},
+		{name: "create secret on matching provider", method: http.MethodPost, path: "/system/secrets?providers=dev", body: `{"name": "db-password"}`, token: token("deploy:env=dev"), wantStatus: http.StatusOK},
+		{name: "create secret on every provider", method: http.MethodPost, path: "/system/secrets", body: `{"name": "db-password"}`, token: token("deploy:env=dev"), wantStatus: http.StatusForbidden, wantBody: "provider prod with labels {env=prod}, required by secret db-password"},
+		{name: "read streams events", method: http.MethodGet, path: "/system/federation/events", token: token("read"), wantStatus: http.StatusOK},
+		{name: "federation API needs admin", method: http.MethodGet, path: "/system/federation/providers", token: token("read", "deploy"), wantStatus: http.StatusForbidden, wantBody: `permission "admin" is required`},
+		{name: "admin", method: http.MethodGet, path: "/system/federation/providers", token: token("admin"), wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if len(tt.token) > 0 {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			if !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("want body containing %q, got %q", tt.wantBody, rr.Body.String())
+			}
+		})
+	}
+}
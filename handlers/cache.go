@@ -0,0 +1,35 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+)
+
+// CacheSummary is the state of the federation's function cache
+type CacheSummary struct {
+	Functions int                    `json:"functions"`
+	Orphaned  int                    `json:"orphaned"`
+	Misses    routing.CacheMissStats `json:"misses"`
+}
+
+// MakeCacheHandler shows the number of cached functions and how the lookups of functions
+// which were not cached were handled
+func MakeCacheHandler(providerLookup routing.FunctionCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		summary := CacheSummary{
+			Functions: len(providerLookup.GetFunctions()),
+			Orphaned:  len(providerLookup.GetOrphanedFunctions()),
+			Misses:    providerLookup.GetCacheMissStats(),
+		}
+
+		summaryBytes, _ := json.Marshal(summary)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(summaryBytes)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas-incubator/faas-federation/metrics"
+	"github.com/openfaas-incubator/faas-federation/routing"
+	log "github.com/sirupsen/logrus"
+)
+
+// providerVar is the path variable naming the provider of a function which is not cached yet
+const providerVar = "provider"
+
+// MakeControlPlaneProxy proxies a control-plane request such as a deployment to the provider
+// named by the `provider` path variable, or otherwise to the provider the function named by
+// the `name` path variable and the namespace query parameter resolves to. The caller's
+// Authorization header is replaced with the credentials of that provider
+func MakeControlPlaneProxy(providerLookup routing.ProviderLookup, timeout time.Duration) http.HandlerFunc {
+	proxies := newProviderProxies(timeout, providerLookup.GetTransport())
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := requestFunctionName(r)
+
+		providerURL, err := resolveRequestProvider(providerLookup, r, functionName)
+		if err != nil {
+			log.Errorf("can not resolve provider for %s. %v", functionName, err)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("Cannot find service: %s.", functionName)))
+			return
+		}
+
+		if _, ok := mux.Vars(r)[providerVar]; !ok {
+			qualifyRequest(providerLookup, r, functionName)
+		}
+
+		if err := authorize(providerLookup, providerURL, r); err != nil {
+			log.Errorln(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		proxies.get(providerURL, timeout).ServeHTTP(recorder, r)
+		recordControlPlane(r, providerLookup.ProviderName(providerURL), recorder.succeeded())
+	}
+}
+
+// controlPlaneOperations names the requests to /system/functions counted by recordControlPlane
+var controlPlaneOperations = map[string]string{
+	http.MethodPost:   "deploy",
+	http.MethodPut:    "update",
+	http.MethodDelete: "delete",
+}
+
+// recordControlPlane counts a deploy, update or delete sent to a provider by its result
+func recordControlPlane(r *http.Request, provider string, succeeded bool) {
+	operation, ok := controlPlaneOperations[r.Method]
+	if !ok || r.URL.Path != "/system/functions" {
+		return
+	}
+
+	result := "success"
+	if !succeeded {
+		result = "failure"
+	}
+
+	metrics.ControlPlaneOperations.Inc(operation, provider, result)
+}
+
+// requestFunctionName returns the `name` path variable qualified with the namespace query parameter
+func requestFunctionName(r *http.Request) string {
+	return routing.FunctionKey(mux.Vars(r)["name"], r.URL.Query().Get("namespace"))
+}
+
+// qualifyRequest returns the cache key of the function functionName resolves to. A request
+// which names a function in a namespace by its name alone is given the namespace query
+// parameter of the function, so that the provider does not look in its default namespace
+func qualifyRequest(providerLookup routing.FunctionCache, r *http.Request, functionName string) string {
+	f, ok := providerLookup.GetFunction(functionName)
+	if !ok {
+		return functionName
+	}
+
+	if len(f.Namespace) > 0 && len(r.URL.Query().Get("namespace")) == 0 {
+		r.URL = namespaceURL(r.URL, f.Namespace)
+	}
+
+	return routing.FunctionKey(f.Service, f.Namespace)
+}
+
+// namespaceURL returns a copy of u with the namespace query parameter of a function
+func namespaceURL(u *url.URL, namespace string) *url.URL {
+	result := *u
+	query := result.Query()
+	query.Del("namespace")
+	if len(namespace) > 0 {
+		query.Set("namespace", namespace)
+	}
+	result.RawQuery = query.Encode()
+
+	return &result
+}
+
+// resolveRequestProvider returns the provider named by the `provider` path variable, otherwise
+// the provider the function resolves to
+func resolveRequestProvider(providerLookup routing.ProviderLookup, r *http.Request, functionName string) (*url.URL, error) {
+	name, ok := mux.Vars(r)[providerVar]
+	if !ok {
+		return providerLookup.Resolve(functionName)
+	}
+
+	providerURL, ok := providerLookup.GetProviders()[name]
+	if !ok {
+		return nil, fmt.Errorf("provider %s does not exist", name)
+	}
+
+	return providerURL, nil
+}
+
+// authorize replaces the Authorization header of r with the credentials of the provider
+func authorize(providerLookup routing.ProviderRegistry, providerURL *url.URL, r *http.Request) error {
+	p, _ := providerLookup.GetProvider(providerLookup.ProviderName(providerURL))
+	if err := p.Credentials.Authorize(r); err != nil {
+		return fmt.Errorf("can not authorize request to provider %s. %v", p.Name, err)
+	}
+
+	return nil
+}
+
+// statusRecorder records the status code of a response written by a proxy
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+
+	return s.ResponseWriter.Write(b)
+}
+
+// succeeded returns true when the response has a 2xx status, a response which was not
+// written is sent with a 200
+func (s *statusRecorder) succeeded() bool {
+	return s.status == 0 || (s.status >= 200 && s.status < 300)
+}
@@ -0,0 +1,87 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+)
+
+func Test_Deploy_SendsProviderCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(path.Join(dir, "token"), []byte("provider-b-token"), 0600)
+
+	received := map[string]string{}
+	var lock sync.Mutex
+	newProvider := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			defer lock.Unlock()
+			received[name] = r.Header.Get("Authorization")
+			w.WriteHeader(http.StatusAccepted)
+		}))
+	}
+	providerA := newProvider("a")
+	defer providerA.Close()
+	providerB := newProvider("b")
+	defer providerB.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{
+		{Name: "a", URL: providerA.URL, Default: true},
+		{Name: "b", URL: providerB.URL, Credentials: &routing.Credentials{TokenFile: path.Join(dir, "token")}},
+	}, routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want map[string]string
+	}{
+		{name: "single provider", body: `{"service": "echo", "annotations": {"com.openfaas.federation.gateway": "b"}}`, want: map[string]string{"b": "Bearer provider-b-token"}},
+		{name: "default provider without credentials", body: `{"service": "cat"}`, want: map[string]string{"a": ""}},
+		{name: "replicated", body: `{"service": "wc", "annotations": {"com.openfaas.federation.replicas-on": "a,b"}}`, want: map[string]string{"a": "", "b": "Bearer provider-b-token"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k := range received {
+				delete(received, k)
+			}
+
+			req, _ := http.NewRequest(http.MethodPost, "/system/functions", strings.NewReader(tt.body))
+			req.SetBasicAuth("admin", "federation-password")
+			rr := httptest.NewRecorder()
+
+			MakeDeployHandler(MakeControlPlaneProxy(providerLookup, time.Second), providerLookup).ServeHTTP(rr, req)
+			if rr.Code != http.StatusAccepted && rr.Code != http.StatusOK {
+				t.Fatalf("want deployment to succeed, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			if len(received) != len(tt.want) {
+				t.Fatalf("want requests to %v, got %v", tt.want, received)
+			}
+
+			for name, want := range tt.want {
+				if got, ok := received[name]; !ok || got != want {
+					t.Errorf("want provider %s to receive Authorization %q, got %q", name, want, got)
+				}
+			}
+		})
+	}
+}
@@ -10,13 +10,15 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/openfaas-incubator/faas-federation/routing"
 
 	"github.com/openfaas/faas/gateway/requests"
 	log "github.com/sirupsen/logrus"
 )
 
-// MakeDeleteHandler delete a function
-func MakeDeleteHandler(proxy http.HandlerFunc) http.HandlerFunc {
+// MakeDeleteHandler delete a function, a replicated function is deleted from each of its providers.
+// The function is removed from the cache once its providers deleted it
+func MakeDeleteHandler(proxy http.HandlerFunc, providerLookup routing.ProviderLookup) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Info("delete request")
 		defer r.Body.Close()
@@ -37,16 +39,41 @@ func MakeDeleteHandler(proxy http.HandlerFunc) http.HandlerFunc {
 
 		r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
 
+		functionName := routing.FunctionKey(f.FunctionName, r.URL.Query().Get("namespace"))
+		if cached, ok := providerLookup.GetFunction(functionName); ok {
+			functionName = qualifyRequest(providerLookup, r, functionName)
+			replicas, err := providerLookup.ResolveReplicas(cached)
+			if err != nil {
+				log.Warnf("deleting function %s from a single provider. %v", f.FunctionName, err)
+			} else if len(replicas) > 0 {
+				results := replicate(providerLookup, replicas, r)
+				writeReplicationResult(w, ReplicationResult{Function: f.FunctionName, Providers: results})
+				if allSucceeded(results) {
+					providerLookup.RemoveFunction(functionName)
+				}
+				log.Infof("delete request %s replicated to %d providers", f.FunctionName, len(replicas))
+				return
+			}
+		}
+
 		pathVars := mux.Vars(r)
 		if pathVars == nil {
 			r = mux.SetURLVars(r, map[string]string{})
 			pathVars = mux.Vars(r)
 		}
 
-		pathVars["name"] = f.FunctionName
+		pathVars["name"] = functionName
 		pathVars["params"] = r.URL.Path
-		proxy.ServeHTTP(w, r)
 
+		recorder := &statusRecorder{ResponseWriter: w}
+		proxy.ServeHTTP(recorder, r)
+		if !recorder.succeeded() && recorder.status != http.StatusNotFound {
+			log.Errorf("delete request %s failed with status %d", f.FunctionName, recorder.status)
+			return
+		}
+
+		// a provider which does not have the function leaves nothing to route to either
+		providerLookup.RemoveFunction(functionName)
 		log.Infof("delete request %s successful", f.FunctionName)
 	}
 }
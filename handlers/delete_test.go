@@ -11,6 +11,7 @@ import (
 	"github.com/openfaas-incubator/faas-federation/routing"
 	acc "github.com/openfaas-incubator/faas-federation/testing"
 	"github.com/openfaas/faas-provider/proxy"
+	types "github.com/openfaas/faas-provider/types"
 )
 
 // Test_Delete requires `make up` and `cd examples && faas-cli up`
@@ -24,7 +25,7 @@ func Test_Delete(t *testing.T) {
 	mux.NewRouter()
 	rr := httptest.NewRecorder()
 
-	providerLookup, err := routing.NewDefaultProviderRouting([]string{"http://faas-provider-a:8082", "http://faas-provider-b:8083"}, "http://faas-provider-a:8082")
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082", nil, "http://faas-provider-a:8082", "http://faas-provider-b:8083"), routing.Config{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -36,11 +37,73 @@ func Test_Delete(t *testing.T) {
 
 	proxyFunc := proxy.NewHandlerFunc(time.Minute*1, NewFunctionLookup(providerLookup))
 
-	MakeDeleteHandler(proxyFunc).ServeHTTP(rr, req)
+	MakeDeleteHandler(proxyFunc, providerLookup).ServeHTTP(rr, req)
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusOK)
 	}
 }
 
+func Test_Delete_RemovesFromCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		wantCached bool
+	}{
+		{name: "provider deletes the function", status: http.StatusOK},
+		{name: "provider does not have the function", status: http.StatusNotFound},
+		{name: "provider fails", status: http.StatusInternalServerError, wantCached: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082", nil, "http://faas-provider-a:8082"), routing.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			providerLookup.AddFunction(&types.FunctionDeployment{Service: "echo-a"})
+
+			proxyFunc := func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}
+
+			req, _ := http.NewRequest("DELETE", "/system/functions", bytes.NewBuffer([]byte(`{"functionName":"echo-a"}`)))
+			rr := httptest.NewRecorder()
+			MakeDeleteHandler(proxyFunc, providerLookup).ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Errorf("want status %d, got %d", tt.status, rr.Code)
+			}
+
+			if _, ok := providerLookup.GetFunction("echo-a"); ok != tt.wantCached {
+				t.Errorf("want function cached %t, got %t", tt.wantCached, ok)
+			}
+		})
+	}
+}
+
+func Test_Delete_UnqualifiedName(t *testing.T) {
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082", nil, "http://faas-provider-a:8082"), routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerLookup.AddFunction(&types.FunctionDeployment{Service: "cat", Namespace: "team-a"})
+
+	var got string
+	proxyFunc := func(w http.ResponseWriter, r *http.Request) {
+		got = mux.Vars(r)["name"] + "?" + r.URL.RawQuery
+	}
+
+	req, _ := http.NewRequest("DELETE", "/system/functions", bytes.NewBuffer([]byte(`{"functionName":"cat"}`)))
+	rr := httptest.NewRecorder()
+	MakeDeleteHandler(proxyFunc, providerLookup).ServeHTTP(rr, req)
+
+	if want := "cat.team-a?namespace=team-a"; got != want {
+		t.Errorf("want the delete sent for %s, got %s", want, got)
+	}
+	if _, ok := providerLookup.GetFunction("cat.team-a"); ok {
+		t.Error("want the function removed from the cache")
+	}
+}
+
 const echoDelete = `{"functionName":"echo-b"}`
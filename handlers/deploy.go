@@ -17,47 +17,97 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// MakeDeployHandler creates a handler to create new functions in the cluster
+// MakeDeployHandler creates a handler to create new functions in the cluster, the function
+// is cached once a provider accepted the deployment
 func MakeDeployHandler(proxy http.HandlerFunc, providerLookup routing.ProviderLookup) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		log.Info("deployment request")
 
-		function, err := addToFunctionToCache(r, providerLookup)
+		function, err := readDeployment(r, providerLookup)
 		if err != nil {
-			log.Errorln("error during unmarshal of create function request. ", err)
+			log.Errorln("invalid create function request. ", err)
 			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
 			return
 		}
 
-		proxyDeployment(proxy, function, w, r)
+		proxyDeployment(proxy, providerLookup, function, w, r)
 
 		log.Infof("deployment request for function %s path %s", function.Service, r.URL.String())
 	}
 }
 
-func proxyDeployment(proxy http.HandlerFunc, function *types.FunctionDeployment, w http.ResponseWriter, r *http.Request) {
+// proxyDeployment sends the deployment to every provider listed for a replicated function,
+// otherwise it is proxied to the single provider the function resolves to. The function is
+// cached once a provider accepted the deployment, so a failed deployment or update leaves
+// the cache as it was
+func proxyDeployment(proxy http.HandlerFunc, providerLookup routing.ProviderLookup, function *types.FunctionDeployment, w http.ResponseWriter, r *http.Request) {
+	replicas, err := providerLookup.ResolveReplicas(function)
+	if err != nil {
+		log.Errorln(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if len(replicas) > 0 {
+		results := replicate(providerLookup, replicas, r)
+		writeReplicationResult(w, ReplicationResult{Function: function.Service, Providers: results})
+		if anySucceeded(results) {
+			providerLookup.AddFunction(function)
+		}
+		return
+	}
+
+	providerURL, err := providerLookup.ResolveFunction(function)
+	if err != nil {
+		log.Errorln(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	pathVars := mux.Vars(r)
 	if pathVars == nil {
 		r = mux.SetURLVars(r, map[string]string{})
 		pathVars = mux.Vars(r)
 	}
 
-	pathVars["name"] = function.Service
+	pathVars["name"] = routing.FunctionKey(function.Service, function.Namespace)
 	pathVars["params"] = r.URL.Path
-	proxy.ServeHTTP(w, r)
+	pathVars[providerVar] = providerLookup.ProviderName(providerURL)
+
+	recorder := &statusRecorder{ResponseWriter: w}
+	proxy.ServeHTTP(recorder, r)
+	if recorder.succeeded() {
+		providerLookup.AddFunction(function)
+	}
 }
 
-func addToFunctionToCache(r *http.Request, providerLookup routing.ProviderLookup) (*types.FunctionDeployment, error) {
+// readDeployment reads the function of a deployment or update, checking that it can be
+// placed and that the secrets it uses exist on its providers
+func readDeployment(r *http.Request, providerLookup routing.ProviderLookup) (*types.FunctionDeployment, error) {
 	defer r.Body.Close()
-	body, _ := ioutil.ReadAll(r.Body)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the function request. %v", err)
+	}
 
 	request := &types.FunctionDeployment{}
 	if err := json.Unmarshal(body, &request); err != nil {
 		return nil, fmt.Errorf("error during unmarshal of create function request. %v", err)
 	}
 
-	providerLookup.AddFunction(request)
+	providers, err := resolveProviders(providerLookup, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkSecrets(r.Context(), providerLookup, request, providers); err != nil {
+		return nil, err
+	}
+
 	r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
 	return request, nil
 }
@@ -2,9 +2,13 @@ package handlers
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -23,7 +27,7 @@ func Test_Deploy(t *testing.T) {
 	mux.NewRouter()
 	rr := httptest.NewRecorder()
 
-	providerLookup, err := routing.NewDefaultProviderRouting([]string{"http://faas-provider-a:8082", "http://faas-provider-b:8083"}, "http://faas-provider-a:8082")
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082", nil, "http://faas-provider-a:8082", "http://faas-provider-b:8083"), routing.Config{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -37,4 +41,83 @@ func Test_Deploy(t *testing.T) {
 	}
 }
 
+func Test_Deploy_UnmatchedSelectorIsRejected(t *testing.T) {
+	req, err := http.NewRequest("POST", "/system/functions", bytes.NewBuffer([]byte(echoDeployWithSelector)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082",
+		map[string]map[string]string{"faas-provider-b": {"region": "eu-west"}}, "http://faas-provider-a:8082", "http://faas-provider-b:8083"), routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxyFunc := func(w http.ResponseWriter, r *http.Request) {
+		t.Error("deployment should not be proxied")
+	}
+
+	MakeDeployHandler(proxyFunc, providerLookup).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+
+	if _, ok := providerLookup.GetFunction("echo-a"); ok {
+		t.Error("rejected function should not be added to the cache")
+	}
+}
+
+func Test_Deploy_FailureIsNotCached(t *testing.T) {
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082", nil, "http://faas-provider-a:8082"), routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var provider string
+	proxyFunc := func(w http.ResponseWriter, r *http.Request) {
+		provider = mux.Vars(r)["provider"]
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	req, _ := http.NewRequest("POST", "/system/functions", bytes.NewBuffer([]byte(echoDeploy)))
+	rr := httptest.NewRecorder()
+	MakeDeployHandler(proxyFunc, providerLookup).ServeHTTP(rr, req)
+
+	if provider != "faas-provider-a" {
+		t.Errorf("want the deployment proxied to faas-provider-a, got %q", provider)
+	}
+
+	if _, ok := providerLookup.GetFunction("echo-a"); ok {
+		t.Error("want a failed deployment not to be cached")
+	}
+}
+
+func Test_Deploy_UnreadableBodyIsRejected(t *testing.T) {
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082", nil, "http://faas-provider-a:8082"), routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxyFunc := func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a deployment which could not be read should not be proxied")
+	}
+
+	body := io.MultiReader(strings.NewReader(`{"service":"echo-a"}`), iotest.ErrReader(errors.New("connection reset")))
+	req, _ := http.NewRequest("POST", "/system/functions", body)
+	rr := httptest.NewRecorder()
+	MakeDeployHandler(proxyFunc, providerLookup).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("want status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+	if _, ok := providerLookup.GetFunction("echo-a"); ok {
+		t.Error("want a deployment which could not be read not to be cached")
+	}
+}
+
 const echoDeploy = `{"service":"echo-a","image":"openfaas/echo:latest","network":"","envProcess":"./handler","envVars":{},"constraints":null,"secrets":[],"labels":{},"annotations":{},"limits":null,"requests":null,"readOnlyRootFilesystem":false}`
+
+const echoDeployWithSelector = `{"service":"echo-a","image":"openfaas/echo:latest","annotations":{"com.openfaas.federation.selector":"region=us-east"}}`
@@ -0,0 +1,121 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// eventsKeepAlive is the interval between comments sent to keep an idle stream open
+	eventsKeepAlive = 15 * time.Second
+	// eventResync is sent when events after the Last-Event-ID of the caller are no longer
+	// kept, the caller should list the functions again
+	eventResync = "resync"
+)
+
+// MakeEventsHandler streams the changes to the cached functions and to the health of the
+// providers as server-sent events. A caller which reconnects with the Last-Event-ID header,
+// or the lastEventId query parameter, is sent the events it missed. The stream is ended
+// before streamTimeout, so that the caller reconnects before the server's write timeout
+func MakeEventsHandler(providerLookup routing.FunctionCache, streamTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("streaming is not supported"))
+			return
+		}
+
+		events := providerLookup.Events()
+		notify, unsubscribe := events.Subscribe()
+		defer unsubscribe()
+
+		lastID := events.LastID()
+		if v := lastEventID(r); len(v) > 0 {
+			id, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(fmt.Sprintf("invalid Last-Event-ID %q", v)))
+				return
+			}
+			lastID = id
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		var timeout <-chan time.Time
+		if streamTimeout > 0 {
+			timer := time.NewTimer(streamTimeout - streamTimeout/10)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		keepAlive := time.NewTicker(eventsKeepAlive)
+		defer keepAlive.Stop()
+
+		for {
+			pending, complete := events.Since(lastID)
+			if !complete {
+				// the caller lists the functions again, which covers the events it missed
+				fmt.Fprintf(w, "event: %s\ndata: {}\n\n", eventResync)
+				if len(pending) == 0 {
+					lastID = events.LastID()
+				}
+			}
+
+			for _, e := range pending {
+				if err := writeEvent(w, e); err != nil {
+					log.Debugf("event stream closed by the caller. %v", err)
+					return
+				}
+				lastID = e.ID
+			}
+			flusher.Flush()
+
+			select {
+			case <-notify:
+			case <-keepAlive.C:
+				if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-timeout:
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// lastEventID returns the ID of the last event received by a caller which reconnects
+func lastEventID(r *http.Request) string {
+	if v := r.Header.Get("Last-Event-ID"); len(v) > 0 {
+		return v
+	}
+
+	return r.URL.Query().Get("lastEventId")
+}
+
+func writeEvent(w http.ResponseWriter, e routing.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
@@ -0,0 +1,99 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+// readEvents reads count events from a server-sent event stream, returning the id and event
+// lines of each
+func readEvents(t *testing.T, reader *bufio.Reader, count int) []string {
+	var events []string
+	var current []string
+	for len(events) < count {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("want %d events, got %v with error %v", count, events, err)
+		}
+
+		line = strings.TrimSpace(line)
+		switch {
+		case len(line) == 0 && len(current) > 0:
+			events = append(events, strings.Join(current, " "))
+			current = nil
+		case strings.HasPrefix(line, "id:"), strings.HasPrefix(line, "event:"):
+			current = append(current, line)
+		}
+	}
+
+	return events
+}
+
+func Test_EventsHandler(t *testing.T) {
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082", nil, "http://faas-provider-a:8082"), routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(MakeEventsHandler(providerLookup, time.Minute))
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("want Content-Type text/event-stream, got %s", contentType)
+	}
+
+	start := providerLookup.Events().LastID()
+	providerLookup.AddFunction(&types.FunctionDeployment{Service: "echo"})
+	providerLookup.RemoveFunction("echo")
+
+	got := readEvents(t, bufio.NewReader(res.Body), 2)
+	want := []string{
+		fmt.Sprintf("id: %d event: function-added", start+1),
+		fmt.Sprintf("id: %d event: function-removed", start+2),
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("want events %v, got %v", want, got)
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        string
+	}{
+		{name: "resumed", lastEventID: fmt.Sprint(start + 1), want: fmt.Sprintf("id: %d event: function-removed", start+2)},
+		{name: "events no longer kept", lastEventID: "1", want: "event: resync"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+			req.Header.Set("Last-Event-ID", tt.lastEventID)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if got := readEvents(t, bufio.NewReader(res.Body), 1); got[0] != tt.want {
+				t.Errorf("want %s, got %s", tt.want, got[0])
+			}
+		})
+	}
+}
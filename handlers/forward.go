@@ -0,0 +1,114 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas/faas-provider/httputil"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultProviderPort is used when the provider URL has no port, as with proxy.NewHandlerFunc
+	defaultProviderPort = "8080"
+	defaultContentType  = "text/plain"
+)
+
+// forward sends the request to the provider at providerURL with client, it behaves as the
+// proxy built by proxy.NewHandlerFunc but allows the client, and so the TLS config, to be
+// chosen for each provider. The `name` and `params` path variables give the function and path
+func forward(client *http.Client, providerURL url.URL, w http.ResponseWriter, originalReq *http.Request) {
+	if originalReq.Body != nil {
+		defer originalReq.Body.Close()
+	}
+
+	switch originalReq.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodGet:
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	pathVars := mux.Vars(originalReq)
+	functionName := pathVars["name"]
+	if functionName == "" {
+		httputil.Errorf(w, http.StatusBadRequest, "Please provide a valid route /function/function_name.")
+		return
+	}
+
+	host := providerURL.Host
+	if providerURL.Port() == "" {
+		host = providerURL.Host + ":" + defaultProviderPort
+	}
+
+	upstreamURL := url.URL{
+		Scheme:   providerURL.Scheme,
+		Host:     host,
+		Path:     pathVars["params"],
+		RawQuery: originalReq.URL.RawQuery,
+	}
+
+	upstreamReq, err := http.NewRequest(originalReq.Method, upstreamURL.String(), nil)
+	if err != nil {
+		httputil.Errorf(w, http.StatusInternalServerError, "Failed to resolve service: %s.", functionName)
+		return
+	}
+
+	copyHeaders(upstreamReq.Header, originalReq.Header)
+	if len(originalReq.Host) > 0 && upstreamReq.Header.Get("X-Forwarded-Host") == "" {
+		upstreamReq.Header["X-Forwarded-Host"] = []string{originalReq.Host}
+	}
+	if upstreamReq.Header.Get("X-Forwarded-For") == "" {
+		upstreamReq.Header["X-Forwarded-For"] = []string{originalReq.RemoteAddr}
+	}
+
+	if originalReq.Body != nil {
+		upstreamReq.Body = originalReq.Body
+		upstreamReq.ContentLength = originalReq.ContentLength
+	}
+
+	start := time.Now()
+	response, err := client.Do(upstreamReq.WithContext(originalReq.Context()))
+	if err != nil {
+		log.Errorf("error with proxy request to: %s, %s", upstreamReq.URL.String(), err.Error())
+		httputil.Errorf(w, http.StatusInternalServerError, "%s %s.", unreachableMessage, functionName)
+		return
+	}
+	defer response.Body.Close()
+
+	log.Infof("%s took %f seconds", functionName, time.Since(start).Seconds())
+
+	copyHeaders(w.Header(), response.Header)
+	w.Header().Set("Content-Type", contentType(response.Header, originalReq.Header))
+
+	w.WriteHeader(response.StatusCode)
+	io.Copy(w, response.Body)
+}
+
+// copyHeaders clones the header values from the source into the destination
+func copyHeaders(destination http.Header, source http.Header) {
+	for k, v := range source {
+		vClone := make([]string, len(v))
+		copy(vClone, v)
+		destination[k] = vClone
+	}
+}
+
+// contentType prefers the Content-Type of the response, then that of the request
+func contentType(response http.Header, request http.Header) string {
+	if v := response.Get("Content-Type"); len(v) > 0 {
+		return v
+	}
+
+	if v := request.Get("Content-Type"); len(v) > 0 {
+		return v
+	}
+
+	return defaultContentType
+}
@@ -0,0 +1,22 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"net/url"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+)
+
+// testProviders names each provider by the host name of its URL
+func testProviders(defaultURL string, labels map[string]map[string]string, urls ...string) []routing.Provider {
+	var providers []routing.Provider
+	for _, v := range urls {
+		u, _ := url.Parse(v)
+		name := u.Hostname()
+		providers = append(providers, routing.Provider{Name: name, URL: v, Labels: labels[name], Default: v == defaultURL})
+	}
+
+	return providers
+}
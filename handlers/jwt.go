@@ -0,0 +1,385 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// jwksRefreshInterval is the minimum time between fetches of the JWKS for an unknown key id
+	jwksRefreshInterval = 30 * time.Second
+	// jwtLeeway allowed for clock skew when checking exp and nbf
+	jwtLeeway = time.Minute
+)
+
+// JWTConfig configures the validation of bearer tokens
+type JWTConfig struct {
+	// JWKS is a path or http(s) URL of the JSON Web Key Set used to verify tokens
+	JWKS string
+	// Issuer the iss claim must match, not checked when empty
+	Issuer string
+	// Audience the aud claim must contain, not checked when empty
+	Audience string
+}
+
+// JWTValidator verifies the signature and claims of a JWT against the keys of a JWKS, the
+// JWKS is fetched again when a token is signed with an unknown key
+type JWTValidator struct {
+	config  JWTConfig
+	client  *http.Client
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	lock    sync.Mutex
+}
+
+// NewJWTValidator creates a JWTValidator, the JWKS is loaded straight away
+func NewJWTValidator(config JWTConfig) (*JWTValidator, error) {
+	v := &JWTValidator{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	keys, err := v.loadKeys()
+	if err != nil {
+		return nil, err
+	}
+	v.keys = keys
+	v.fetched = time.Now()
+
+	return v, nil
+}
+
+// Validate verifies the token and returns its claims
+func (v *JWTValidator) Validate(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header. %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature. %v", err)
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims. %v", err)
+	}
+
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *JWTValidator) checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("token has no expiry")
+	}
+
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return fmt.Errorf("token has expired")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("token is not valid yet")
+	}
+
+	if len(v.config.Issuer) > 0 && claims["iss"] != v.config.Issuer {
+		return fmt.Errorf("token issuer %v is not trusted", claims["iss"])
+	}
+
+	if len(v.config.Audience) > 0 && !containsClaim(claims["aud"], v.config.Audience) {
+		return fmt.Errorf("token audience %v does not include %s", claims["aud"], v.config.Audience)
+	}
+
+	return nil
+}
+
+// key returns the key with the given id, the JWKS is fetched again for an unknown id at
+// most once every jwksRefreshInterval so that rotated keys are picked up
+func (v *JWTValidator) key(kid string) (crypto.PublicKey, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(v.fetched) >= jwksRefreshInterval {
+		v.fetched = time.Now()
+		keys, err := v.loadKeys()
+		if err != nil {
+			log.Errorf("unable to refresh JWKS %s, error: %v", v.config.JWKS, err)
+		} else {
+			v.keys = keys
+		}
+	}
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("token is signed with unknown key %q", kid)
+}
+
+func (v *JWTValidator) loadKeys() (map[string]crypto.PublicKey, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(v.config.JWKS, "http://") || strings.HasPrefix(v.config.JWKS, "https://") {
+		data, err = v.fetch(v.config.JWKS)
+	} else {
+		data, err = ioutil.ReadFile(v.config.JWKS)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read JWKS %s, error: %v", v.config.JWKS, err)
+	}
+
+	return parseJWKS(data)
+}
+
+func (v *JWTValidator) fetch(jwksURL string) ([]byte, error) {
+	res, err := v.client.Get(jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// jsonWebKey is a single RSA or EC public key of a JWKS
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("unable to parse JWKS. %v", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range jwks.Keys {
+		if len(k.Use) > 0 && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			log.Warnf("ignoring key %q of JWKS. %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys found in JWKS")
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// algorithmCurves is the curve of the key each ES* signing algorithm requires
+var algorithmCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// verifySignature checks an RS* or ES* signature, other algorithms such as none and HS* are rejected
+func verifySignature(alg string, key crypto.PublicKey, signed []byte, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not match signing algorithm %s", alg)
+		}
+
+		if err := rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature); err != nil {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	case strings.HasPrefix(alg, "ES"):
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key does not match signing algorithm %s", alg)
+		}
+
+		// each ES* algorithm is bound to one curve, so a token can not pick the hash of a key
+		if want := algorithmCurves[alg]; want == nil || ecKey.Curve.Params().Name != want.Params().Name {
+			return fmt.Errorf("key curve %s does not match signing algorithm %s", ecKey.Curve.Params().Name, alg)
+		}
+
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid token signature")
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported signing algorithm %q", alg)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func decodeBigInt(v string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key parameter. %v", err)
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
+
+// containsClaim returns true when a string or list claim contains want
+func containsClaim(claim interface{}, want string) bool {
+	for _, v := range claimValues(claim) {
+		if v == want {
+			return true
+		}
+	}
+
+	return false
+}
+
+// claimValues returns a list claim, or a space separated string claim such as scope
+func claimValues(claim interface{}) []string {
+	switch c := claim.(type) {
+	case string:
+		return strings.Fields(c)
+	case []interface{}:
+		var result []string
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	logsPath = "/system/logs"
+	// maxLogMessageBytes is the longest log message read from a provider
+	maxLogMessageBytes = 1024 * 1024
+)
+
+// logStream is the open log stream of a single provider
+type logStream struct {
+	provider string
+	body     io.ReadCloser
+}
+
+// MakeLogHandler streams the logs of the function given by the name and namespace query
+// parameters from each provider it is deployed to. The newline delimited JSON messages of the providers are
+// multiplexed with a provider field added. The query, including follow, since and tail, is
+// sent to each provider, and their streams are cancelled when the caller disconnects. The
+// streams are not given the provider timeout, so a followed stream lasts until either side ends it
+func MakeLogHandler(providerLookup routing.ProviderLookup) http.HandlerFunc {
+	client := &http.Client{Transport: providerLookup.GetTransport()}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := r.URL.Query().Get("name")
+		if len(functionName) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("the name query parameter is required"))
+			return
+		}
+
+		functionName = routing.FunctionKey(functionName, r.URL.Query().Get("namespace"))
+		providers, err := resolveFunctionProviders(providerLookup, functionName)
+		if err != nil {
+			log.Errorf("can not resolve provider for %s. %v", functionName, err)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("Cannot find service: %s.", functionName)))
+			return
+		}
+		functionName = qualifyRequest(providerLookup, r, functionName)
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		streams, status, message := openLogStreams(ctx, client, providerLookup, providers, r.URL.RawQuery)
+		if len(streams) == 0 {
+			w.WriteHeader(status)
+			w.Write(message)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		for line := range multiplexLogs(ctx, streams) {
+			if _, err := w.Write(line); err != nil {
+				log.Debugf("log stream of %s closed by the caller. %v", functionName, err)
+				return
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// openLogStreams requests the logs from each provider, when no stream could be opened the
+// status and message of the first failure are returned for the caller
+func openLogStreams(ctx context.Context, client *http.Client, providerLookup routing.ProviderRegistry, providers map[string]*url.URL, query string) ([]logStream, int, []byte) {
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var requests []*http.Request
+	var requestNames []string
+	for _, name := range names {
+		u := *providers[name]
+		u.Path = logsPath
+		u.RawQuery = query
+
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			log.Errorf("unable to request logs from provider %s. %v", name, err)
+			continue
+		}
+
+		if err := authorize(providerLookup, providers[name], req); err != nil {
+			log.Errorln(err)
+			continue
+		}
+
+		requests = append(requests, req.WithContext(ctx))
+		requestNames = append(requestNames, name)
+	}
+
+	status := http.StatusBadGateway
+	message := []byte("unable to read logs from any provider")
+	failed := false
+
+	var streams []logStream
+	for _, res := range routing.DoWithClient(client, requests, len(requests)) {
+		name := requestNames[res.Index]
+		if res.Err != nil {
+			log.Errorf("unable to read logs from provider %s. %v", name, res.Err)
+			continue
+		}
+
+		if res.Response.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(res.Response.Body)
+			res.Response.Body.Close()
+			log.Errorf("unable to read logs from provider %s, status code: %d, error: %s", name, res.Response.StatusCode, string(bytes.TrimSpace(body)))
+
+			if !failed {
+				status, message = res.Response.StatusCode, body
+				failed = true
+			}
+			continue
+		}
+
+		streams = append(streams, logStream{provider: name, body: res.Response.Body})
+	}
+
+	return streams, status, message
+}
+
+// multiplexLogs reads each stream line by line and sends the messages with the provider
+// added, the channel is closed when every stream has ended or ctx is cancelled
+func multiplexLogs(ctx context.Context, streams []logStream) <-chan []byte {
+	lines := make(chan []byte)
+
+	wg := sync.WaitGroup{}
+	for _, s := range streams {
+		wg.Add(1)
+		go func(s logStream) {
+			defer wg.Done()
+			defer s.body.Close()
+
+			scanner := bufio.NewScanner(s.body)
+			scanner.Buffer(make([]byte, 64*1024), maxLogMessageBytes)
+			for scanner.Scan() {
+				if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+					continue
+				}
+
+				line, err := tagLogMessage(scanner.Bytes(), s.provider)
+				if err != nil {
+					log.Warnf("ignoring invalid log message from provider %s. %v", s.provider, err)
+					continue
+				}
+
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if err := scanner.Err(); err != nil && ctx.Err() == nil {
+				log.Warnf("log stream of provider %s ended. %v", s.provider, err)
+			}
+		}(s)
+	}
+
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+
+	return lines
+}
+
+// tagLogMessage adds the provider to a JSON log message, other fields are kept as they are
+func tagLogMessage(line []byte, provider string) ([]byte, error) {
+	message := map[string]interface{}{}
+	if err := json.Unmarshal(line, &message); err != nil {
+		return nil, err
+	}
+	message["provider"] = provider
+
+	tagged, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(tagged, '\n'), nil
+}
@@ -0,0 +1,173 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+func Test_LogHandler_Replicated(t *testing.T) {
+	queries := make(chan string, 2)
+	newProvider := func(instance string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			queries <- r.URL.RawQuery
+			for i := 0; i < 2; i++ {
+				fmt.Fprintf(w, `{"name": "echo", "instance": "%s", "text": "line %d"}`+"\n", instance, i)
+			}
+		}))
+	}
+	east := newProvider("echo-east")
+	defer east.Close()
+	west := newProvider("echo-west")
+	defer west.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{
+		{Name: "east", URL: east.URL, Default: true},
+		{Name: "west", URL: west.URL},
+	}, routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerLookup.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{"com.openfaas.federation.replicas-on": "east,west"}})
+
+	req, _ := http.NewRequest(http.MethodGet, "/system/logs?name=echo&tail=2&since=2019-01-01T00:00:00Z", nil)
+	rr := httptest.NewRecorder()
+	MakeLogHandler(providerLookup)(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	for i := 0; i < 2; i++ {
+		if q := <-queries; q != "name=echo&tail=2&since=2019-01-01T00:00:00Z" {
+			t.Errorf("want query sent to each provider, got %q", q)
+		}
+	}
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(rr.Body.String()), "\n") {
+		message := map[string]string{}
+		if err := json.Unmarshal([]byte(line), &message); err != nil {
+			t.Fatalf("want newline delimited JSON, got %q", line)
+		}
+		got = append(got, message["provider"]+" "+message["instance"]+" "+message["text"])
+	}
+	sort.Strings(got)
+
+	want := []string{"east echo-east line 0", "east echo-east line 1", "west echo-west line 0", "west echo-west line 1"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("want messages %v, got %v", want, got)
+	}
+}
+
+func Test_LogHandler_CancelsProviderStreams(t *testing.T) {
+	cancelled := make(chan struct{})
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"name": "echo", "text": "started"}`)
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer provider.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{{Name: "east", URL: provider.URL, Default: true}}, routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerLookup.AddFunction(&types.FunctionDeployment{Service: "echo"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest(http.MethodGet, "/system/logs?name=echo&follow=true", nil)
+
+	done := make(chan struct{})
+	go func() {
+		MakeLogHandler(providerLookup)(httptest.NewRecorder(), req.WithContext(ctx))
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want handler to return when the caller disconnects")
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want provider stream to be cancelled when the caller disconnects")
+	}
+}
+
+func Test_LogHandler_FollowOutlastsProviderTimeout(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(w, `{"name": "echo", "text": "line %d"}`+"\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer provider.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{
+		{Name: "east", URL: provider.URL, Default: true, Timeout: 100 * time.Millisecond},
+	}, routing.Config{Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerLookup.AddFunction(&types.FunctionDeployment{Service: "echo"})
+
+	req, _ := http.NewRequest(http.MethodGet, "/system/logs?name=echo&follow=true", nil)
+	rr := httptest.NewRecorder()
+	MakeLogHandler(providerLookup)(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n"); len(lines) != 5 {
+		t.Errorf("want every message of a stream longer than the provider timeout, got %d: %v", len(lines), lines)
+	}
+}
+
+func Test_LogHandler_ProviderError(t *testing.T) {
+	provider := providertest.New(http.StatusOK)
+	provider.Handle("/system/logs", http.StatusNotImplemented, "logs are not supported")
+	defer provider.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{{Name: "east", URL: provider.URL, Default: true}}, routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerLookup.AddFunction(&types.FunctionDeployment{Service: "echo"})
+
+	req, _ := http.NewRequest(http.MethodGet, "/system/logs?name=echo", nil)
+	rr := httptest.NewRecorder()
+	MakeLogHandler(providerLookup)(rr, req)
+
+	if rr.Code != http.StatusNotImplemented || rr.Body.String() != "logs are not supported" {
+		t.Errorf("want the provider's error, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/system/logs", nil)
+	rr = httptest.NewRecorder()
+	MakeLogHandler(providerLookup)(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("want status 400 without a name, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	log "github.com/sirupsen/logrus"
+)
+
+const namespacesPath = "/system/namespaces"
+
+// MakeNamespaceHandler lists the namespaces of every provider merged with the namespaces
+// mapped to providers in the federation's config, a provider whose namespaces can not be
+// listed is left out
+func MakeNamespaceHandler(providerLookup routing.ProviderRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		providers := providerLookup.ListProviders()
+
+		found := map[string]bool{}
+		for _, p := range providers {
+			for _, ns := range p.Namespaces {
+				found[ns] = true
+			}
+		}
+
+		var names []string
+		var requests []*http.Request
+		providerURLs := providerLookup.GetProviders()
+		for _, p := range providers {
+			providerURL := providerURLs[p.Name]
+			u := *providerURL
+			u.Path = namespacesPath
+			req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+			if err := authorize(providerLookup, providerURL, req); err != nil {
+				log.Warnf("unable to list namespaces of provider %s. %v", p.Name, err)
+				continue
+			}
+
+			names = append(names, p.Name)
+			requests = append(requests, req.WithContext(r.Context()))
+		}
+
+		for _, res := range routing.DoWithClient(providerLookup.GetClient(), requests, len(requests)) {
+			namespaces, err := readNamespaces(res)
+			if err != nil {
+				log.Warnf("unable to list namespaces of provider %s. %v", names[res.Index], err)
+				continue
+			}
+
+			for _, ns := range namespaces {
+				found[ns] = true
+			}
+		}
+
+		result := []string{}
+		for ns := range found {
+			result = append(result, ns)
+		}
+		sort.Strings(result)
+
+		namespaceBytes, _ := json.Marshal(result)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(namespaceBytes)
+	}
+}
+
+func readNamespaces(res routing.Result) ([]string, error) {
+	if res.Err != nil {
+		return nil, res.Err
+	}
+
+	body, _ := ioutil.ReadAll(res.Response.Body)
+	res.Response.Body.Close()
+	if res.Response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", res.Response.StatusCode)
+	}
+
+	var namespaces []string
+	if err := json.Unmarshal(body, &namespaces); err != nil {
+		return nil, err
+	}
+
+	return namespaces, nil
+}
@@ -0,0 +1,110 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+// newNamespacesProvider lists the namespaces, which are not supported when empty, and the
+// function in each namespace
+func newNamespacesProvider(namespaces string, functions map[string]string) *providertest.Provider {
+	p := providertest.New(http.StatusNotFound)
+	if len(namespaces) > 0 {
+		p.Handle("/system/namespaces", http.StatusOK, namespaces)
+	}
+	p.HandleFunc("/system/functions", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(providertest.Functions(functions[r.URL.Query().Get("namespace")])))
+	})
+
+	return p
+}
+
+func Test_NamespaceHandler(t *testing.T) {
+	east := newNamespacesProvider(`["openfaas-fn", "team-a"]`, nil)
+	defer east.Close()
+	west := newNamespacesProvider(`["openfaas-fn", "team-b"]`, nil)
+	defer west.Close()
+	swarm := newNamespacesProvider("", nil)
+	defer swarm.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{
+		{Name: "east", URL: east.URL, Default: true},
+		{Name: "west", URL: west.URL},
+		{Name: "swarm", URL: swarm.URL, Namespaces: []string{"team-c"}},
+	}, routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/system/namespaces", nil)
+	rr := httptest.NewRecorder()
+	MakeNamespaceHandler(providerLookup)(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var got []string
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	want := []string{"openfaas-fn", "team-a", "team-b", "team-c"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("want namespaces %v, got %v", want, got)
+	}
+}
+
+func Test_FunctionReader_Namespace(t *testing.T) {
+	east := newNamespacesProvider("", map[string]string{"": "echo", "team-a": "echo-east"})
+	defer east.Close()
+	west := newNamespacesProvider("", map[string]string{"": "cat", "team-a": "echo-west"})
+	defer west.Close()
+
+	tests := []struct {
+		name       string
+		namespaces []string
+		query      string
+		want       []string
+	}{
+		{name: "default namespace", namespaces: []string{"team-a"}, want: []string{"cat", "echo"}},
+		{name: "mapped namespace", namespaces: []string{"team-a"}, query: "?namespace=team-a", want: []string{"echo-west"}},
+		{name: "namespace which is not mapped", query: "?namespace=team-a", want: []string{"echo-east", "echo-west"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{
+				{Name: "east", URL: east.URL, Default: true},
+				{Name: "west", URL: west.URL, Namespaces: tt.namespaces},
+			}, routing.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, "/system/functions"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			MakeFunctionReader(providerLookup)(rr, req)
+
+			var functions []types.FunctionStatus
+			json.Unmarshal(rr.Body.Bytes(), &functions)
+			var got []string
+			for _, f := range functions {
+				got = append(got, f.Name)
+			}
+			sort.Strings(got)
+
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("want functions %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
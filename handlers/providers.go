@@ -0,0 +1,242 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	log "github.com/sirupsen/logrus"
+)
+
+// ProviderSummary is the federation's view of a single provider
+type ProviderSummary struct {
+	Name           string                       `json:"name"`
+	URL            string                       `json:"url"`
+	Labels         map[string]string            `json:"labels,omitempty"`
+	Timeout        string                       `json:"timeout,omitempty"`
+	Default        bool                         `json:"default,omitempty"`
+	Namespaces     []string                     `json:"namespaces,omitempty"`
+	Health         *routing.ProviderHealth      `json:"health,omitempty"`
+	CircuitBreaker routing.CircuitBreakerStatus `json:"circuitBreaker"`
+	Functions      int                          `json:"functions"`
+	LastRefresh    *time.Time                   `json:"lastRefresh,omitempty"`
+	LastListing    *routing.ProviderListing     `json:"lastListing,omitempty"`
+}
+
+// ProviderRequest registers or updates a provider, or names the provider to deregister
+type ProviderRequest struct {
+	Name        string               `json:"name"`
+	URL         string               `json:"url,omitempty"`
+	Labels      map[string]string    `json:"labels,omitempty"`
+	Timeout     string               `json:"timeout,omitempty"`
+	Credentials *routing.Credentials `json:"credentials,omitempty"`
+	TLS         *routing.TLSConfig   `json:"tls,omitempty"`
+	Default     bool                 `json:"default,omitempty"`
+	Namespaces  []string             `json:"namespaces,omitempty"`
+}
+
+// MakeProvidersHandler lists, registers, updates and deregisters the providers of the federation.
+// The credential and TLS files of a provider registered or updated through the API must be
+// inside credentialsDir, an empty credentialsDir rejects them
+func MakeProvidersHandler(providerLookup routing.ProviderLookup, credentialsDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			defer r.Body.Close()
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			listProviders(providerLookup, w, r)
+		case http.MethodPost, http.MethodPut, http.MethodDelete:
+			changeProvider(providerLookup, credentialsDir, w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func listProviders(providerLookup routing.ProviderLookup, w http.ResponseWriter, r *http.Request) {
+	log.Info("providers request")
+
+	providers := providerLookup.GetProviders()
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := []ProviderSummary{}
+	for _, name := range names {
+		result = append(result, summarise(providerLookup, name, providers[name]))
+	}
+
+	resultBytes, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resultBytes)
+}
+
+func summarise(providerLookup routing.ProviderLookup, name string, providerURL *url.URL) ProviderSummary {
+	summary := ProviderSummary{
+		Name:           name,
+		URL:            providerURL.String(),
+		CircuitBreaker: providerLookup.GetCircuitBreaker(providerURL).Status(),
+	}
+
+	if p, ok := providerLookup.GetProvider(name); ok {
+		summary.Labels = p.Labels
+		summary.Default = p.Default
+		summary.Namespaces = p.Namespaces
+		if p.Timeout > 0 {
+			summary.Timeout = p.Timeout.String()
+		}
+	}
+
+	if h, ok := providerLookup.GetProviderHealth(name); ok {
+		summary.Health = &h
+	}
+
+	stats := providerLookup.GetProviderStats(name)
+	summary.Functions = stats.Functions
+	summary.LastRefresh = stats.LastRefresh
+	summary.LastListing = stats.LastListing
+
+	return summary
+}
+
+func changeProvider(providerLookup routing.ProviderLookup, credentialsDir string, w http.ResponseWriter, r *http.Request) {
+	log.Infof("provider %s request", r.Method)
+
+	var body []byte
+	if r.Body != nil {
+		body, _ = ioutil.ReadAll(r.Body)
+	}
+
+	req := ProviderRequest{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if len(req.Name) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("provider name is required"))
+		return
+	}
+
+	_, exists := providerLookup.GetProvider(req.Name)
+	switch {
+	case r.Method == http.MethodPost && exists:
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(fmt.Sprintf("provider %s already exists", req.Name)))
+		return
+	case r.Method != http.MethodPost && !exists:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(fmt.Sprintf("provider %s does not exist", req.Name)))
+		return
+	}
+
+	var err error
+	if r.Method == http.MethodDelete {
+		err = providerLookup.RemoveProvider(req.Name)
+	} else {
+		var p routing.Provider
+		p, err = req.toProvider(credentialsDir)
+		if err == nil && r.Method == http.MethodPost {
+			err = providerLookup.AddProvider(p)
+		} else if err == nil {
+			err = providerLookup.UpdateProvider(p)
+		}
+	}
+
+	if err != nil {
+		log.Errorf("provider %s request for %s failed, error: %v", r.Method, req.Name, err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// read the new provider's functions so that they can be invoked straight away
+	if err := providerLookup.ReloadCache(); err != nil {
+		log.Warnf("could not reload cache after %s of provider %s. %v", r.Method, req.Name, err)
+	}
+
+	summary := summarise(providerLookup, req.Name, providerLookup.GetProviders()[req.Name])
+	resultBytes, _ := json.Marshal(summary)
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.Write(resultBytes)
+}
+
+func (req ProviderRequest) toProvider(credentialsDir string) (routing.Provider, error) {
+	var files [][2]string
+	if req.Credentials != nil {
+		files = append(files, [2]string{"credentials.secretMountPath", req.Credentials.SecretMountPath}, [2]string{"credentials.tokenFile", req.Credentials.TokenFile})
+	}
+	if req.TLS != nil {
+		files = append(files, [2]string{"tls.caFile", req.TLS.CAFile}, [2]string{"tls.certFile", req.TLS.CertFile}, [2]string{"tls.keyFile", req.TLS.KeyFile})
+	}
+	for _, f := range files {
+		if err := checkCredentialsPath(credentialsDir, f[0], f[1]); err != nil {
+			return routing.Provider{}, err
+		}
+	}
+
+	p := routing.Provider{
+		Name:        req.Name,
+		URL:         req.URL,
+		Labels:      req.Labels,
+		Credentials: req.Credentials,
+		TLS:         req.TLS,
+		Default:     req.Default,
+		Namespaces:  req.Namespaces,
+	}
+
+	if len(req.Timeout) > 0 {
+		timeout, err := time.ParseDuration(req.Timeout)
+		if err != nil || timeout < 0 {
+			return p, fmt.Errorf("invalid timeout %q, expected a duration such as 30s", req.Timeout)
+		}
+		p.Timeout = timeout
+	}
+
+	return p, nil
+}
+
+// checkCredentialsPath returns an error unless path is empty or inside credentialsDir
+func checkCredentialsPath(credentialsDir string, field string, path string) error {
+	if len(path) == 0 {
+		return nil
+	}
+
+	if len(credentialsDir) == 0 {
+		return fmt.Errorf("%s can not be set through the API unless provider_credentials_dir is configured", field)
+	}
+
+	dir := filepath.Clean(credentialsDir)
+	if !filepath.IsAbs(path) || !strings.HasPrefix(filepath.Clean(path), dir+string(filepath.Separator)) {
+		return fmt.Errorf("%s %q must be inside %s", field, path, dir)
+	}
+
+	return nil
+}
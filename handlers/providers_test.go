@@ -0,0 +1,154 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+)
+
+func Test_Providers(t *testing.T) {
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082", nil, "http://faas-provider-a:8082", "http://faas-provider-b:8083"),
+		routing.Config{CircuitBreaker: routing.CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providerLookup.UpdateProviderHealth(routing.ProviderHealth{Name: "faas-provider-a", Status: routing.ProviderStatusUp})
+	providerLookup.GetCircuitBreaker(providerLookup.GetProviders()["faas-provider-b"]).Record(false)
+
+	req, err := http.NewRequest("GET", "/system/federation/providers", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	MakeProvidersHandler(providerLookup, "").ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var got []ProviderSummary
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 || got[0].Name != "faas-provider-a" || got[1].Name != "faas-provider-b" {
+		t.Fatalf("want both providers sorted by name, got %v", got)
+	}
+
+	if got[0].Health == nil || got[0].Health.Status != routing.ProviderStatusUp {
+		t.Errorf("want faas-provider-a to be up, got %v", got[0].Health)
+	}
+
+	if got[1].CircuitBreaker.State != routing.CircuitOpen {
+		t.Errorf("want faas-provider-b circuit %s, got %s", routing.CircuitOpen, got[1].CircuitBreaker.State)
+	}
+}
+
+func Test_Providers_Register(t *testing.T) {
+	providerA := providertest.New(http.StatusOK)
+	defer providerA.Close()
+	providerB := providertest.New(http.StatusOK)
+	defer providerB.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders(providerA.HostURL("127.0.0.1"), nil, providerA.HostURL("127.0.0.1")), routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{name: "register", method: http.MethodPost, body: `{"name": "edge", "url": "` + providerB.HostURL("localhost") + `", "labels": {"region": "eu-west"}}`, wantStatus: http.StatusCreated},
+		{name: "register existing", method: http.MethodPost, body: `{"name": "edge", "url": "` + providerB.HostURL("localhost") + `"}`, wantStatus: http.StatusConflict},
+		{name: "register without URL", method: http.MethodPost, body: `{"name": "cloud"}`, wantStatus: http.StatusBadRequest},
+		{name: "update", method: http.MethodPut, body: `{"name": "edge", "url": "` + providerB.HostURL("localhost") + `", "timeout": "30s", "labels": {"region": "us-east"}}`, wantStatus: http.StatusOK},
+		{name: "update invalid timeout", method: http.MethodPut, body: `{"name": "edge", "url": "` + providerB.HostURL("localhost") + `", "timeout": "soon"}`, wantStatus: http.StatusBadRequest},
+		{name: "update missing", method: http.MethodPut, body: `{"name": "cloud", "url": "http://cloud:8080"}`, wantStatus: http.StatusNotFound},
+		{name: "deregister default", method: http.MethodDelete, body: `{"name": "127.0.0.1"}`, wantStatus: http.StatusBadRequest},
+		{name: "deregister", method: http.MethodDelete, body: `{"name": "edge"}`, wantStatus: http.StatusOK},
+		{name: "deregister missing", method: http.MethodDelete, body: `{"name": "edge"}`, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "/system/federation/providers", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		MakeProvidersHandler(providerLookup, "").ServeHTTP(rr, req)
+		if status := rr.Code; status != tt.wantStatus {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v, body: %s", tt.name, status, tt.wantStatus, rr.Body.String())
+		}
+
+		switch tt.name {
+		case "register":
+			if _, ok := providerLookup.GetProviders()["edge"]; !ok {
+				t.Fatalf("%s: want edge to be a provider", tt.name)
+			}
+		case "update":
+			got := ProviderSummary{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Labels["region"] != "us-east" || got.Timeout != "30s" {
+				t.Errorf("%s: want updated labels and timeout, got %+v", tt.name, got)
+			}
+		case "deregister":
+			if _, ok := providerLookup.GetProviders()["edge"]; ok {
+				t.Fatalf("%s: want edge to be removed", tt.name)
+			}
+		}
+	}
+}
+
+func Test_Providers_CredentialsDir(t *testing.T) {
+	tests := []struct {
+		name           string
+		credentialsDir string
+		body           string
+		wantStatus     int
+	}{
+		{name: "no files", body: `{"name": "edge", "url": "http://edge:8080"}`, wantStatus: http.StatusCreated},
+		{name: "files without a credentials dir", body: `{"name": "edge", "url": "http://edge:8080", "credentials": {"tokenFile": "/var/secrets/edge/token"}}`, wantStatus: http.StatusBadRequest},
+		{name: "token inside the credentials dir", credentialsDir: "/var/secrets/", body: `{"name": "edge", "url": "http://edge:8080", "credentials": {"tokenFile": "/var/secrets/edge/token"}}`, wantStatus: http.StatusCreated},
+		{name: "token outside the credentials dir", credentialsDir: "/var/secrets", body: `{"name": "edge", "url": "http://edge:8080", "credentials": {"tokenFile": "/etc/shadow"}}`, wantStatus: http.StatusBadRequest},
+		{name: "token escaping the credentials dir", credentialsDir: "/var/secrets", body: `{"name": "edge", "url": "http://edge:8080", "credentials": {"tokenFile": "/var/secrets/../../etc/shadow"}}`, wantStatus: http.StatusBadRequest},
+		{name: "relative token", credentialsDir: "/var/secrets", body: `{"name": "edge", "url": "http://edge:8080", "credentials": {"tokenFile": "edge/token"}}`, wantStatus: http.StatusBadRequest},
+		{name: "sibling of the credentials dir", credentialsDir: "/var/secrets", body: `{"name": "edge", "url": "http://edge:8080", "credentials": {"secretMountPath": "/var/secrets-other"}}`, wantStatus: http.StatusBadRequest},
+		{name: "key outside the credentials dir", credentialsDir: "/var/secrets", body: `{"name": "edge", "url": "https://edge:8080", "tls": {"caFile": "/var/secrets/edge/ca.pem", "keyFile": "/root/key.pem"}}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082", nil, "http://faas-provider-a:8082"), routing.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, _ := http.NewRequest(http.MethodPost, "/system/federation/providers", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			MakeProvidersHandler(providerLookup, tt.credentialsDir).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if _, ok := providerLookup.GetProvider("edge"); ok != (tt.wantStatus == http.StatusCreated) {
+				t.Errorf("want edge registered %t, got %t", tt.wantStatus == http.StatusCreated, ok)
+			}
+		})
+	}
+}
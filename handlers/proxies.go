@@ -0,0 +1,59 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// unreachableMessage prefixes the error written by forward when the provider
+// could not be reached or timed out, as opposed to an error returned by the function
+const unreachableMessage = "Can't reach service for:"
+
+// providerProxies forwards requests to a single provider, so that the provider serving an
+// invocation is known to the caller. One client is kept for each timeout
+type providerProxies struct {
+	timeout   time.Duration
+	transport http.RoundTripper
+	clients   map[time.Duration]*http.Client
+	lock      sync.Mutex
+}
+
+// newProviderProxies creates providerProxies which send requests with transport, so that
+// the TLS config of each provider is used
+func newProviderProxies(timeout time.Duration, transport http.RoundTripper) *providerProxies {
+	return &providerProxies{
+		timeout:   timeout,
+		transport: transport,
+		clients:   map[time.Duration]*http.Client{},
+	}
+}
+
+// get returns the proxy for the provider, timeout overrides the default timeout when set
+func (p *providerProxies) get(providerURL *url.URL, timeout time.Duration) http.HandlerFunc {
+	if timeout <= 0 {
+		timeout = p.timeout
+	}
+
+	p.lock.Lock()
+	client, ok := p.clients[timeout]
+	if !ok {
+		client = &http.Client{
+			Transport: p.transport,
+			Timeout:   timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		p.clients[timeout] = client
+	}
+	p.lock.Unlock()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		forward(client, *providerURL, w, r)
+	}
+}
@@ -1,25 +1,32 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 
+	"github.com/openfaas-incubator/faas-federation/metrics"
 	"github.com/openfaas-incubator/faas-federation/routing"
 	log "github.com/sirupsen/logrus"
 )
 
-const urlScheme = "http"
+// MakeProxyHandler creates a handler to invoke functions downstream, the provider is picked
+// per invocation and its circuit breaker short-circuits invocations while it is failing.
+// Functions which opt in are retried against an alternate provider after a failure
+func MakeProxyHandler(providerLookup routing.ProviderLookup, timeout time.Duration, retryConfig RetryConfig) http.HandlerFunc {
+	proxies := newProviderProxies(timeout, providerLookup.GetTransport())
+	budget := newRetryBudget(retryConfig.BudgetRatio)
 
-// MakeProxyHandler creates a handler to invoke functions downstream
-func MakeProxyHandler(proxy http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		log.Info("proxy request")
@@ -33,27 +40,144 @@ func MakeProxyHandler(proxy http.HandlerFunc) http.HandlerFunc {
 		functionName := strings.Split(r.URL.Path, "/")[2]
 		pathVars["name"] = functionName
 		pathVars["params"] = r.URL.Path
-		proxy.ServeHTTP(w, r)
+
+		providerURL, err := providerLookup.ResolveWeighted(functionName)
+		if err != nil {
+			log.Errorf("resolver error: cannot find %s. %v", functionName, err)
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("Cannot find service: %s.", functionName)))
+			return
+		}
+
+		f, ok := providerLookup.GetFunction(functionName)
+		if ok && routing.FunctionKey(f.Service, f.Namespace) != functionName {
+			// the function was named without its namespace, so invoke it by its qualified name
+			functionName = routing.FunctionKey(f.Service, f.Namespace)
+			pathVars["name"] = functionName
+			pathVars["params"] = invocationPath(r.URL.Path, functionName)
+		}
+
+		candidates := []*url.URL{providerURL}
+		if retryConfig.MaxAttempts > 1 && routing.IsRetryable(f) {
+			candidates = retryCandidates(providerLookup, functionName, providerURL, retryConfig.MaxAttempts)
+		}
+
+		var body []byte
+		if len(candidates) > 1 {
+			var buffered bool
+			if body, buffered = bufferBody(r, retryConfig.MaxBodyBytes); !buffered {
+				log.Infof("request body for %s is too large to retry", functionName)
+				candidates = candidates[:1]
+			}
+		}
+
+		budget.deposit()
+		var previous *attemptResponseWriter
+		for i, candidate := range candidates {
+			last := i == len(candidates)-1
+			if previous != nil && !budget.withdraw() {
+				log.Warnf("retry budget exhausted, not retrying %s", functionName)
+				break
+			}
+
+			breaker := providerLookup.GetCircuitBreaker(candidate)
+			if !breaker.Allow() {
+				if !last {
+					continue
+				}
+
+				if previous != nil {
+					break
+				}
+
+				log.Warnf("circuit breaker open for provider %s, rejecting invocation of %s", candidate.String(), functionName)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(fmt.Sprintf("Circuit breaker open for the provider of: %s.", functionName)))
+				return
+			}
+
+			if body != nil {
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			}
+
+			attempt := newAttemptResponseWriter(w, len(candidates) > 1 && !last, retryConfig.MaxBodyBytes)
+			provider, _ := providerLookup.GetProvider(providerLookup.ProviderName(candidate))
+			start := time.Now()
+			proxies.get(candidate, provider.Timeout).ServeHTTP(attempt, r)
+			breaker.Record(!attempt.failed)
+			recordInvocation(functionName, provider.Name, attempt.status, time.Since(start))
+
+			if !attempt.shouldRetry() {
+				previous = nil
+				break
+			}
+
+			log.Warnf("invocation of %s failed on provider %s with status %d, retrying", functionName, candidate.String(), attempt.status)
+			previous = attempt
+		}
+
+		if previous != nil {
+			previous.flush()
+		}
 
 		log.Infof("proxy request for function %s path %s", functionName, r.URL.String())
 	}
 }
 
+// invocationPath replaces the function name of an invocation path such as /function/echo/path
+func invocationPath(path string, functionName string) string {
+	parts := strings.SplitN(path, "/", 4)
+	parts[2] = functionName
+
+	return strings.Join(parts, "/")
+}
+
+// recordInvocation records the status code and duration of an invocation proxied to a provider
+func recordInvocation(functionName string, provider string, status int, duration time.Duration) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	metrics.Invocations.Inc(functionName, provider, strconv.Itoa(status))
+	metrics.InvocationDuration.Observe(duration.Seconds(), functionName, provider)
+}
+
+// retryCandidates returns the resolved provider followed by the alternates of the function,
+// up to maxAttempts providers in total
+func retryCandidates(providerLookup routing.FunctionResolver, functionName string, providerURL *url.URL, maxAttempts int) []*url.URL {
+	candidates := []*url.URL{providerURL}
+
+	alternates, err := providerLookup.ResolveAlternates(functionName)
+	if err != nil {
+		log.Warnf("can not resolve alternate providers for %s. %v", functionName, err)
+		return candidates
+	}
+
+	for _, u := range alternates {
+		if len(candidates) == maxAttempts {
+			break
+		}
+
+		if u.String() != providerURL.String() {
+			candidates = append(candidates, u)
+		}
+	}
+
+	return candidates
+}
+
 // FunctionLookup is a openfaas-provider proxy.BaseURLResolver that allows the
 // caller to verify that a function is resolvable.
 type FunctionLookup struct {
-	// scheme is the http scheme (http/https) used to proxy the request
-	scheme string
 	// dnsrrLookup method used to resolve the function IP address, defaults to the internal lookupIP
 	// method, which is an implementation of net.LookupIP
 	dnsrrLookup    func(context.Context, string) ([]net.IP, error)
-	providerLookup routing.ProviderLookup
+	providerLookup routing.FunctionResolver
 }
 
 // NewFunctionLookup creates a new FunctionLookup resolver
-func NewFunctionLookup(providerLookup routing.ProviderLookup) *FunctionLookup {
+func NewFunctionLookup(providerLookup routing.FunctionResolver) *FunctionLookup {
 	return &FunctionLookup{
-		scheme:         urlScheme,
 		dnsrrLookup:    lookupIP,
 		providerLookup: providerLookup,
 	}
@@ -10,7 +10,8 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/openfaas-incubator/faas-federation/routing"
 	acc "github.com/openfaas-incubator/faas-federation/testing"
-	"github.com/openfaas/faas-provider/proxy"
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+	types "github.com/openfaas/faas-provider/types"
 )
 
 func Test_Invoke(t *testing.T) {
@@ -24,15 +25,156 @@ func Test_Invoke(t *testing.T) {
 	mux.NewRouter()
 	rr := httptest.NewRecorder()
 
-	providerLookup, err := routing.NewDefaultProviderRouting([]string{"http://faas-provider-a:8082", "http://faas-provider-b:8083"}, "http://faas-provider-a:8082")
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082", nil, "http://faas-provider-a:8082", "http://faas-provider-b:8083"), routing.Config{})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	proxyFunc := proxy.NewHandlerFunc(time.Minute*1, NewFunctionLookup(providerLookup))
-	MakeProxyHandler(proxyFunc).ServeHTTP(rr, req)
+	MakeProxyHandler(providerLookup, time.Minute*1, RetryConfig{}).ServeHTTP(rr, req)
 	if status := rr.Code; status != http.StatusOK {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusOK)
 	}
 }
+
+func Test_Invoke_UnqualifiedName(t *testing.T) {
+	provider := providertest.New(http.StatusNotFound)
+	provider.Handle("/function/cat.team-a/path", http.StatusOK, "meow")
+	defer provider.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{
+		{Name: "faas-provider-a", URL: provider.URL, Default: true, Namespaces: []string{"team-a"}},
+	}, routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerLookup.AddFunction(&types.FunctionDeployment{Service: "cat", Namespace: "team-a"})
+
+	req, _ := http.NewRequest(http.MethodPost, "/function/cat/path", bytes.NewBufferString("Hello World"))
+	rr := httptest.NewRecorder()
+	MakeProxyHandler(providerLookup, time.Minute, RetryConfig{}).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Body.String() != "meow" {
+		t.Errorf("want the function in team-a to be invoked, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := provider.Received(); len(got) != 1 || got[0] != "POST /function/cat.team-a/path Hello World" {
+		t.Errorf("want the invocation sent to cat.team-a, got %v", got)
+	}
+}
+
+func Test_Invoke_CircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantStatus  int
+		wantHealthy int
+	}{
+		{
+			name:        "open circuit short-circuits invocations",
+			annotations: map[string]string{"com.openfaas.federation.gateway": "127.0.0.1"},
+			wantStatus:  http.StatusServiceUnavailable,
+		},
+		{
+			name: "open circuit falls back to the alternate provider",
+			annotations: map[string]string{
+				"com.openfaas.federation.gateway":          "127.0.0.1",
+				"com.openfaas.federation.fallback-gateway": "localhost",
+			},
+			wantStatus:  http.StatusOK,
+			wantHealthy: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing := providertest.New(http.StatusBadGateway)
+			defer failing.Close()
+			healthy := providertest.New(http.StatusOK)
+			defer healthy.Close()
+
+			providerLookup, err := routing.NewDefaultProviderRouting(testProviders(failing.HostURL("127.0.0.1"), nil, failing.HostURL("127.0.0.1"), healthy.HostURL("localhost")),
+				routing.Config{CircuitBreaker: routing.CircuitBreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			providerLookup.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &tt.annotations})
+			handler := MakeProxyHandler(providerLookup, time.Second*5, RetryConfig{})
+
+			for i := 0; i < 2; i++ {
+				rr := httptest.NewRecorder()
+				req, _ := http.NewRequest("POST", "/function/echo", nil)
+				handler.ServeHTTP(rr, req)
+				if rr.Code != http.StatusBadGateway {
+					t.Fatalf("want %d from the failing provider, got %d", http.StatusBadGateway, rr.Code)
+				}
+			}
+
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/function/echo", nil)
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want status %d once the circuit is open, got %d", tt.wantStatus, rr.Code)
+			}
+
+			if len(failing.Received()) != 2 {
+				t.Errorf("want the failing provider to receive 2 invocations, got %d", len(failing.Received()))
+			}
+
+			if len(healthy.Received()) != tt.wantHealthy {
+				t.Errorf("want the healthy provider to receive %d invocations, got %d", tt.wantHealthy, len(healthy.Received()))
+			}
+
+			u, _ := providerLookup.ResolveFunction(&types.FunctionDeployment{Service: "echo"})
+			if state := providerLookup.GetCircuitBreaker(u).Status().State; state != routing.CircuitOpen {
+				t.Errorf("want circuit %s, got %s", routing.CircuitOpen, state)
+			}
+		})
+	}
+}
+
+func Test_Invoke_FunctionErrorDoesNotOpenCircuit(t *testing.T) {
+	provider := providertest.New(http.StatusInternalServerError)
+	defer provider.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders(provider.HostURL("127.0.0.1"), nil, provider.HostURL("127.0.0.1")),
+		routing.Config{CircuitBreaker: routing.CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providerLookup.AddFunction(&types.FunctionDeployment{Service: "echo"})
+	handler := MakeProxyHandler(providerLookup, time.Second*5, RetryConfig{})
+
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/function/echo", nil)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusInternalServerError {
+			t.Fatalf("want the function's status %d, got %d", http.StatusInternalServerError, rr.Code)
+		}
+	}
+}
+
+func Test_Invoke_UnreachableProviderOpensCircuit(t *testing.T) {
+	provider := providertest.New(http.StatusOK)
+	providerURL := provider.HostURL("127.0.0.1")
+	provider.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders(providerURL, nil, providerURL),
+		routing.Config{CircuitBreaker: routing.CircuitBreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providerLookup.AddFunction(&types.FunctionDeployment{Service: "echo"})
+	handler := MakeProxyHandler(providerLookup, time.Second*5, RetryConfig{})
+
+	for _, want := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/function/echo", nil)
+		handler.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("want status %d, got %d", want, rr.Code)
+		}
+	}
+}
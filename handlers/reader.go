@@ -5,7 +5,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/openfaas-incubator/faas-federation/routing"
 
@@ -13,12 +16,32 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// failedProvidersHeader names the providers which could not be listed
+const failedProvidersHeader = "X-Federation-Failed-Providers"
+
+// FederatedFunctionStatus is the status of a function across the providers it is listed by
+type FederatedFunctionStatus struct {
+	types.FunctionStatus
+	// Providers lists the function, sorted by name
+	Providers []string `json:"providers"`
+}
+
 // MakeFunctionReader handler for reading functions deployed in the cluster as deployments.
-func MakeFunctionReader(providers []string) http.HandlerFunc {
+// The namespace query parameter lists a namespace from the providers it is mapped to, or
+// from every provider when it is not mapped. The providers which could not be listed are
+// named by the X-Federation-Failed-Providers header, and a 502 is returned when none could be.
+// A function listed by several providers is returned once with their replicas summed
+func MakeFunctionReader(providerLookup routing.ProviderRegistry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		log.Info("read request")
-		functions, err := routing.ReadServices(providers)
+		providers := providerLookup.ListProviders()
+		namespace := r.URL.Query().Get("namespace")
+		if len(namespace) > 0 {
+			providers = namespaceProviders(providers, namespace)
+		}
+
+		functions, err := routing.ReadNamespaceServices(r.Context(), providerLookup.GetClient(), providers, namespace)
 		if err != nil {
 			log.Printf("Error getting service list: %s\n", err.Error())
 
@@ -27,14 +50,83 @@ func MakeFunctionReader(providers []string) http.HandlerFunc {
 			return
 		}
 
-		var result []*types.FunctionStatus
-		for _, v := range functions.Providers {
-			result = append(result, v...)
+		if failed := functions.Failed(); len(failed) > 0 {
+			var errs []string
+			for _, name := range failed {
+				errs = append(errs, fmt.Sprintf("%s: %s", name, functions.Listings[name].Error))
+			}
+			log.Warnf("unable to list the functions of providers %s", strings.Join(errs, ", "))
+
+			if len(failed) == len(functions.Listings) {
+				w.WriteHeader(http.StatusBadGateway)
+				w.Write([]byte(fmt.Sprintf("unable to list the functions of any provider. %s", strings.Join(errs, ", "))))
+				return
+			}
+
+			w.Header().Set(failedProvidersHeader, strings.Join(failed, ","))
 		}
 
-		functionBytes, _ := json.Marshal(result)
+		functionBytes, _ := json.Marshal(federatedStatuses(functions.Providers))
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		w.Write(functionBytes)
 	}
 }
+
+// federatedStatuses merges the functions listed by each provider into one status per function,
+// sorted by namespace and name. The status is taken from the first provider by name, with the
+// replicas and invocations of every provider summed
+func federatedStatuses(listed map[string][]*types.FunctionStatus) []*FederatedFunctionStatus {
+	var names []string
+	for name := range listed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := []*FederatedFunctionStatus{}
+	byKey := map[string]*FederatedFunctionStatus{}
+	for _, name := range names {
+		for _, f := range listed[name] {
+			key := routing.FunctionKey(f.Name, f.Namespace)
+			status, ok := byKey[key]
+			if !ok {
+				status = &FederatedFunctionStatus{FunctionStatus: *f}
+				byKey[key] = status
+				result = append(result, status)
+			} else {
+				status.Replicas += f.Replicas
+				status.AvailableReplicas += f.AvailableReplicas
+				status.InvocationCount += f.InvocationCount
+			}
+			status.Providers = append(status.Providers, name)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// namespaceProviders returns the providers the namespace is mapped to, or all of them when it
+// is not mapped to any
+func namespaceProviders(providers []routing.Provider, namespace string) []routing.Provider {
+	var result []routing.Provider
+	for _, p := range providers {
+		for _, ns := range p.Namespaces {
+			if ns == namespace {
+				result = append(result, p)
+			}
+		}
+	}
+
+	if len(result) == 0 {
+		return providers
+	}
+
+	return result
+}
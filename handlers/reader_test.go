@@ -0,0 +1,84 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+)
+
+func Test_FunctionReader_FailedProviders(t *testing.T) {
+	healthy := providertest.New(http.StatusOK)
+	healthy.Handle("/system/functions", http.StatusOK, providertest.Functions("echo"))
+	defer healthy.Close()
+	failing := providertest.New(http.StatusServiceUnavailable)
+	defer failing.Close()
+
+	tests := []struct {
+		name       string
+		providers  []routing.Provider
+		wantStatus int
+		wantHeader string
+	}{
+		{name: "all listed", providers: []routing.Provider{{Name: "east", URL: healthy.URL, Default: true}}, wantStatus: http.StatusOK},
+		{name: "some failed", providers: []routing.Provider{{Name: "east", URL: failing.URL, Default: true}, {Name: "west", URL: healthy.URL}}, wantStatus: http.StatusOK, wantHeader: "east"},
+		{name: "all failed", providers: []routing.Provider{{Name: "east", URL: failing.URL, Default: true}}, wantStatus: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providerLookup, err := routing.NewDefaultProviderRouting(tt.providers, routing.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, _ := http.NewRequest(http.MethodGet, "/system/functions", nil)
+			rr := httptest.NewRecorder()
+			MakeFunctionReader(providerLookup)(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			if got := rr.Header().Get("X-Federation-Failed-Providers"); got != tt.wantHeader {
+				t.Errorf("want failed providers %q, got %q", tt.wantHeader, got)
+			}
+		})
+	}
+}
+
+func Test_FunctionReader_MergesReplicatedFunctions(t *testing.T) {
+	east := providertest.New(http.StatusOK)
+	east.Handle("/system/functions", http.StatusOK, `[{"name":"nodeinfo","replicas":1},{"name":"echo","replicas":1,"availableReplicas":1,"invocationCount":3}]`)
+	defer east.Close()
+	west := providertest.New(http.StatusOK)
+	west.Handle("/system/functions", http.StatusOK, `[{"name":"echo","replicas":2,"availableReplicas":1,"invocationCount":4}]`)
+	defer west.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{
+		{Name: "west", URL: west.URL},
+		{Name: "east", URL: east.URL, Default: true},
+	}, routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `[{"name":"echo","image":"","invocationCount":7,"replicas":3,"envProcess":"","availableReplicas":2,"labels":null,"annotations":null,"providers":["east","west"]},` +
+		`{"name":"nodeinfo","image":"","invocationCount":0,"replicas":1,"envProcess":"","availableReplicas":0,"labels":null,"annotations":null,"providers":["east"]}]`
+
+	// listing twice shows the order does not depend on map iteration
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "/system/functions", nil)
+		rr := httptest.NewRecorder()
+		MakeFunctionReader(providerLookup)(rr, req)
+
+		if got := rr.Body.String(); got != want {
+			t.Errorf("want %s, got %s", want, got)
+		}
+	}
+}
@@ -4,37 +4,266 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
 
 	"github.com/gorilla/mux"
+	"github.com/openfaas-incubator/faas-federation/routing"
 	types "github.com/openfaas/faas-provider/types"
 	log "github.com/sirupsen/logrus"
 )
 
-// MakeReplicaUpdater updates desired count of replicas
-func MakeReplicaUpdater() http.HandlerFunc {
+// MakeReplicaUpdater scales a function on the provider it is deployed to, the requested
+// replicas of a function on several providers are split across them by the distribution
+// policy of the function
+func MakeReplicaUpdater(proxy http.HandlerFunc, providerLookup routing.ProviderLookup, distribution *routing.ReplicaDistribution) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Info("update replicas, nothing to do here")
+		functionName := requestFunctionName(r)
+		log.Infof("update replicas of %s", functionName)
 
+		f, ok := providerLookup.GetFunction(functionName)
+		var providers []routing.Provider
+		if ok {
+			var err error
+			if providers, err = routing.ReplicaProviders(providerLookup, f); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		}
+
+		if len(providers) < 2 {
+			mux.Vars(r)["params"] = r.URL.Path
+			proxy.ServeHTTP(w, r)
+			return
+		}
+
+		functionName = qualifyRequest(providerLookup, r, functionName)
+		body, err := readBody(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		req := types.ScaleServiceRequest{}
+		if err := json.Unmarshal(body, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("invalid scale request. %v", err)))
+			return
+		}
+
+		replicas, err := distribution.Distribute(f, providers, req.Replicas)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		log.Infof("scaling %s to %d replicas with the %s policy: %v", functionName, req.Replicas, distribution.PolicyName(f), replicas)
+
+		providerURLs := map[string]*url.URL{}
+		for name, u := range providerLookup.GetProviders() {
+			if _, ok := replicas[name]; ok {
+				providerURLs[name] = u
+			}
+		}
+
+		results := replicateEach(providerLookup, providerURLs, r, func(name string) []byte {
+			scaleBytes, _ := json.Marshal(types.ScaleServiceRequest{ServiceName: req.ServiceName, Replicas: replicas[name]})
+			return scaleBytes
+		})
+
+		writeReplicationResult(w, ReplicationResult{Function: functionName, Providers: results})
 	}
 }
 
-// MakeReplicaReader reads the amount of replicas for a deployment
-func MakeReplicaReader() http.HandlerFunc {
+// MakeReplicaReader reads the replicas of a function from the provider it is deployed to,
+// the replicas of a function on several providers are summed
+func MakeReplicaReader(proxy http.HandlerFunc, providerLookup routing.ProviderLookup) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Info("read replicas")
+		functionName := requestFunctionName(r)
+		log.Infof("read replicas of %s", functionName)
 
-		vars := mux.Vars(r)
-		functionName := vars["name"]
+		providers := functionProviders(providerLookup, functionName)
+		if len(providers) < 2 {
+			mux.Vars(r)["params"] = r.URL.Path
+			proxy.ServeHTTP(w, r)
+			return
+		}
 
-		found := &types.FunctionStatus{}
-		found.Name = functionName
-		found.AvailableReplicas = 1
+		functionName = qualifyRequest(providerLookup, r, functionName)
+		status, err := sumReplicas(readReplicas(r.Context(), providerLookup, providers, r.URL))
+		if err != nil {
+			log.Errorln(err)
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(err.Error()))
+			return
+		}
 
-		functionBytes, _ := json.Marshal(found)
+		functionBytes, _ := json.Marshal(status)
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(200)
+		w.WriteHeader(http.StatusOK)
 		w.Write(functionBytes)
 	}
 }
+
+// functionProviders returns the providers of a cached function, or nil when the function is
+// not cached or can not be resolved so that the request is proxied to a single provider
+func functionProviders(providerLookup routing.ProviderLookup, functionName string) map[string]*url.URL {
+	f, ok := providerLookup.GetFunction(functionName)
+	if !ok {
+		return nil
+	}
+
+	providers, err := resolveProviders(providerLookup, f)
+	if err != nil {
+		log.Warnf("unable to resolve the providers of function %s. %v", functionName, err)
+		return nil
+	}
+
+	return providers
+}
+
+// ProviderReplicas are the replicas of a function on a single provider
+type ProviderReplicas struct {
+	Provider  string `json:"provider"`
+	Desired   uint64 `json:"desired"`
+	Available uint64 `json:"available"`
+	Error     string `json:"error,omitempty"`
+	status    *types.FunctionStatus
+}
+
+// ReplicaStatus shows the desired and available replicas of a function on each provider
+type ReplicaStatus struct {
+	Function          string             `json:"function"`
+	Policy            string             `json:"policy,omitempty"`
+	Replicas          uint64             `json:"replicas"`
+	AvailableReplicas uint64             `json:"availableReplicas"`
+	Providers         []ProviderReplicas `json:"providers"`
+}
+
+// MakeReplicaStatusHandler shows the desired and available replicas of the function named
+// by the name path variable on each of its providers, with its distribution policy
+func MakeReplicaStatusHandler(providerLookup routing.ProviderLookup, distribution *routing.ReplicaDistribution) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		functionName := requestFunctionName(r)
+
+		providers, err := resolveFunctionProviders(providerLookup, functionName)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(fmt.Sprintf("Cannot find service: %s.", functionName)))
+			return
+		}
+
+		statusURL := &url.URL{Path: "/system/function/" + mux.Vars(r)["name"], RawQuery: r.URL.RawQuery}
+		f, ok := providerLookup.GetFunction(functionName)
+		if ok {
+			functionName = routing.FunctionKey(f.Service, f.Namespace)
+			statusURL = namespaceURL(&url.URL{Path: "/system/function/" + f.Service}, f.Namespace)
+		}
+
+		result := ReplicaStatus{Function: functionName, Providers: readReplicas(r.Context(), providerLookup, providers, statusURL)}
+		if ok && len(providers) > 1 {
+			result.Policy = distribution.PolicyName(f)
+		}
+
+		for _, v := range result.Providers {
+			result.Replicas += v.Desired
+			result.AvailableReplicas += v.Available
+		}
+
+		statusBytes, _ := json.Marshal(result)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(statusBytes)
+	}
+}
+
+// readReplicas reads the replicas of a function from the path and query of functionURL on
+// each provider, sorted by provider name
+func readReplicas(ctx context.Context, providerLookup routing.ProviderRegistry, providers map[string]*url.URL, functionURL *url.URL) []ProviderReplicas {
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]ProviderReplicas, len(names))
+	var requests []*http.Request
+	var requestIndex []int
+	for i, name := range names {
+		result[i].Provider = name
+
+		u := *providers[name]
+		u.Path = functionURL.Path
+		u.RawQuery = functionURL.RawQuery
+		req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err := authorize(providerLookup, providers[name], req); err != nil {
+			result[i].Error = err.Error()
+			continue
+		}
+
+		requests = append(requests, req.WithContext(ctx))
+		requestIndex = append(requestIndex, i)
+	}
+
+	for _, res := range routing.DoWithClient(providerLookup.GetClient(), requests, len(requests)) {
+		replicas := &result[requestIndex[res.Index]]
+		if res.Err != nil {
+			replicas.Error = res.Err.Error()
+			continue
+		}
+
+		body, _ := ioutil.ReadAll(res.Response.Body)
+		res.Response.Body.Close()
+		if res.Response.StatusCode != http.StatusOK {
+			replicas.Error = fmt.Sprintf("unexpected status code %d", res.Response.StatusCode)
+			continue
+		}
+
+		status := types.FunctionStatus{}
+		if err := json.Unmarshal(body, &status); err != nil {
+			replicas.Error = err.Error()
+			continue
+		}
+
+		replicas.Desired = status.Replicas
+		replicas.Available = status.AvailableReplicas
+		replicas.status = &status
+	}
+
+	return result
+}
+
+// sumReplicas adds up the replicas and invocations of a function on each provider, providers
+// which could not be read are left out unless none of them could be read
+func sumReplicas(replicas []ProviderReplicas) (*types.FunctionStatus, error) {
+	var result *types.FunctionStatus
+	for _, v := range replicas {
+		if v.status == nil {
+			log.Warnf("unable to read replicas from provider %s. %s", v.Provider, v.Error)
+			continue
+		}
+
+		if result == nil {
+			status := *v.status
+			result = &status
+			continue
+		}
+
+		result.Replicas += v.status.Replicas
+		result.AvailableReplicas += v.status.AvailableReplicas
+		result.InvocationCount += v.status.InvocationCount
+	}
+
+	if result == nil {
+		return nil, fmt.Errorf("unable to read replicas from any provider")
+	}
+
+	return result, nil
+}
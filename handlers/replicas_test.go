@@ -0,0 +1,232 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas-incubator/faas-federation/routing"
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+// functionStatus is the status of the function echo as read from a provider
+func functionStatus(replicas uint64, available uint64) string {
+	return fmt.Sprintf(`{"name": "echo", "image": "functions/alpine", "replicas": %d, "availableReplicas": %d, "invocationCount": 10}`, replicas, available)
+}
+
+// scaled returns the scale requests received by the provider
+func scaled(p *providertest.Provider) []string {
+	var result []string
+	for _, v := range p.Received() {
+		if strings.HasPrefix(v, "POST ") {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+func newReplicasRouter(t *testing.T, annotations map[string]string, east *providertest.Provider, west *providertest.Provider) *mux.Router {
+	providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{
+		{Name: "east", URL: east.URL, Default: true},
+		{Name: "west", URL: west.URL},
+	}, routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerLookup.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &annotations})
+
+	proxy := MakeControlPlaneProxy(providerLookup, time.Second)
+	distribution, _ := routing.NewReplicaDistribution("even")
+
+	r := mux.NewRouter()
+	r.HandleFunc("/system/function/{name}", MakeReplicaReader(proxy, providerLookup))
+	r.HandleFunc("/system/scale-function/{name}", MakeReplicaUpdater(proxy, providerLookup, distribution))
+	r.HandleFunc("/system/federation/replicas/{name}", MakeReplicaStatusHandler(providerLookup, distribution))
+
+	return r
+}
+
+func Test_ReplicaReader(t *testing.T) {
+	tests := []struct {
+		name          string
+		annotations   map[string]string
+		wantReplicas  uint64
+		wantAvailable uint64
+	}{
+		{name: "single provider", annotations: map[string]string{"com.openfaas.federation.gateway": "west"}, wantReplicas: 3, wantAvailable: 2},
+		{name: "several providers", annotations: map[string]string{"com.openfaas.federation.replicas-on": "east,west"}, wantReplicas: 4, wantAvailable: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			east := providertest.New(http.StatusAccepted)
+			east.Handle("GET /system/function/echo", http.StatusOK, functionStatus(1, 0))
+			defer east.Close()
+			west := providertest.New(http.StatusAccepted)
+			west.Handle("GET /system/function/echo", http.StatusOK, functionStatus(3, 2))
+			defer west.Close()
+
+			req, _ := http.NewRequest(http.MethodGet, "/system/function/echo", nil)
+			rr := httptest.NewRecorder()
+			newReplicasRouter(t, tt.annotations, east, west).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("want status 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			status := types.FunctionStatus{}
+			json.Unmarshal(rr.Body.Bytes(), &status)
+			if status.Replicas != tt.wantReplicas || status.AvailableReplicas != tt.wantAvailable {
+				t.Errorf("want %d replicas with %d available, got %d with %d", tt.wantReplicas, tt.wantAvailable, status.Replicas, status.AvailableReplicas)
+			}
+		})
+	}
+}
+
+func Test_ReplicaUpdater(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		replicas    uint64
+		wantEast    string
+		wantWest    string
+	}{
+		{name: "single provider", annotations: map[string]string{"com.openfaas.federation.gateway": "west"}, replicas: 5, wantWest: `"replicas": 5`},
+		{name: "several providers", annotations: map[string]string{"com.openfaas.federation.replicas-on": "east,west"}, replicas: 5, wantEast: `"replicas":3`, wantWest: `"replicas":2`},
+		{name: "burst policy", annotations: map[string]string{"com.openfaas.federation.replicas-on": "west,east", "com.openfaas.federation.distribution": "burst"}, replicas: 5, wantEast: `"replicas":0`, wantWest: `"replicas":5`},
+		{name: "scale to zero", annotations: map[string]string{"com.openfaas.federation.replicas-on": "east,west"}, replicas: 0, wantEast: `"replicas":0`, wantWest: `"replicas":0`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			east := providertest.New(http.StatusAccepted)
+			east.Handle("GET /system/function/echo", http.StatusOK, functionStatus(0, 0))
+			defer east.Close()
+			west := providertest.New(http.StatusAccepted)
+			west.Handle("GET /system/function/echo", http.StatusOK, functionStatus(0, 0))
+			defer west.Close()
+
+			body := fmt.Sprintf(`{"serviceName": "echo", "replicas": %d}`, tt.replicas)
+			req, _ := http.NewRequest(http.MethodPost, "/system/scale-function/echo", strings.NewReader(body))
+			rr := httptest.NewRecorder()
+			newReplicasRouter(t, tt.annotations, east, west).ServeHTTP(rr, req)
+
+			if rr.Code >= 300 {
+				t.Fatalf("want success, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			for name, v := range map[string]struct {
+				scaled []string
+				want   string
+			}{"east": {scaled(east), tt.wantEast}, "west": {scaled(west), tt.wantWest}} {
+				if len(v.want) == 0 {
+					if len(v.scaled) > 0 {
+						t.Errorf("want %s not scaled, got %v", name, v.scaled)
+					}
+					continue
+				}
+
+				if len(v.scaled) != 1 || !strings.HasPrefix(v.scaled[0], "POST /system/scale-function/echo ") || !strings.Contains(v.scaled[0], v.want) {
+					t.Errorf("want %s scaled with %s, got %v", name, v.want, v.scaled)
+				}
+			}
+		})
+	}
+}
+
+func Test_ReplicaStatusHandler(t *testing.T) {
+	east := providertest.New(http.StatusAccepted)
+	east.Handle("GET /system/function/echo", http.StatusOK, functionStatus(1, 0))
+	defer east.Close()
+	west := providertest.New(http.StatusAccepted)
+	west.Handle("GET /system/function/echo", http.StatusOK, functionStatus(3, 2))
+	defer west.Close()
+
+	annotations := map[string]string{"com.openfaas.federation.replicas-on": "east,west", "com.openfaas.federation.distribution": "weighted"}
+	req, _ := http.NewRequest(http.MethodGet, "/system/federation/replicas/echo", nil)
+	rr := httptest.NewRecorder()
+	newReplicasRouter(t, annotations, east, west).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	status := ReplicaStatus{}
+	json.Unmarshal(rr.Body.Bytes(), &status)
+	want := ReplicaStatus{
+		Function:          "echo",
+		Policy:            "weighted",
+		Replicas:          4,
+		AvailableReplicas: 2,
+		Providers:         []ProviderReplicas{{Provider: "east", Desired: 1}, {Provider: "west", Desired: 3, Available: 2}},
+	}
+	if fmt.Sprint(status) != fmt.Sprint(want) {
+		t.Errorf("want %v, got %v", want, status)
+	}
+}
+
+func Test_ReplicaReader_Namespace(t *testing.T) {
+	teamA := func(replicas uint64, available uint64) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("namespace") != "team-a" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(functionStatus(replicas, available)))
+		}
+	}
+	east := providertest.New(http.StatusNotFound)
+	east.HandleFunc("GET /system/function/echo", teamA(1, 0))
+	defer east.Close()
+	west := providertest.New(http.StatusNotFound)
+	west.HandleFunc("GET /system/function/echo", teamA(3, 2))
+	defer west.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{
+		{Name: "east", URL: east.URL, Default: true},
+		{Name: "west", URL: west.URL},
+	}, routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerLookup.AddFunction(&types.FunctionDeployment{Service: "echo", Namespace: "team-a", Annotations: &map[string]string{"com.openfaas.federation.replicas-on": "east,west"}})
+
+	proxy := MakeControlPlaneProxy(providerLookup, time.Second)
+	distribution, _ := routing.NewReplicaDistribution("even")
+	router := mux.NewRouter()
+	router.HandleFunc("/system/function/{name}", MakeReplicaReader(proxy, providerLookup))
+	router.HandleFunc("/system/federation/replicas/{name}", MakeReplicaStatusHandler(providerLookup, distribution))
+
+	for _, path := range []string{
+		"/system/function/echo?namespace=team-a",
+		"/system/function/echo",
+		"/system/federation/replicas/echo?namespace=team-a",
+		"/system/federation/replicas/echo",
+	} {
+		t.Run(path, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, path, nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("want status 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			status := ReplicaStatus{}
+			json.Unmarshal(rr.Body.Bytes(), &status)
+			if status.Replicas != 4 || status.AvailableReplicas != 2 {
+				t.Errorf("want the replicas of echo in team-a from both providers, got %s", rr.Body.String())
+			}
+		})
+	}
+}
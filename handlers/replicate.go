@@ -0,0 +1,196 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	types "github.com/openfaas/faas-provider/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// ProviderResult is the outcome of a control-plane request sent to a single provider
+type ProviderResult struct {
+	Provider   string `json:"provider"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Succeeded returns true when the provider accepted the request
+func (p ProviderResult) Succeeded() bool {
+	return len(p.Error) == 0 && p.StatusCode >= 200 && p.StatusCode < 300
+}
+
+// anySucceeded returns true when at least one of the providers accepted the request
+func anySucceeded(results []ProviderResult) bool {
+	for _, v := range results {
+		if v.Succeeded() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allSucceeded returns true when every provider accepted the request
+func allSucceeded(results []ProviderResult) bool {
+	for _, v := range results {
+		if !v.Succeeded() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ReplicationResult is the response body of a control-plane request sent to several providers
+type ReplicationResult struct {
+	Function  string           `json:"function,omitempty"`
+	Secret    string           `json:"secret,omitempty"`
+	Providers []ProviderResult `json:"providers"`
+}
+
+func (r ReplicationResult) subject() string {
+	if len(r.Secret) > 0 {
+		return "secret " + r.Secret
+	}
+
+	return "function " + r.Function
+}
+
+// replicate sends the request to each of the providers in parallel with the credentials of
+// each provider, the request body is read fully so that it can be sent more than once
+func replicate(providerLookup routing.ProviderRegistry, providers map[string]*url.URL, r *http.Request) []ProviderResult {
+	var body []byte
+	if r.Body != nil {
+		defer r.Body.Close()
+		body, _ = ioutil.ReadAll(r.Body)
+	}
+
+	return replicateEach(providerLookup, providers, r, func(string) []byte {
+		return body
+	})
+}
+
+// replicateEach sends the request to each of the providers in parallel, with the body
+// returned by bodyFor the name of the provider
+func replicateEach(providerLookup routing.ProviderRegistry, providers map[string]*url.URL, r *http.Request, bodyFor func(name string) []byte) []ProviderResult {
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]ProviderResult, len(names))
+	var requests []*http.Request
+	var requestIndex []int
+	for i, name := range names {
+		results[i].Provider = name
+
+		u := *providers[name]
+		u.Path = r.URL.Path
+		u.RawQuery = r.URL.RawQuery
+		req, err := http.NewRequest(r.Method, u.String(), bytes.NewReader(bodyFor(name)))
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		req = req.WithContext(r.Context())
+		if contentType := r.Header.Get("Content-Type"); len(contentType) > 0 {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		if err := authorize(providerLookup, providers[name], req); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		requests = append(requests, req)
+		requestIndex = append(requestIndex, i)
+	}
+
+	for _, res := range routing.DoWithClient(providerLookup.GetClient(), requests, len(requests)) {
+		result := &results[requestIndex[res.Index]]
+		if res.Err != nil {
+			result.Error = res.Err.Error()
+			continue
+		}
+
+		result.StatusCode = res.Response.StatusCode
+		if !result.Succeeded() {
+			message, _ := ioutil.ReadAll(res.Response.Body)
+			result.Error = string(bytes.TrimSpace(message))
+		}
+		res.Response.Body.Close()
+	}
+
+	for _, result := range results {
+		recordControlPlane(r, result.Provider, result.Succeeded())
+	}
+
+	return results
+}
+
+// writeReplicationResult writes the outcome of each provider as JSON, the status code is
+// 200 when every provider succeeded, 207 for a partial failure and 502 when all failed
+func writeReplicationResult(w http.ResponseWriter, result ReplicationResult) {
+	failed := 0
+	for _, v := range result.Providers {
+		if !v.Succeeded() {
+			failed++
+			log.Errorf("request for %s failed on provider %s, status code: %d, error: %s", result.subject(), v.Provider, v.StatusCode, v.Error)
+		}
+	}
+
+	status := http.StatusOK
+	if failed == len(result.Providers) {
+		status = http.StatusBadGateway
+	} else if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+
+	resultBytes, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resultBytes)
+}
+
+// resolveProviders returns every provider a function is deployed to, keyed by provider name
+func resolveProviders(providerLookup routing.ProviderLookup, f *types.FunctionDeployment) (map[string]*url.URL, error) {
+	replicas, err := providerLookup.ResolveReplicas(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(replicas) > 0 {
+		return replicas, nil
+	}
+
+	providerURL, err := providerLookup.ResolveFunction(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]*url.URL{providerLookup.ProviderName(providerURL): providerURL}, nil
+}
+
+// resolveFunctionProviders returns every provider the named function is deployed to
+func resolveFunctionProviders(providerLookup routing.ProviderLookup, functionName string) (map[string]*url.URL, error) {
+	if f, ok := providerLookup.GetFunction(functionName); ok {
+		return resolveProviders(providerLookup, f)
+	}
+
+	providerURL, err := providerLookup.Resolve(functionName)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]*url.URL{providerLookup.ProviderName(providerURL): providerURL}, nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+func Test_Deploy_Replicated(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusA    int
+		statusB    int
+		wantStatus int
+		wantCached bool
+	}{
+		{name: "all providers succeed", statusA: http.StatusAccepted, statusB: http.StatusOK, wantStatus: http.StatusOK, wantCached: true},
+		{name: "one provider fails", statusA: http.StatusAccepted, statusB: http.StatusInternalServerError, wantStatus: http.StatusMultiStatus, wantCached: true},
+		{name: "all providers fail", statusA: http.StatusBadRequest, statusB: http.StatusInternalServerError, wantStatus: http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providerA := providertest.New(tt.statusA)
+			defer providerA.Close()
+			providerB := providertest.New(tt.statusB)
+			defer providerB.Close()
+
+			providerLookup, err := routing.NewDefaultProviderRouting(testProviders(providerA.HostURL("127.0.0.1"), nil, providerA.HostURL("127.0.0.1"), providerB.HostURL("localhost")), routing.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			proxyFunc := func(w http.ResponseWriter, r *http.Request) {
+				t.Error("replicated deployment should not be proxied")
+			}
+
+			req, err := http.NewRequest("POST", "/system/functions", bytes.NewBuffer([]byte(echoDeployReplicated)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			MakeDeployHandler(proxyFunc, providerLookup).ServeHTTP(rr, req)
+			if status := rr.Code; status != tt.wantStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, tt.wantStatus)
+			}
+
+			result := ReplicationResult{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(result.Providers) != 2 {
+				t.Fatalf("want a result for each provider, got %v", result.Providers)
+			}
+
+			want := map[string]int{"127.0.0.1": tt.statusA, "localhost": tt.statusB}
+			for _, v := range result.Providers {
+				if v.StatusCode != want[v.Provider] {
+					t.Errorf("want status code %d for %s, got %d", want[v.Provider], v.Provider, v.StatusCode)
+				}
+			}
+
+			for _, p := range []*providertest.Provider{providerA, providerB} {
+				if len(p.Received()) != 1 || !strings.HasPrefix(p.Received()[0], "POST /system/functions {") {
+					t.Errorf("want deployment to be sent to each provider, got %v", p.Received())
+				}
+			}
+
+			weights, ok := providerLookup.GetFunctionWeights("echo-a")
+			if tt.wantCached && (!ok || len(weights) != 2) {
+				t.Errorf("want both providers recorded for invocations, got %v", weights)
+			}
+
+			if _, ok := providerLookup.GetFunction("echo-a"); ok != tt.wantCached {
+				t.Errorf("want function cached %t, got %t", tt.wantCached, ok)
+			}
+		})
+	}
+}
+
+func Test_Delete_Replicated(t *testing.T) {
+	providerA := providertest.New(http.StatusOK)
+	defer providerA.Close()
+	providerB := providertest.New(http.StatusOK)
+	defer providerB.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders(providerA.HostURL("127.0.0.1"), nil, providerA.HostURL("127.0.0.1"), providerB.HostURL("localhost")), routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providerLookup.AddFunction(&types.FunctionDeployment{
+		Service:     "echo-a",
+		Annotations: &map[string]string{"com.openfaas.federation.replicas-on": "127.0.0.1,localhost"},
+	})
+
+	proxyFunc := func(w http.ResponseWriter, r *http.Request) {
+		t.Error("replicated delete should not be proxied")
+	}
+
+	req, err := http.NewRequest("DELETE", "/system/functions", bytes.NewBuffer([]byte(`{"functionName":"echo-a"}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	MakeDeleteHandler(proxyFunc, providerLookup).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	for _, p := range []*providertest.Provider{providerA, providerB} {
+		if len(p.Received()) != 1 || !strings.HasPrefix(p.Received()[0], "DELETE /system/functions") {
+			t.Errorf("want delete to be sent to each provider, got %v", p.Received())
+		}
+	}
+
+	if _, ok := providerLookup.GetFunction("echo-a"); ok {
+		t.Error("want a function deleted from each provider to be removed from the cache")
+	}
+}
+
+const echoDeployReplicated = `{"service":"echo-a","image":"openfaas/echo:latest","annotations":{"com.openfaas.federation.replicas-on":"127.0.0.1,localhost"}}`
@@ -0,0 +1,173 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// retryBudgetMaxTokens caps the retries which can be saved up while providers are healthy
+const retryBudgetMaxTokens = 10
+
+// RetryConfig configures retrying failed invocations against another provider
+type RetryConfig struct {
+	// MaxAttempts for a single invocation including the first, 1 disables retries
+	MaxAttempts int
+	// MaxBodyBytes buffered so that a request or an error response can be replayed,
+	// larger requests are not retried
+	MaxBodyBytes int64
+	// BudgetRatio of invocations which may be retried
+	BudgetRatio float64
+}
+
+// retryBudget is a token bucket which earns BudgetRatio of a retry for every invocation,
+// so that retries can not amplify an outage
+type retryBudget struct {
+	ratio  float64
+	tokens float64
+	lock   sync.Mutex
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{ratio: ratio}
+}
+
+func (b *retryBudget) deposit() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens += b.ratio
+	if b.tokens > retryBudgetMaxTokens {
+		b.tokens = retryBudgetMaxTokens
+	}
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// bufferBody reads the request body so that it can be sent more than once, false is
+// returned when the body is larger than limit in which case r.Body is left readable
+func bufferBody(r *http.Request, limit int64) ([]byte, bool) {
+	if r.Body == nil {
+		return nil, true
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil || int64(len(body)) > limit {
+		r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		return nil, false
+	}
+
+	return body, true
+}
+
+// attemptResponseWriter records whether an invocation failed because of its provider, a
+// 502, 503 or 504 status or a provider which could not be reached. When retryable, a 5xx
+// response is held back, up to limit bytes, so that the invocation can be sent elsewhere
+type attemptResponseWriter struct {
+	w         http.ResponseWriter
+	header    http.Header
+	retryable bool
+	limit     int64
+	status    int
+	failed    bool
+	held      bool
+	body      bytes.Buffer
+}
+
+func newAttemptResponseWriter(w http.ResponseWriter, retryable bool, limit int64) *attemptResponseWriter {
+	return &attemptResponseWriter{
+		w:         w,
+		header:    http.Header{},
+		retryable: retryable,
+		limit:     limit,
+	}
+}
+
+func (a *attemptResponseWriter) Header() http.Header {
+	return a.header
+}
+
+func (a *attemptResponseWriter) WriteHeader(status int) {
+	if a.status != 0 {
+		return
+	}
+
+	a.status = status
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		a.failed = true
+	}
+
+	if a.retryable && status >= http.StatusInternalServerError {
+		a.held = true
+		return
+	}
+
+	a.commit()
+}
+
+func (a *attemptResponseWriter) Write(p []byte) (int, error) {
+	if a.status == 0 {
+		a.WriteHeader(http.StatusOK)
+	}
+
+	if a.status == http.StatusInternalServerError && a.body.Len() == 0 && bytes.HasPrefix(p, []byte(unreachableMessage)) {
+		a.failed = true
+	}
+
+	if !a.held {
+		return a.w.Write(p)
+	}
+
+	if int64(a.body.Len()+len(p)) <= a.limit {
+		return a.body.Write(p)
+	}
+
+	// the error response is too large to hold back, so it is sent on and not retried
+	if err := a.flush(); err != nil {
+		return 0, err
+	}
+
+	return a.w.Write(p)
+}
+
+// shouldRetry returns true when a 5xx response was held back and nothing was written
+func (a *attemptResponseWriter) shouldRetry() bool {
+	return a.held
+}
+
+// flush writes a held back response to the client
+func (a *attemptResponseWriter) flush() error {
+	if !a.held {
+		return nil
+	}
+
+	a.held = false
+	a.commit()
+	_, err := a.w.Write(a.body.Bytes())
+	a.body.Reset()
+
+	return err
+}
+
+func (a *attemptResponseWriter) commit() {
+	for k, v := range a.header {
+		a.w.Header()[k] = v
+	}
+	a.w.WriteHeader(a.status)
+}
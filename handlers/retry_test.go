@@ -0,0 +1,171 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+func Test_Invoke_Retry(t *testing.T) {
+	tests := []struct {
+		name            string
+		retry           string
+		primaryStatus   int
+		primaryDown     bool
+		alternateStatus int
+		config          RetryConfig
+		body            string
+		wantStatus      int
+		wantAlternate   int
+	}{
+		{
+			name:            "5xx is retried against the alternate provider",
+			retry:           "true",
+			primaryStatus:   http.StatusServiceUnavailable,
+			alternateStatus: http.StatusOK,
+			config:          RetryConfig{MaxAttempts: 2, MaxBodyBytes: 1024, BudgetRatio: 1},
+			body:            "Hello World",
+			wantStatus:      http.StatusOK,
+			wantAlternate:   1,
+		},
+		{
+			name:            "unreachable provider is retried against the alternate provider",
+			retry:           "true",
+			primaryDown:     true,
+			alternateStatus: http.StatusOK,
+			config:          RetryConfig{MaxAttempts: 2, MaxBodyBytes: 1024, BudgetRatio: 1},
+			body:            "Hello World",
+			wantStatus:      http.StatusOK,
+			wantAlternate:   1,
+		},
+		{
+			name:            "last failure is returned when the alternate fails too",
+			retry:           "true",
+			primaryStatus:   http.StatusServiceUnavailable,
+			alternateStatus: http.StatusBadGateway,
+			config:          RetryConfig{MaxAttempts: 2, MaxBodyBytes: 1024, BudgetRatio: 1},
+			body:            "Hello World",
+			wantStatus:      http.StatusBadGateway,
+			wantAlternate:   1,
+		},
+		{
+			name:            "function which has not opted in is not retried",
+			retry:           "false",
+			primaryStatus:   http.StatusServiceUnavailable,
+			alternateStatus: http.StatusOK,
+			config:          RetryConfig{MaxAttempts: 2, MaxBodyBytes: 1024, BudgetRatio: 1},
+			body:            "Hello World",
+			wantStatus:      http.StatusServiceUnavailable,
+		},
+		{
+			name:            "4xx is not retried",
+			retry:           "true",
+			primaryStatus:   http.StatusBadRequest,
+			alternateStatus: http.StatusOK,
+			config:          RetryConfig{MaxAttempts: 2, MaxBodyBytes: 1024, BudgetRatio: 1},
+			body:            "Hello World",
+			wantStatus:      http.StatusBadRequest,
+		},
+		{
+			name:            "exhausted budget is not retried",
+			retry:           "true",
+			primaryStatus:   http.StatusServiceUnavailable,
+			alternateStatus: http.StatusOK,
+			config:          RetryConfig{MaxAttempts: 2, MaxBodyBytes: 1024, BudgetRatio: 0},
+			body:            "Hello World",
+			wantStatus:      http.StatusServiceUnavailable,
+		},
+		{
+			name:            "body larger than the limit is not retried",
+			retry:           "true",
+			primaryStatus:   http.StatusServiceUnavailable,
+			alternateStatus: http.StatusOK,
+			config:          RetryConfig{MaxAttempts: 2, MaxBodyBytes: 4, BudgetRatio: 1},
+			body:            "Hello World",
+			wantStatus:      http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := providertest.New(tt.primaryStatus)
+			primaryURL := primary.HostURL("127.0.0.1")
+			if tt.primaryDown {
+				primary.Close()
+			} else {
+				defer primary.Close()
+			}
+			alternate := providertest.New(tt.alternateStatus)
+			defer alternate.Close()
+
+			providerLookup, err := routing.NewDefaultProviderRouting(testProviders(primaryURL, nil, primaryURL, alternate.HostURL("localhost")), routing.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			providerLookup.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{
+				"com.openfaas.federation.gateway":          "127.0.0.1",
+				"com.openfaas.federation.fallback-gateway": "localhost",
+				"com.openfaas.federation.retry":            tt.retry,
+			}})
+
+			req, _ := http.NewRequest("POST", "/function/echo", bytes.NewBufferString(tt.body))
+			rr := httptest.NewRecorder()
+			MakeProxyHandler(providerLookup, time.Second*5, tt.config).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want status %d, got %d", tt.wantStatus, rr.Code)
+			}
+
+			if len(alternate.Received()) != tt.wantAlternate {
+				t.Fatalf("want the alternate provider to receive %d invocations, got %d", tt.wantAlternate, len(alternate.Received()))
+			}
+
+			if tt.wantAlternate > 0 && alternate.Received()[0] != "POST /function/echo "+tt.body {
+				t.Errorf("want the request body to be replayed, got %q", alternate.Received()[0])
+			}
+
+			if !tt.primaryDown && (len(primary.Received()) != 1 || primary.Received()[0] != "POST /function/echo "+tt.body) {
+				t.Errorf("want the primary provider to receive the invocation, got %v", primary.Received())
+			}
+		})
+	}
+}
+
+func Test_retryBudget(t *testing.T) {
+	b := newRetryBudget(0.5)
+	if b.withdraw() {
+		t.Fatal("want an empty budget to refuse a retry")
+	}
+
+	b.deposit()
+	b.deposit()
+	if !b.withdraw() {
+		t.Fatal("want a retry after two invocations at a ratio of 0.5")
+	}
+
+	if b.withdraw() {
+		t.Error("want a single retry to be allowed")
+	}
+
+	for i := 0; i < 100; i++ {
+		b.deposit()
+	}
+
+	allowed := 0
+	for b.withdraw() {
+		allowed++
+	}
+
+	if allowed != retryBudgetMaxTokens {
+		t.Errorf("want the budget to be capped at %d retries, got %d", retryBudgetMaxTokens, allowed)
+	}
+}
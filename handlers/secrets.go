@@ -0,0 +1,219 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	types "github.com/openfaas/faas-provider/types"
+	log "github.com/sirupsen/logrus"
+)
+
+const secretsPath = "/system/secrets"
+
+// ProviderSecret is a secret listed by one of the providers
+type ProviderSecret struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+}
+
+// MakeSecretHandler lists the secrets of every provider, tagged with the provider, and
+// creates, updates or deletes a secret on the providers selected by the providers or
+// selector query parameter, or on every provider when neither is given
+func MakeSecretHandler(providerLookup routing.ProviderRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		providers, err := selectProviders(providerLookup, r.URL.Query())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			listSecrets(r.Context(), providerLookup, providers, w)
+		case http.MethodPost, http.MethodPut, http.MethodDelete:
+			body, err := readBody(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+
+			secret := types.Secret{}
+			if err := json.Unmarshal(body, &secret); err != nil || len(secret.Name) == 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("invalid secret request, a name is required"))
+				return
+			}
+
+			log.Infof("%s secret %s on %d providers", r.Method, secret.Name, len(providers))
+			writeReplicationResult(w, ReplicationResult{Secret: secret.Name, Providers: replicate(providerLookup, providers, r)})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func listSecrets(ctx context.Context, providerLookup routing.ProviderRegistry, providers map[string]*url.URL, w http.ResponseWriter) {
+	secrets, errs := readSecrets(ctx, providerLookup, providers)
+	for name, err := range errs {
+		log.Warnf("unable to list secrets of provider %s. %v", name, err)
+	}
+
+	if len(errs) == len(providers) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("unable to list secrets of any provider"))
+		return
+	}
+
+	result := []ProviderSecret{}
+	for provider, list := range secrets {
+		for _, s := range list {
+			result = append(result, ProviderSecret{Name: s.Name, Provider: provider})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Provider < result[j].Provider
+	})
+
+	secretBytes, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(secretBytes)
+}
+
+// selectProviders returns the providers named by the comma separated providers query
+// parameter, or those whose labels match the selector query parameter, otherwise all of them
+func selectProviders(providerLookup routing.ProviderRegistry, query url.Values) (map[string]*url.URL, error) {
+	names := query.Get("providers")
+	selector := query.Get("selector")
+	if len(names) > 0 && len(selector) > 0 {
+		return nil, fmt.Errorf("only one of the providers and selector query parameters can be given")
+	}
+
+	all := providerLookup.GetProviders()
+	result := map[string]*url.URL{}
+
+	switch {
+	case len(names) > 0:
+		for _, name := range strings.Split(names, ",") {
+			name = strings.TrimSpace(name)
+			u, ok := all[name]
+			if !ok {
+				return nil, fmt.Errorf("provider %s does not exist", name)
+			}
+			result[name] = u
+		}
+	case len(selector) > 0:
+		for _, p := range providerLookup.ListProviders() {
+			matches, err := p.MatchesSelector(selector)
+			if err != nil {
+				return nil, err
+			}
+			if matches {
+				result[p.Name] = all[p.Name]
+			}
+		}
+
+		if len(result) == 0 {
+			return nil, fmt.Errorf("no providers match selector %s", selector)
+		}
+	default:
+		result = all
+	}
+
+	return result, nil
+}
+
+// readSecrets lists the secrets of each provider keyed by provider name, providers whose
+// secrets could not be listed are returned with their error
+func readSecrets(ctx context.Context, providerLookup routing.ProviderRegistry, providers map[string]*url.URL) (map[string][]types.Secret, map[string]error) {
+	secrets := map[string][]types.Secret{}
+	errs := map[string]error{}
+
+	var names []string
+	var requests []*http.Request
+	for name, providerURL := range providers {
+		u := *providerURL
+		u.Path = secretsPath
+		req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err := authorize(providerLookup, providerURL, req); err != nil {
+			errs[name] = err
+			continue
+		}
+
+		names = append(names, name)
+		requests = append(requests, req.WithContext(ctx))
+	}
+
+	for _, res := range routing.DoWithClient(providerLookup.GetClient(), requests, len(requests)) {
+		name := names[res.Index]
+		if res.Err != nil {
+			errs[name] = res.Err
+			continue
+		}
+
+		body, _ := ioutil.ReadAll(res.Response.Body)
+		res.Response.Body.Close()
+		if res.Response.StatusCode != http.StatusOK {
+			errs[name] = fmt.Errorf("unexpected status code %d", res.Response.StatusCode)
+			continue
+		}
+
+		var list []types.Secret
+		if err := json.Unmarshal(body, &list); err != nil {
+			errs[name] = err
+			continue
+		}
+		secrets[name] = list
+	}
+
+	return secrets, errs
+}
+
+// checkSecrets returns an error when a secret used by the function is missing on one of
+// the providers, a provider whose secrets can not be listed is not checked
+func checkSecrets(ctx context.Context, providerLookup routing.ProviderRegistry, f *types.FunctionDeployment, providers map[string]*url.URL) error {
+	if len(f.Secrets) == 0 {
+		return nil
+	}
+
+	secrets, errs := readSecrets(ctx, providerLookup, providers)
+	for name, err := range errs {
+		log.Warnf("unable to check the secrets of function %s on provider %s. %v", f.Service, name, err)
+	}
+
+	var missing []string
+	for provider, list := range secrets {
+		found := map[string]bool{}
+		for _, s := range list {
+			found[s.Name] = true
+		}
+
+		for _, name := range f.Secrets {
+			if !found[name] {
+				missing = append(missing, fmt.Sprintf("%s on provider %s", name, provider))
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("function %s uses secrets which do not exist: %s", f.Service, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openfaas-incubator/faas-federation/routing"
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+// newSecretsProvider lists the secrets and accepts changes to them
+func newSecretsProvider(secrets ...string) *providertest.Provider {
+	list := []types.Secret{}
+	for _, name := range secrets {
+		list = append(list, types.Secret{Name: name})
+	}
+	listBytes, _ := json.Marshal(list)
+
+	p := providertest.New(http.StatusAccepted)
+	p.Handle("GET /system/secrets", http.StatusOK, string(listBytes))
+
+	return p
+}
+
+func newSecretsLookup(t *testing.T, dev *providertest.Provider, prod *providertest.Provider) routing.ProviderLookup {
+	providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{
+		{Name: "dev", URL: dev.URL, Labels: map[string]string{"env": "dev"}, Default: true},
+		{Name: "prod", URL: prod.URL, Labels: map[string]string{"env": "prod"}},
+	}, routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return providerLookup
+}
+
+func Test_SecretHandler_List(t *testing.T) {
+	dev := newSecretsProvider("db-password", "api-key")
+	defer dev.Close()
+	prod := newSecretsProvider("db-password")
+	defer prod.Close()
+
+	handler := MakeSecretHandler(newSecretsLookup(t, dev, prod))
+
+	req, _ := http.NewRequest(http.MethodGet, "/system/secrets", nil)
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("want status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var secrets []ProviderSecret
+	json.Unmarshal(rr.Body.Bytes(), &secrets)
+	want := []ProviderSecret{{Name: "api-key", Provider: "dev"}, {Name: "db-password", Provider: "dev"}, {Name: "db-password", Provider: "prod"}}
+	if len(secrets) != len(want) {
+		t.Fatalf("want %v, got %v", want, secrets)
+	}
+	for i := range want {
+		if secrets[i] != want[i] {
+			t.Errorf("want secret %d to be %v, got %v", i, want[i], secrets[i])
+		}
+	}
+
+	prod.Close()
+	rr = httptest.NewRecorder()
+	handler(rr, req)
+	json.Unmarshal(rr.Body.Bytes(), &secrets)
+	if rr.Code != http.StatusOK || len(secrets) != 2 {
+		t.Errorf("want the secrets of dev when prod is down, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func Test_SecretHandler_Create(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantDev    int
+		wantProd   int
+	}{
+		{name: "all providers", wantStatus: http.StatusOK, wantDev: 1, wantProd: 1},
+		{name: "by name", query: "?providers=prod", wantStatus: http.StatusOK, wantProd: 1},
+		{name: "by label", query: "?selector=env=dev", wantStatus: http.StatusOK, wantDev: 1},
+		{name: "unknown provider", query: "?providers=staging", wantStatus: http.StatusBadRequest},
+		{name: "no matching provider", query: "?selector=env=staging", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev := newSecretsProvider()
+			defer dev.Close()
+			prod := newSecretsProvider()
+			defer prod.Close()
+
+			req, _ := http.NewRequest(http.MethodPost, "/system/secrets"+tt.query, strings.NewReader(`{"name": "db-password", "value": "s3cr3t"}`))
+			rr := httptest.NewRecorder()
+			MakeSecretHandler(newSecretsLookup(t, dev, prod))(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			if dev.Count("POST /system/secrets") != tt.wantDev || prod.Count("POST /system/secrets") != tt.wantProd {
+				t.Errorf("want %d requests to dev and %d to prod, got %v and %v", tt.wantDev, tt.wantProd, dev.Received(), prod.Received())
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				result := ReplicationResult{}
+				json.Unmarshal(rr.Body.Bytes(), &result)
+				if result.Secret != "db-password" || len(result.Providers) != tt.wantDev+tt.wantProd {
+					t.Errorf("want a result for each provider, got %s", rr.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func Test_Deploy_RejectsMissingSecrets(t *testing.T) {
+	dev := newSecretsProvider("db-password")
+	defer dev.Close()
+	prod := newSecretsProvider()
+	defer prod.Close()
+
+	providerLookup := newSecretsLookup(t, dev, prod)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "secret exists", body: `{"service": "echo", "secrets": ["db-password"]}`, wantStatus: http.StatusAccepted},
+		{name: "secret missing", body: `{"service": "echo", "secrets": ["db-password", "api-key"]}`, wantStatus: http.StatusBadRequest, wantBody: "api-key on provider dev"},
+		{name: "secret missing on a replica", body: `{"service": "echo", "secrets": ["db-password"], "annotations": {"com.openfaas.federation.replicas-on": "dev,prod"}}`, wantStatus: http.StatusBadRequest, wantBody: "db-password on provider prod"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+			}
+
+			req, _ := http.NewRequest(http.MethodPost, "/system/functions", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			MakeDeployHandler(proxy, providerLookup)(rr, req)
+
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("want status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			if !strings.Contains(rr.Body.String(), tt.wantBody) {
+				t.Errorf("want body containing %q, got %q", tt.wantBody, rr.Body.String())
+			}
+		})
+	}
+}
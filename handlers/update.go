@@ -1,27 +1,135 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 
 	"github.com/openfaas-incubator/faas-federation/routing"
+	types "github.com/openfaas/faas-provider/types"
+	"github.com/openfaas/faas/gateway/requests"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// MakeUpdateHandler update specified function
+// MakeUpdateHandler update specified function, an update which places the function on other
+// providers moves it to them
 func MakeUpdateHandler(proxy http.HandlerFunc, providerLookup routing.ProviderLookup) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Info("update request")
 
-		function, err := addToFunctionToCache(r, providerLookup)
+		function, err := readDeployment(r, providerLookup)
 		if err != nil {
-			log.Errorln("error during unmarshal of create function request. ", err)
+			log.Errorln("invalid update function request. ", err)
 			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
 			return
 		}
 
-		proxyDeployment(proxy, function, w, r)
+		key := routing.FunctionKey(function.Service, function.Namespace)
+		if previous, ok := providerLookup.GetFunction(key); ok && routing.FunctionKey(previous.Service, previous.Namespace) == key {
+			change, err := providerLookup.ResolvePlacementChange(previous, function)
+			if err != nil {
+				log.Errorln(err)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(err.Error()))
+				return
+			}
+
+			if change.Moved() {
+				moveDeployment(providerLookup, previous, function, change, w, r)
+				log.Infof("update request moved function %s", function.Service)
+				return
+			}
+		}
+
+		proxyDeployment(proxy, providerLookup, function, w, r)
 
 		log.Info("update request successful")
 	}
 }
+
+// moveDeployment deploys the function to the providers the update added, once every added
+// provider accepted it the function is updated on the providers it stays on, cached with its
+// new placement and deleted from the providers it was moved from. When an added provider
+// fails the function is deleted again from the added providers which accepted it, so that
+// it is left where it was and the cache still matches the providers
+func moveDeployment(providerLookup routing.ProviderLookup, previous *types.FunctionDeployment, function *types.FunctionDeployment, change *routing.PlacementChange, w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	body, _ := ioutil.ReadAll(r.Body)
+	bodyFor := func(string) []byte {
+		return body
+	}
+
+	deploy := r.WithContext(r.Context())
+	deploy.Method = http.MethodPost
+	added := replicateEach(providerLookup, change.Added, deploy, bodyFor)
+	if !allSucceeded(added) {
+		rollbackDeployment(providerLookup, function, change.Added, added, r)
+		writeReplicationResult(w, ReplicationResult{Function: function.Service, Providers: added})
+		return
+	}
+
+	results := append(added, replicateEach(providerLookup, change.Updated, r, bodyFor)...)
+	if anySucceeded(results) {
+		providerLookup.AddFunction(function)
+
+		remove := deleteRequest(r, previous)
+		results = append(results, replicateEach(providerLookup, change.Removed, remove, func(string) []byte {
+			return deleteBody(previous)
+		})...)
+	}
+
+	writeReplicationResult(w, ReplicationResult{Function: function.Service, Providers: results})
+}
+
+// rollbackDeployment deletes the function from the providers whose result shows they accepted
+// it, their results are marked as failed as the function is no longer deployed to them
+func rollbackDeployment(providerLookup routing.ProviderRegistry, function *types.FunctionDeployment, providers map[string]*url.URL, results []ProviderResult, r *http.Request) {
+	accepted := map[string]*url.URL{}
+	for _, v := range results {
+		if v.Succeeded() {
+			accepted[v.Provider] = providers[v.Provider]
+		}
+	}
+	if len(accepted) == 0 {
+		return
+	}
+
+	removed := map[string]ProviderResult{}
+	for _, v := range replicateEach(providerLookup, accepted, deleteRequest(r, function), func(string) []byte {
+		return deleteBody(function)
+	}) {
+		removed[v.Provider] = v
+	}
+
+	for i, v := range results {
+		rollback, ok := removed[v.Provider]
+		if !ok {
+			continue
+		}
+
+		if rollback.Succeeded() {
+			results[i].Error = "rolled back as the function could not be deployed to every new provider"
+		} else {
+			log.Errorf("unable to roll back function %s on provider %s, status code: %d, error: %s", function.Service, v.Provider, rollback.StatusCode, rollback.Error)
+			results[i].Error = fmt.Sprintf("roll back failed, status code: %d, error: %s", rollback.StatusCode, rollback.Error)
+		}
+	}
+}
+
+// deleteRequest returns a request deleting the function, based on r
+func deleteRequest(r *http.Request, f *types.FunctionDeployment) *http.Request {
+	remove := r.WithContext(r.Context())
+	remove.Method = http.MethodDelete
+	remove.URL = namespaceURL(r.URL, f.Namespace)
+	return remove
+}
+
+// deleteBody returns the body of a request deleting the function
+func deleteBody(f *types.FunctionDeployment) []byte {
+	body, _ := json.Marshal(requests.DeleteFunctionRequest{FunctionName: f.Service})
+	return body
+}
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/openfaas-incubator/faas-federation/routing"
 	acc "github.com/openfaas-incubator/faas-federation/testing"
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
 	"github.com/openfaas/faas-provider/proxy"
+	types "github.com/openfaas/faas-provider/types"
 )
 
 func Test_Update(t *testing.T) {
@@ -23,7 +26,7 @@ func Test_Update(t *testing.T) {
 	mux.NewRouter()
 	rr := httptest.NewRecorder()
 
-	providerLookup, err := routing.NewDefaultProviderRouting([]string{"http://faas-provider-a:8082", "http://faas-provider-b:8083"}, "http://faas-provider-a:8082")
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082", nil, "http://faas-provider-a:8082", "http://faas-provider-b:8083"), routing.Config{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -37,4 +40,193 @@ func Test_Update(t *testing.T) {
 	}
 }
 
+func Test_Update_ReplacesWeights(t *testing.T) {
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082", nil, "http://faas-provider-a:8082", "http://faas-provider-b:8083"), routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxied := false
+	proxyFunc := func(w http.ResponseWriter, r *http.Request) {
+		proxied = true
+		w.WriteHeader(http.StatusOK)
+	}
+
+	req, err := http.NewRequest("PUT", "/system/functions", bytes.NewBuffer([]byte(echoUpdateWithWeights)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	MakeUpdateHandler(proxyFunc, providerLookup).ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	if !proxied {
+		t.Error("want update to be proxied")
+	}
+
+	weights, ok := providerLookup.GetFunctionWeights("echo-a")
+	if !ok {
+		t.Fatal("want weights for echo-a in the cache")
+	}
+
+	want := []routing.ProviderWeight{{Provider: "faas-provider-a", Weight: 50}, {Provider: "faas-provider-b", Weight: 50}}
+	for i := range want {
+		if weights[i] != want[i] {
+			t.Errorf("want %v, got %v", want[i], weights[i])
+		}
+	}
+}
+
+func Test_Update_MovesFunction(t *testing.T) {
+	tests := []struct {
+		name         string
+		deployStatus int
+		wantStatus   int
+		wantProvider string
+		wantA        []string
+	}{
+		{
+			name:         "deployed to the new provider",
+			deployStatus: http.StatusAccepted,
+			wantStatus:   http.StatusOK,
+			wantProvider: "localhost",
+			wantA:        []string{`DELETE /system/functions {"functionName":"echo-a"}`},
+		},
+		{
+			name:         "deployment to the new provider fails",
+			deployStatus: http.StatusInternalServerError,
+			wantStatus:   http.StatusBadGateway,
+			wantProvider: "127.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			providerA := providertest.New(http.StatusOK)
+			defer providerA.Close()
+			providerB := providertest.New(tt.deployStatus)
+			defer providerB.Close()
+
+			providerLookup, err := routing.NewDefaultProviderRouting(testProviders(providerA.HostURL("127.0.0.1"), nil, providerA.HostURL("127.0.0.1"), providerB.HostURL("localhost")), routing.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			providerLookup.AddFunction(&types.FunctionDeployment{
+				Service:     "echo-a",
+				Annotations: &map[string]string{"com.openfaas.federation.gateway": "127.0.0.1"},
+			})
+
+			proxyFunc := func(w http.ResponseWriter, r *http.Request) {
+				t.Error("a moved function should not be proxied")
+			}
+
+			req, _ := http.NewRequest("PUT", "/system/functions", bytes.NewBuffer([]byte(echoUpdateMoved)))
+			rr := httptest.NewRecorder()
+			MakeUpdateHandler(proxyFunc, providerLookup).ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("want status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+
+			if len(providerB.Received()) != 1 || !strings.HasPrefix(providerB.Received()[0], "POST /system/functions {") {
+				t.Errorf("want the function deployed to the new provider, got %v", providerB.Received())
+			}
+
+			if strings.Join(providerA.Received(), ";") != strings.Join(tt.wantA, ";") {
+				t.Errorf("want the previous provider to receive %v, got %v", tt.wantA, providerA.Received())
+			}
+
+			providerURL, err := providerLookup.Resolve("echo-a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if providerURL.Hostname() != tt.wantProvider {
+				t.Errorf("want echo-a routed to %s, got %s", tt.wantProvider, providerURL.Hostname())
+			}
+		})
+	}
+}
+
+func Test_Update_MoveRollsBackPartialFailure(t *testing.T) {
+	providerA := providertest.New(http.StatusOK)
+	defer providerA.Close()
+	providerB := providertest.New(http.StatusOK)
+	defer providerB.Close()
+	providerC := providertest.New(http.StatusInternalServerError)
+	defer providerC.Close()
+	providerD := providertest.New(http.StatusAccepted)
+	defer providerD.Close()
+
+	providerLookup, err := routing.NewDefaultProviderRouting([]routing.Provider{
+		{Name: "a", URL: providerA.URL, Default: true},
+		{Name: "b", URL: providerB.URL},
+		{Name: "c", URL: providerC.URL},
+		{Name: "d", URL: providerD.URL},
+	}, routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := &types.FunctionDeployment{Service: "echo-a", Annotations: &map[string]string{"com.openfaas.federation.replicas-on": "a,b"}}
+	providerLookup.AddFunction(previous)
+
+	proxyFunc := func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a moved function should not be proxied")
+	}
+
+	body := `{"service":"echo-a","image":"openfaas/echo:latest","annotations":{"com.openfaas.federation.replicas-on":"a,c,d"}}`
+	req, _ := http.NewRequest("PUT", "/system/functions", bytes.NewBufferString(body))
+	rr := httptest.NewRecorder()
+	MakeUpdateHandler(proxyFunc, providerLookup).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("want status %d, got %d: %s", http.StatusBadGateway, rr.Code, rr.Body.String())
+	}
+
+	want := []string{
+		"POST /system/functions " + body,
+		`DELETE /system/functions {"functionName":"echo-a"}`,
+	}
+	if got := providerD.Received(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want the function rolled back on d, got %v", got)
+	}
+
+	// the providers the function was placed on must still hold the cached version
+	if got := providerA.Received(); len(got) > 0 {
+		t.Errorf("want the function left untouched on a, got %v", got)
+	}
+	if got := providerB.Received(); len(got) > 0 {
+		t.Errorf("want the function left untouched on b, got %v", got)
+	}
+	if f, ok := providerLookup.GetFunction("echo-a"); !ok || f != previous {
+		t.Errorf("want the previous placement to stay cached, got %v", f)
+	}
+}
+
+func Test_Update_FailureKeepsCache(t *testing.T) {
+	providerLookup, err := routing.NewDefaultProviderRouting(testProviders("http://faas-provider-a:8082", nil, "http://faas-provider-a:8082"), routing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	providerLookup.AddFunction(&types.FunctionDeployment{Service: "echo-a", Image: "openfaas/echo:0.1"})
+
+	proxyFunc := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	req, _ := http.NewRequest("PUT", "/system/functions", bytes.NewBuffer([]byte(echoUpdate)))
+	rr := httptest.NewRecorder()
+	MakeUpdateHandler(proxyFunc, providerLookup).ServeHTTP(rr, req)
+
+	f, ok := providerLookup.GetFunction("echo-a")
+	if !ok || f.Image != "openfaas/echo:0.1" {
+		t.Errorf("want a failed update to keep the cached function, got %v", f)
+	}
+}
+
+const echoUpdateMoved = `{"service":"echo-a","image":"openfaas/echo:latest","annotations":{"com.openfaas.federation.gateway":"localhost"}}`
+
 const echoUpdate = `{"service":"echo-a","image":"openfaas/echo:latest","network":"","envProcess":"./handler","envVars":{},"constraints":null,"secrets":[],"labels":{},"annotations":{},"limits":null,"requests":null,"readOnlyRootFilesystem":false}`
+
+const echoUpdateWithWeights = `{"service":"echo-a","image":"openfaas/echo:latest","annotations":{"com.openfaas.federation.weights":"faas-provider-a=50,faas-provider-b=50"}}`
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"math/rand"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/openfaas-incubator/faas-federation/handlers"
+	"github.com/openfaas-incubator/faas-federation/metrics"
 	"github.com/openfaas-incubator/faas-federation/routing"
 	"github.com/openfaas-incubator/faas-federation/types"
 	"github.com/openfaas-incubator/faas-federation/version"
 	bootstrap "github.com/openfaas/faas-provider"
-	"github.com/openfaas/faas-provider/proxy"
 
 	bootTypes "github.com/openfaas/faas-provider/types"
 	log "github.com/sirupsen/logrus"
@@ -36,6 +43,8 @@ func init() {
 	if level, err := log.ParseLevel(logLevel); err == nil {
 		log.SetLevel(level)
 	}
+
+	rand.Seed(time.Now().UnixNano())
 }
 
 func main() {
@@ -44,31 +53,110 @@ func main() {
 
 	readConfig := types.ReadConfig{}
 	osEnv := types.OsEnv{}
-	cfg := readConfig.Read(osEnv)
+	cfg, err := readConfig.Read(osEnv)
+	if err != nil {
+		panic(fmt.Errorf("could not read config, error: %v", err))
+	}
 
-	providerLookup, err := routing.NewDefaultProviderRouting(cfg.Providers, cfg.DefaultProvider)
+	providerLookup, err := routing.NewDefaultProviderRouting(toRoutingProviders(cfg.Providers), routing.Config{
+		CircuitBreaker: routing.CircuitBreakerConfig{
+			FailureThreshold: cfg.CircuitBreakerFailureThreshold,
+			OpenTimeout:      cfg.CircuitBreakerOpenTimeout,
+			HalfOpenRequests: cfg.CircuitBreakerHalfOpenRequests,
+		},
+		CacheMiss: routing.CacheMissConfig{
+			NegativeTTL:       cfg.CacheMissNegativeTTL,
+			MinReloadInterval: cfg.CacheMissReloadInterval,
+		},
+		Timeout: cfg.ReadTimeout,
+	})
 	if err != nil {
 		panic(fmt.Errorf("could not create provider lookup, error: %v", err))
 	}
 
-	err = providerLookup.ReloadCache()
+	metrics.Default.SetLimits(metrics.Limits{
+		MaxFunctions: cfg.MetricsMaxFunctions,
+		MaxSeries:    cfg.MetricsMaxSeries,
+	})
+
+	reconciler := routing.NewCacheReconciler(providerLookup, cfg.CacheSnapshotPath)
+	restored := 0
+	if len(cfg.CacheSnapshotPath) > 0 {
+		if restored, err = routing.LoadCacheSnapshot(providerLookup, cfg.CacheSnapshotPath); err != nil {
+			log.Warnf("could not restore the function cache snapshot, error: %v", err)
+		}
+	}
+
+	// done is closed on shutdown to stop the background work
+	done := make(chan struct{})
+	shutdownSignals := make(chan os.Signal, 1)
+	signal.Notify(shutdownSignals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		s := <-shutdownSignals
+		log.Infof("received %s, shutting down", s)
+		close(done)
+	}()
+
+	if cfg.HealthCheckInterval > 0 {
+		healthChecker := routing.NewHealthChecker(providerLookup, cfg.HealthCheckTimeout, cfg.HealthCheckFailureThreshold)
+		go healthChecker.Run(cfg.HealthCheckInterval, done)
+	}
+
+	// a restored cache routes functions straight away while the providers are listed
+	refreshCache := func() {
+		if err := reconciler.Reconcile(context.Background()); err != nil {
+			log.Errorf("could not refresh the function cache, error: %v", err)
+		}
+	}
+	if restored > 0 {
+		go refreshCache()
+	} else {
+		refreshCache()
+	}
+
+	if cfg.CacheReconcileInterval > 0 {
+		go reconciler.Run(cfg.CacheReconcileInterval, done)
+	}
+
+	reloader := routing.NewProviderReloader(providerLookup, func() ([]routing.Provider, error) {
+		reloaded, err := readConfig.Read(osEnv)
+		if err != nil {
+			return nil, err
+		}
+
+		return toRoutingProviders(reloaded.Providers), nil
+	})
+
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	go reloader.Watch(cfg.ProvidersConfigPath, cfg.ProvidersConfigReloadInterval, reloadSignals, done)
+
+	proxyFunc := handlers.MakeControlPlaneProxy(providerLookup, cfg.ReadTimeout)
+
+	replicaDistribution, err := routing.NewReplicaDistribution(cfg.ReplicaDistribution)
 	if err != nil {
-		panic(fmt.Errorf("could not reload provider cache, error: %v", err))
+		panic(fmt.Errorf("could not create replica distribution, error: %v", err))
 	}
 
-	proxyFunc := proxy.NewHandlerFunc(cfg.ReadTimeout,
-		handlers.NewFunctionLookup(providerLookup))
+	retryConfig := handlers.RetryConfig{
+		MaxAttempts:  cfg.RetryMaxAttempts,
+		MaxBodyBytes: cfg.RetryMaxBodyBytes,
+		BudgetRatio:  cfg.RetryBudgetRatio,
+	}
 
 	bootstrapHandlers := bootTypes.FaaSHandlers{
-		FunctionProxy:  handlers.MakeProxyHandler(proxyFunc),
-		DeleteHandler:  handlers.MakeDeleteHandler(proxyFunc),
-		DeployHandler:  handlers.MakeDeployHandler(proxyFunc, providerLookup),
-		FunctionReader: handlers.MakeFunctionReader(cfg.Providers),
-		ReplicaReader:  handlers.MakeReplicaReader(),
-		ReplicaUpdater: handlers.MakeReplicaUpdater(),
-		UpdateHandler:  handlers.MakeUpdateHandler(proxyFunc, providerLookup),
-		HealthHandler:  handlers.MakeHealthHandler(),
-		InfoHandler:    handlers.MakeInfoHandler(version.BuildVersion(), version.GitCommitSHA),
+		FunctionProxy:        handlers.MakeProxyHandler(providerLookup, cfg.ReadTimeout, retryConfig),
+		DeleteHandler:        handlers.MakeDeleteHandler(proxyFunc, providerLookup),
+		DeployHandler:        handlers.MakeDeployHandler(proxyFunc, providerLookup),
+		FunctionReader:       handlers.MakeFunctionReader(providerLookup),
+		ReplicaReader:        handlers.MakeReplicaReader(proxyFunc, providerLookup),
+		ReplicaUpdater:       handlers.MakeReplicaUpdater(proxyFunc, providerLookup, replicaDistribution),
+		SecretHandler:        handlers.MakeSecretHandler(providerLookup),
+		LogHandler:           handlers.MakeLogHandler(providerLookup),
+		ListNamespaceHandler: handlers.MakeNamespaceHandler(providerLookup),
+		UpdateHandler:        handlers.MakeUpdateHandler(proxyFunc, providerLookup),
+		HealthHandler:        handlers.MakeHealthHandler(),
+		InfoHandler:          handlers.MakeInfoHandler(version.BuildVersion(), version.GitCommitSHA),
 	}
 
 	bootstrapConfig := bootTypes.FaaSConfig{
@@ -76,9 +164,69 @@ func main() {
 		WriteTimeout:    cfg.WriteTimeout,
 		TCPPort:         &cfg.Port,
 		EnableHealth:    true,
-		EnableBasicAuth: false,
+		EnableBasicAuth: cfg.EnableBasicAuth,
+		SecretMountPath: cfg.SecretMountPath,
+	}
+
+	bootstrap.Router().HandleFunc("/system/federation/providers", handlers.MakeProvidersHandler(providerLookup, cfg.ProviderCredentialsDir)).
+		Methods(http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete)
+	bootstrap.Router().HandleFunc("/system/federation/replicas/{name:["+bootstrap.NameExpression+"]+}", handlers.MakeReplicaStatusHandler(providerLookup, replicaDistribution)).
+		Methods(http.MethodGet)
+	bootstrap.Router().HandleFunc("/system/federation/cache", handlers.MakeCacheHandler(providerLookup)).
+		Methods(http.MethodGet)
+	bootstrap.Router().HandleFunc("/system/federation/events", handlers.MakeEventsHandler(providerLookup, cfg.WriteTimeout)).
+		Methods(http.MethodGet)
+	bootstrap.Router().HandleFunc("/metrics", metrics.Default.Handler()).
+		Methods(http.MethodGet)
+
+	var jwtAuth mux.MiddlewareFunc
+	if len(cfg.JWTJWKS) > 0 {
+		validator, err := handlers.NewJWTValidator(handlers.JWTConfig{
+			JWKS:     cfg.JWTJWKS,
+			Issuer:   cfg.JWTIssuer,
+			Audience: cfg.JWTAudience,
+		})
+		if err != nil {
+			panic(fmt.Errorf("could not create JWT validator, error: %v", err))
+		}
+
+		jwtAuth = handlers.MakeJWTAuthMiddleware(validator, cfg.JWTPermissionsClaim, providerLookup)
+	}
+
+	log.Infof("listening on port %d, TLS: %t, basic auth: %t, JWT auth: %t", cfg.Port, len(cfg.TLSCertFile) > 0, cfg.EnableBasicAuth, jwtAuth != nil)
+	if err := serve(&bootstrapHandlers, &bootstrapConfig, cfg.TLSCertFile, cfg.TLSKeyFile, jwtAuth, done); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func toRoutingProviders(providers []types.ProviderConfig) []routing.Provider {
+	var result []routing.Provider
+	for _, p := range providers {
+		provider := routing.Provider{
+			Name:       p.Name,
+			URL:        p.URL,
+			Labels:     p.Labels,
+			Timeout:    time.Duration(p.Timeout),
+			Default:    p.Default,
+			Namespaces: p.Namespaces,
+		}
+		if p.Credentials != nil {
+			provider.Credentials = &routing.Credentials{
+				SecretMountPath: p.Credentials.SecretMountPath,
+				TokenFile:       p.Credentials.TokenFile,
+			}
+		}
+		if p.TLS != nil {
+			provider.TLS = &routing.TLSConfig{
+				CAFile:     p.TLS.CAFile,
+				CertFile:   p.TLS.CertFile,
+				KeyFile:    p.TLS.KeyFile,
+				ServerName: p.TLS.ServerName,
+			}
+		}
+
+		result = append(result, provider)
 	}
 
-	log.Infof("listening on port %d", cfg.Port)
-	bootstrap.Serve(&bootstrapHandlers, &bootstrapConfig)
+	return result
 }
@@ -0,0 +1,34 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+// Default is the registry of the federation's metrics, its limits are set from the configuration
+var Default = NewRegistry(Limits{MaxFunctions: 500, MaxSeries: 5000})
+
+var (
+	// Invocations of functions by the provider they were proxied to and the status code
+	Invocations = Default.NewCounter("faas_federation_invocations_total",
+		"Function invocations proxied to a provider, by status code.", "function", "provider", "code")
+	// InvocationDuration of functions by the provider they were proxied to
+	InvocationDuration = Default.NewHistogram("faas_federation_invocation_duration_seconds",
+		"Duration of function invocations proxied to a provider.", DefaultBuckets, "function", "provider")
+
+	// CacheLookups of functions being resolved, by whether they were cached
+	CacheLookups = Default.NewCounter("faas_federation_cache_lookups_total",
+		"Lookups of functions in the function cache, by result hit or miss.", "result")
+	// CacheReloads triggered by lookups of functions which were not cached
+	CacheReloads = Default.NewCounter("faas_federation_cache_reloads_total",
+		"Reloads of the function cache triggered by lookups of functions which were not cached.")
+
+	// ListingDuration of the requests listing the functions of each provider
+	ListingDuration = Default.NewHistogram("faas_federation_provider_listing_duration_seconds",
+		"Duration of listing the functions of a provider.", DefaultBuckets, "provider")
+	// ListingErrors of the requests listing the functions of each provider
+	ListingErrors = Default.NewCounter("faas_federation_provider_listing_errors_total",
+		"Failed attempts to list the functions of a provider.", "provider")
+
+	// ControlPlaneOperations sent to each provider, by operation and result
+	ControlPlaneOperations = Default.NewCounter("faas_federation_control_plane_operations_total",
+		"Deploy, update and delete requests sent to a provider, by result success or failure.", "operation", "provider", "result")
+)
@@ -0,0 +1,305 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package metrics records counters and histograms and exposes them in the Prometheus text
+// format, with the number of label values bounded so that the series can not grow without limit
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// functionLabel is the label whose distinct values are limited by Limits.MaxFunctions
+	functionLabel = "function"
+	// otherFunction is the value of the function label for functions beyond the limit
+	otherFunction = "other"
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Limits bound the cardinality of the recorded series
+type Limits struct {
+	// MaxFunctions is the number of distinct values of the function label, later functions
+	// are recorded as "other". 0 records every function as "other"
+	MaxFunctions int
+	// MaxSeries of each metric, observations of new label values beyond it are dropped
+	MaxSeries int
+}
+
+// Registry holds the metrics of the federation
+type Registry struct {
+	limits    Limits
+	metrics   []*metric
+	functions map[string]bool
+	dropped   uint64
+	lock      sync.Mutex
+}
+
+// NewRegistry creates a Registry with the given limits
+func NewRegistry(limits Limits) *Registry {
+	return &Registry{limits: limits, functions: make(map[string]bool)}
+}
+
+// SetLimits replaces the limits, series which were already recorded are kept
+func (r *Registry) SetLimits(limits Limits) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.limits = limits
+}
+
+// metric is a counter or a histogram with its series keyed by their label values
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	values []string
+	// count is the value of a counter, or the number of observations of a histogram
+	count   float64
+	sum     float64
+	buckets []uint64
+}
+
+// Counter is a metric whose value only increases
+type Counter struct {
+	registry *Registry
+	metric   *metric
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	registry *Registry
+	metric   *metric
+}
+
+// NewCounter registers a counter with the given label names
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{registry: r, metric: r.register(name, help, "counter", nil, labels)}
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds and label names
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{registry: r, metric: r.register(name, help, "histogram", buckets, labels)}
+}
+
+func (r *Registry) register(name string, help string, kind string, buckets []float64, labels []string) *metric {
+	m := &metric{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.metrics = append(r.metrics, m)
+
+	return m
+}
+
+// Inc adds one to the counter for the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v to the counter for the label values
+func (c *Counter) Add(v float64, values ...string) {
+	c.registry.lock.Lock()
+	defer c.registry.lock.Unlock()
+
+	if s := c.registry.series(c.metric, values); s != nil {
+		s.count += v
+	}
+}
+
+// Observe records v in the histogram for the label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.registry.lock.Lock()
+	defer h.registry.lock.Unlock()
+
+	s := h.registry.series(h.metric, values)
+	if s == nil {
+		return
+	}
+
+	s.count++
+	s.sum += v
+	for i, upper := range h.metric.buckets {
+		if v <= upper {
+			s.buckets[i]++
+		}
+	}
+}
+
+// series returns the series of the metric for the label values, creating it unless the
+// metric has reached its series limit. Callers must hold the lock
+func (r *Registry) series(m *metric, values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", m.name, len(m.labels), len(values)))
+	}
+
+	bounded := make([]string, len(values))
+	for i, v := range values {
+		if m.labels[i] == functionLabel {
+			v = r.function(v)
+		}
+		bounded[i] = v
+	}
+
+	key := strings.Join(bounded, "\xff")
+	if s, ok := m.series[key]; ok {
+		return s
+	}
+
+	if r.limits.MaxSeries > 0 && len(m.series) >= r.limits.MaxSeries {
+		r.dropped++
+		return nil
+	}
+
+	s := &series{values: bounded, buckets: make([]uint64, len(m.buckets))}
+	m.series[key] = s
+	return s
+}
+
+// function returns the value of the function label, functions beyond the limit share one
+// value. Callers must hold the lock
+func (r *Registry) function(name string) string {
+	if r.functions[name] {
+		return name
+	}
+
+	if len(r.functions) >= r.limits.MaxFunctions {
+		return otherFunction
+	}
+
+	r.functions[name] = true
+	return name
+}
+
+// DeleteFunction drops the series labelled with the function, so that the series of removed
+// functions do not accumulate, and frees its place among the functions labelled by name. The
+// series of functions labelled "other" are kept
+func (r *Registry) DeleteFunction(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.functions[name] {
+		return
+	}
+	delete(r.functions, name)
+
+	for _, m := range r.metrics {
+		for i, label := range m.labels {
+			if label != functionLabel {
+				continue
+			}
+
+			for key, s := range m.series {
+				if s.values[i] == name {
+					delete(m.series, key)
+				}
+			}
+		}
+	}
+}
+
+// Write writes the metrics in the Prometheus text format
+func (r *Registry) Write(w io.Writer) error {
+	r.lock.Lock()
+	var b bytes.Buffer
+	for _, m := range r.metrics {
+		m.write(&b)
+	}
+
+	fmt.Fprintf(&b, "# HELP faas_federation_metrics_dropped_total Observations dropped because a metric reached its series limit.\n")
+	fmt.Fprintf(&b, "# TYPE faas_federation_metrics_dropped_total counter\n")
+	fmt.Fprintf(&b, "faas_federation_metrics_dropped_total %d\n", r.dropped)
+	r.lock.Unlock()
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// Handler serves the metrics in the Prometheus text format
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(http.StatusOK)
+		r.Write(w)
+	}
+}
+
+func (m *metric) write(b *bytes.Buffer) {
+	fmt.Fprintf(b, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", m.name, m.kind)
+
+	var keys []string
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := m.series[k]
+		if m.kind == "counter" {
+			fmt.Fprintf(b, "%s%s %s\n", m.name, formatLabels(m.labels, s.values, "", ""), formatValue(s.count))
+			continue
+		}
+
+		for i, upper := range m.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.values, "le", formatValue(upper)), s.buckets[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %s\n", m.name, formatLabels(m.labels, s.values, "le", "+Inf"), formatValue(s.count))
+		fmt.Fprintf(b, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.values, "", ""), formatValue(s.sum))
+		fmt.Fprintf(b, "%s_count%s %s\n", m.name, formatLabels(m.labels, s.values, "", ""), formatValue(s.count))
+	}
+}
+
+// formatLabels formats the label pairs of a series, with an extra label when extraName is set
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabelValue(values[i])+`"`)
+	}
+	if len(extraName) > 0 {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,148 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Registry_Write(t *testing.T) {
+	r := NewRegistry(Limits{MaxFunctions: 10})
+	invocations := r.NewCounter("invocations_total", "Invocations.", "function", "code")
+	duration := r.NewHistogram("duration_seconds", "Duration.", []float64{0.1, 1}, "function")
+
+	invocations.Inc("echo", "200")
+	invocations.Add(2, "echo", "200")
+	invocations.Inc("say \"hi\"", "500")
+	duration.Observe(0.05, "echo")
+	duration.Observe(0.5, "echo")
+
+	var b bytes.Buffer
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`# TYPE invocations_total counter`,
+		`invocations_total{function="echo",code="200"} 3`,
+		`invocations_total{function="say \"hi\"",code="500"} 1`,
+		`# TYPE duration_seconds histogram`,
+		`duration_seconds_bucket{function="echo",le="0.1"} 1`,
+		`duration_seconds_bucket{function="echo",le="1"} 2`,
+		`duration_seconds_bucket{function="echo",le="+Inf"} 2`,
+		`duration_seconds_sum{function="echo"} 0.55`,
+		`duration_seconds_count{function="echo"} 2`,
+		`faas_federation_metrics_dropped_total 0`,
+	}
+	for _, line := range want {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("want line %s, got:\n%s", line, b.String())
+		}
+	}
+}
+
+func Test_Registry_Limits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		want   []string
+	}{
+		{
+			name:   "functions beyond the limit are other",
+			limits: Limits{MaxFunctions: 2},
+			want: []string{
+				`invocations_total{function="a",provider="p"} 1`,
+				`invocations_total{function="b",provider="p"} 1`,
+				`invocations_total{function="other",provider="p"} 2`,
+				`faas_federation_metrics_dropped_total 0`,
+			},
+		},
+		{
+			name:   "series beyond the limit are dropped",
+			limits: Limits{MaxFunctions: 10, MaxSeries: 2},
+			want: []string{
+				`invocations_total{function="a",provider="p"} 1`,
+				`invocations_total{function="b",provider="p"} 1`,
+				`faas_federation_metrics_dropped_total 2`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry(tt.limits)
+			invocations := r.NewCounter("invocations_total", "Invocations.", "function", "provider")
+			for _, f := range []string{"a", "b", "c", "d"} {
+				invocations.Inc(f, "p")
+			}
+
+			var b bytes.Buffer
+			r.Write(&b)
+
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+				if !strings.HasPrefix(line, "#") {
+					got = append(got, line)
+				}
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("want:\n%s\ngot:\n%s", strings.Join(tt.want, "\n"), strings.Join(got, "\n"))
+			}
+		})
+	}
+}
+
+func Test_Registry_DeleteFunction(t *testing.T) {
+	r := NewRegistry(Limits{MaxFunctions: 2})
+	invocations := r.NewCounter("invocations_total", "Invocations.", "function", "provider")
+	duration := r.NewHistogram("invocation_duration_seconds", "Duration.", []float64{1}, "function")
+	for _, f := range []string{"a", "b", "c"} {
+		invocations.Inc(f, "p")
+		duration.Observe(0.5, f)
+	}
+
+	r.DeleteFunction("a")
+	r.DeleteFunction("c")
+	invocations.Inc("d", "p")
+
+	var b bytes.Buffer
+	r.Write(&b)
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if strings.HasPrefix(line, "invocations_total") || strings.HasPrefix(line, "invocation_duration_seconds_count") {
+			got = append(got, line)
+		}
+	}
+
+	want := []string{
+		`invocations_total{function="b",provider="p"} 1`,
+		`invocations_total{function="d",provider="p"} 1`,
+		`invocations_total{function="other",provider="p"} 1`,
+		`invocation_duration_seconds_count{function="b"} 1`,
+		`invocation_duration_seconds_count{function="other"} 1`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func Test_Registry_Handler(t *testing.T) {
+	r := NewRegistry(Limits{})
+	r.NewCounter("reloads_total", "Reloads.").Inc()
+
+	rr := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if contentType := rr.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4" {
+		t.Errorf("want Content-Type text/plain; version=0.0.4, got %s", contentType)
+	}
+	if !strings.Contains(rr.Body.String(), "reloads_total 1\n") {
+		t.Errorf("want reloads_total 1, got:\n%s", rr.Body.String())
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a provider's circuit breaker
+type CircuitState string
+
+const (
+	// CircuitClosed invocations flow to the provider
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen invocations are short-circuited until the open timeout passes
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen a limited number of invocations are let through to probe the provider
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// CircuitBreakerConfig configures the circuit breaker of each provider
+type CircuitBreakerConfig struct {
+	// FailureThreshold consecutive failures which open the circuit, 0 disables circuit breaking
+	FailureThreshold int
+	// OpenTimeout before an open circuit lets probe invocations through
+	OpenTimeout time.Duration
+	// HalfOpenRequests concurrent probe invocations allowed while half-open
+	HalfOpenRequests int
+}
+
+// CircuitBreakerStatus is a point in time view of a circuit breaker
+type CircuitBreakerStatus struct {
+	State               CircuitState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutiveFailures"`
+	OpenedAt            *time.Time   `json:"openedAt,omitempty"`
+}
+
+// CircuitBreaker tracks invocation failures for a single provider, a nil
+// CircuitBreaker always allows invocations
+type CircuitBreaker struct {
+	config   CircuitBreakerConfig
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	inFlight int
+	lock     sync.Mutex
+	now      func() time.Time
+}
+
+// NewCircuitBreaker creates a closed CircuitBreaker, nil is returned when the
+// failure threshold disables circuit breaking
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	if config.FailureThreshold <= 0 {
+		return nil
+	}
+
+	if config.HalfOpenRequests < 1 {
+		config.HalfOpenRequests = 1
+	}
+
+	return &CircuitBreaker{
+		config: config,
+		state:  CircuitClosed,
+		now:    time.Now,
+	}
+}
+
+// Available returns true when an invocation would be allowed, without reserving it
+func (b *CircuitBreaker) Available() bool {
+	if b == nil {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.advance()
+
+	return b.state != CircuitOpen && (b.state != CircuitHalfOpen || b.inFlight < b.config.HalfOpenRequests)
+}
+
+// Allow reserves an invocation, the caller must report its outcome with Record
+func (b *CircuitBreaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.advance()
+
+	switch b.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if b.inFlight >= b.config.HalfOpenRequests {
+			return false
+		}
+		b.inFlight++
+	}
+
+	return true
+}
+
+// Record reports the outcome of an invocation reserved with Allow
+func (b *CircuitBreaker) Record(success bool) {
+	if b == nil {
+		return
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == CircuitHalfOpen && b.inFlight > 0 {
+		b.inFlight--
+	}
+
+	if success {
+		b.state = CircuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.config.FailureThreshold {
+		b.state = CircuitOpen
+		b.openedAt = b.now()
+		b.inFlight = 0
+	}
+}
+
+// Status returns the current state of the circuit breaker
+func (b *CircuitBreaker) Status() CircuitBreakerStatus {
+	if b == nil {
+		return CircuitBreakerStatus{State: CircuitClosed}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.advance()
+
+	status := CircuitBreakerStatus{State: b.state, ConsecutiveFailures: b.failures}
+	if b.state != CircuitClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+
+	return status
+}
+
+// advance moves an open circuit to half-open once the open timeout has passed
+func (b *CircuitBreaker) advance() {
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.config.OpenTimeout {
+		b.state = CircuitHalfOpen
+		b.inFlight = 0
+	}
+}
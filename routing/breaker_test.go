@@ -0,0 +1,107 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestBreaker(now *time.Time) *CircuitBreaker {
+	b := NewCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 3, OpenTimeout: time.Second * 30, HalfOpenRequests: 1})
+	b.now = func() time.Time { return *now }
+
+	return b
+}
+
+func Test_CircuitBreaker_OpensAtThreshold(t *testing.T) {
+	now := time.Now()
+	b := newTestBreaker(&now)
+
+	for i := 0; i < 2; i++ {
+		if !b.Allow() {
+			t.Fatalf("want invocation %d to be allowed", i)
+		}
+		b.Record(false)
+	}
+
+	if got := b.Status().State; got != CircuitClosed {
+		t.Fatalf("want %s below the threshold, got %s", CircuitClosed, got)
+	}
+
+	b.Allow()
+	b.Record(false)
+
+	if got := b.Status().State; got != CircuitOpen {
+		t.Fatalf("want %s at the threshold, got %s", CircuitOpen, got)
+	}
+
+	if b.Allow() || b.Available() {
+		t.Error("want invocations to be short-circuited while open")
+	}
+}
+
+func Test_CircuitBreaker_SuccessResetsFailures(t *testing.T) {
+	now := time.Now()
+	b := newTestBreaker(&now)
+
+	b.Record(false)
+	b.Record(false)
+	b.Record(true)
+	b.Record(false)
+
+	if got := b.Status(); got.State != CircuitClosed || got.ConsecutiveFailures != 1 {
+		t.Errorf("want closed with 1 failure, got %s with %d", got.State, got.ConsecutiveFailures)
+	}
+}
+
+func Test_CircuitBreaker_HalfOpen(t *testing.T) {
+	tests := []struct {
+		name      string
+		probeOK   bool
+		wantState CircuitState
+	}{
+		{name: "successful probe closes the circuit", probeOK: true, wantState: CircuitClosed},
+		{name: "failed probe opens the circuit again", probeOK: false, wantState: CircuitOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			b := newTestBreaker(&now)
+			for i := 0; i < 3; i++ {
+				b.Record(false)
+			}
+
+			now = now.Add(time.Second * 31)
+			if got := b.Status().State; got != CircuitHalfOpen {
+				t.Fatalf("want %s after the open timeout, got %s", CircuitHalfOpen, got)
+			}
+
+			if !b.Allow() {
+				t.Fatal("want a probe invocation to be allowed")
+			}
+
+			if b.Allow() {
+				t.Fatal("want only one concurrent probe invocation")
+			}
+
+			b.Record(tt.probeOK)
+			if got := b.Status().State; got != tt.wantState {
+				t.Errorf("want %s, got %s", tt.wantState, got)
+			}
+		})
+	}
+}
+
+func Test_CircuitBreaker_DisabledIsNil(t *testing.T) {
+	b := NewCircuitBreaker(CircuitBreakerConfig{})
+	if b != nil {
+		t.Fatal("want nil circuit breaker when the failure threshold is 0")
+	}
+
+	b.Record(false)
+	if !b.Allow() || !b.Available() {
+		t.Error("want a nil circuit breaker to allow invocations")
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/openfaas/faas-provider/auth"
+)
+
+// Credentials authenticate the federation's calls to a provider, they are read from disk
+// for every call so that rotated secrets are picked up without a restart
+type Credentials struct {
+	// SecretMountPath containing basic-auth-user and basic-auth-password
+	SecretMountPath string `json:"secretMountPath,omitempty"`
+	// TokenFile containing a bearer token
+	TokenFile string `json:"tokenFile,omitempty"`
+}
+
+// Authorize replaces the Authorization header of req with the credentials. The header is
+// removed when c is nil so that the caller's own credentials are never sent to a provider
+func (c *Credentials) Authorize(req *http.Request) error {
+	req.Header.Del("Authorization")
+	if c == nil {
+		return nil
+	}
+
+	if len(c.TokenFile) > 0 {
+		token, err := ioutil.ReadFile(c.TokenFile)
+		if err != nil {
+			return fmt.Errorf("unable to read token file %s, error: %v", c.TokenFile, err)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+		return nil
+	}
+
+	if len(c.SecretMountPath) > 0 {
+		reader := auth.ReadBasicAuthFromDisk{SecretMountPath: c.SecretMountPath}
+		credentials, err := reader.Read()
+		if err != nil {
+			return fmt.Errorf("unable to read basic auth credentials from %s, error: %v", c.SecretMountPath, err)
+		}
+
+		req.SetBasicAuth(credentials.User, credentials.Password)
+	}
+
+	return nil
+}
@@ -0,0 +1,57 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_Credentials_Authorize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(path.Join(dir, "basic-auth-user"), []byte("admin\n"), 0600)
+	ioutil.WriteFile(path.Join(dir, "basic-auth-password"), []byte("secret\n"), 0600)
+	ioutil.WriteFile(path.Join(dir, "token"), []byte("abc123\n"), 0600)
+
+	tests := []struct {
+		name        string
+		credentials *Credentials
+		want        string
+		wantErr     bool
+	}{
+		{name: "no credentials removes the caller's header", credentials: nil, want: ""},
+		{name: "basic auth", credentials: &Credentials{SecretMountPath: dir}, want: "Basic YWRtaW46c2VjcmV0"},
+		{name: "bearer token", credentials: &Credentials{TokenFile: path.Join(dir, "token")}, want: "Bearer abc123"},
+		{name: "missing secret", credentials: &Credentials{SecretMountPath: path.Join(dir, "missing")}, wantErr: true},
+		{name: "missing token", credentials: &Credentials{TokenFile: path.Join(dir, "missing")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "http://faas-provider-a:8080/system/functions", nil)
+			req.SetBasicAuth("caller", "caller-password")
+
+			err := tt.credentials.Authorize(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Authorize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got := req.Header.Get("Authorization"); !tt.wantErr && got != tt.want {
+				t.Errorf("want Authorization %q, got %q", tt.want, got)
+			}
+
+			if tt.wantErr && len(req.Header.Get("Authorization")) > 0 {
+				t.Error("want the caller's Authorization header removed")
+			}
+		})
+	}
+}
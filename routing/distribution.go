@@ -0,0 +1,191 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	types "github.com/openfaas/faas-provider/types"
+)
+
+const (
+	federationDistributionAnnotation = "com.openfaas.federation.distribution"
+
+	// DistributionWeightLabel is the provider label holding the weight used by "weighted"
+	DistributionWeightLabel = "weight"
+	// DistributionCapacityLabel is the provider label holding the most replicas "burst" places on it
+	DistributionCapacityLabel = "capacity"
+)
+
+// DistributionPolicy splits the replicas of a scale request across the providers of a
+// function, the providers are in the order of the replicas-on annotation
+type DistributionPolicy interface {
+	Distribute(replicas uint64, providers []Provider) map[string]uint64
+}
+
+// EvenDistribution splits the replicas evenly, the remainder goes to the first providers
+type EvenDistribution struct{}
+
+// Distribute splits the replicas evenly
+func (EvenDistribution) Distribute(replicas uint64, providers []Provider) map[string]uint64 {
+	result := map[string]uint64{}
+	for i, p := range providers {
+		result[p.Name] = replicas / uint64(len(providers))
+		if uint64(i) < replicas%uint64(len(providers)) {
+			result[p.Name]++
+		}
+	}
+
+	return result
+}
+
+// WeightedDistribution splits the replicas in proportion to the weight in Label of each
+// provider, a provider without a valid weight has a weight of 1
+type WeightedDistribution struct {
+	Label string
+}
+
+// Distribute splits the replicas by weight, the replicas left over by rounding down go to
+// the providers with the largest remainders
+func (d WeightedDistribution) Distribute(replicas uint64, providers []Provider) map[string]uint64 {
+	weights := make([]uint64, len(providers))
+	var total uint64
+	for i, p := range providers {
+		weights[i] = 1
+		if v, err := strconv.ParseUint(p.Labels[d.Label], 10, 32); err == nil {
+			weights[i] = v
+		}
+		total += weights[i]
+	}
+
+	if total == 0 {
+		return EvenDistribution{}.Distribute(replicas, providers)
+	}
+
+	result := map[string]uint64{}
+	remainders := make([]uint64, len(providers))
+	var assigned uint64
+	for i, p := range providers {
+		result[p.Name] = replicas * weights[i] / total
+		remainders[i] = replicas * weights[i] % total
+		assigned += result[p.Name]
+	}
+
+	order := make([]int, len(providers))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]] > remainders[order[j]]
+	})
+
+	for i := uint64(0); i < replicas-assigned; i++ {
+		result[providers[order[i]].Name]++
+	}
+
+	return result
+}
+
+// BurstDistribution fills the first provider up to the capacity in CapacityLabel and bursts
+// the rest onto the next providers in turn, a provider without a capacity takes all the
+// remaining replicas and the last provider takes any replicas beyond the total capacity
+type BurstDistribution struct {
+	CapacityLabel string
+}
+
+// Distribute fills the providers in order
+func (d BurstDistribution) Distribute(replicas uint64, providers []Provider) map[string]uint64 {
+	result := map[string]uint64{}
+	remaining := replicas
+	for i, p := range providers {
+		take := remaining
+		if capacity, err := strconv.ParseUint(p.Labels[d.CapacityLabel], 10, 64); err == nil && capacity < take && i < len(providers)-1 {
+			take = capacity
+		}
+
+		result[p.Name] = take
+		remaining -= take
+	}
+
+	return result
+}
+
+// DefaultDistributionPolicies are the policies which can be named by the distribution
+// annotation of a function
+func DefaultDistributionPolicies() map[string]DistributionPolicy {
+	return map[string]DistributionPolicy{
+		"even":     EvenDistribution{},
+		"weighted": WeightedDistribution{Label: DistributionWeightLabel},
+		"burst":    BurstDistribution{CapacityLabel: DistributionCapacityLabel},
+	}
+}
+
+// ReplicaDistribution distributes the replicas of a function with the policy named by its
+// com.openfaas.federation.distribution annotation, or with the Default policy
+type ReplicaDistribution struct {
+	Policies map[string]DistributionPolicy
+	Default  string
+}
+
+// NewReplicaDistribution creates a ReplicaDistribution with DefaultDistributionPolicies
+func NewReplicaDistribution(defaultPolicy string) (*ReplicaDistribution, error) {
+	d := &ReplicaDistribution{Policies: DefaultDistributionPolicies(), Default: defaultPolicy}
+	if _, ok := d.Policies[defaultPolicy]; !ok {
+		return nil, fmt.Errorf("unknown replica distribution policy %q", defaultPolicy)
+	}
+
+	return d, nil
+}
+
+// PolicyName returns the name of the policy used for the function
+func (d *ReplicaDistribution) PolicyName(f *types.FunctionDeployment) string {
+	if f.Annotations != nil {
+		if v, ok := (*f.Annotations)[federationDistributionAnnotation]; ok {
+			return v
+		}
+	}
+
+	return d.Default
+}
+
+// Distribute splits replicas across the providers of the function
+func (d *ReplicaDistribution) Distribute(f *types.FunctionDeployment, providers []Provider, replicas uint64) (map[string]uint64, error) {
+	name := d.PolicyName(f)
+	policy, ok := d.Policies[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid %s annotation for function %s, unknown policy %q", federationDistributionAnnotation, f.Service, name)
+	}
+
+	if len(providers) == 0 {
+		return map[string]uint64{}, nil
+	}
+
+	return policy.Distribute(replicas, providers), nil
+}
+
+// ReplicaProviders returns the providers listed by the replicas-on annotation of the
+// function in order, or nil when the function is not replicated
+func ReplicaProviders(lookup ProviderRegistry, f *types.FunctionDeployment) ([]Provider, error) {
+	if f.Annotations == nil {
+		return nil, nil
+	}
+
+	v, ok := (*f.Annotations)[federationReplicasOnAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	var result []Provider
+	for _, name := range parseProviderNames(v) {
+		p, ok := lookup.GetProvider(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s annotation for function %s, provider %s does not exist", federationReplicasOnAnnotation, f.Service, name)
+		}
+		result = append(result, p)
+	}
+
+	return result, nil
+}
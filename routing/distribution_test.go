@@ -0,0 +1,77 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"fmt"
+	"testing"
+
+	types "github.com/openfaas/faas-provider/types"
+)
+
+func Test_DistributionPolicies(t *testing.T) {
+	providers := []Provider{
+		{Name: "on-prem", Labels: map[string]string{"weight": "3", "capacity": "4"}},
+		{Name: "cloud-a", Labels: map[string]string{"weight": "1", "capacity": "2"}},
+		{Name: "cloud-b"},
+	}
+
+	tests := []struct {
+		name     string
+		policy   DistributionPolicy
+		replicas uint64
+		want     map[string]uint64
+	}{
+		{name: "even", policy: EvenDistribution{}, replicas: 7, want: map[string]uint64{"on-prem": 3, "cloud-a": 2, "cloud-b": 2}},
+		{name: "even zero", policy: EvenDistribution{}, replicas: 0, want: map[string]uint64{"on-prem": 0, "cloud-a": 0, "cloud-b": 0}},
+		{name: "weighted", policy: WeightedDistribution{Label: "weight"}, replicas: 10, want: map[string]uint64{"on-prem": 6, "cloud-a": 2, "cloud-b": 2}},
+		{name: "weighted rounding", policy: WeightedDistribution{Label: "weight"}, replicas: 4, want: map[string]uint64{"on-prem": 2, "cloud-a": 1, "cloud-b": 1}},
+		{name: "burst within capacity", policy: BurstDistribution{CapacityLabel: "capacity"}, replicas: 3, want: map[string]uint64{"on-prem": 3, "cloud-a": 0, "cloud-b": 0}},
+		{name: "burst", policy: BurstDistribution{CapacityLabel: "capacity"}, replicas: 9, want: map[string]uint64{"on-prem": 4, "cloud-a": 2, "cloud-b": 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.Distribute(tt.replicas, providers)
+
+			var total uint64
+			for _, v := range got {
+				total += v
+			}
+			if total != tt.replicas {
+				t.Errorf("want %d replicas in total, got %d", tt.replicas, total)
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_ReplicaDistribution(t *testing.T) {
+	if _, err := NewReplicaDistribution("random"); err == nil {
+		t.Error("want error for an unknown default policy")
+	}
+
+	d, err := NewReplicaDistribution("even")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providers := []Provider{{Name: "a", Labels: map[string]string{"capacity": "1"}}, {Name: "b"}}
+	f := &types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationDistributionAnnotation: "burst"}}
+	got, err := d.Distribute(f, providers, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["a"] != 1 || got["b"] != 2 {
+		t.Errorf("want the burst policy of the annotation used, got %v", got)
+	}
+
+	(*f.Annotations)[federationDistributionAnnotation] = "random"
+	if _, err := d.Distribute(f, providers, 3); err == nil {
+		t.Error("want error for an unknown policy annotation")
+	}
+}
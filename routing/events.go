@@ -0,0 +1,168 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	types "github.com/openfaas/faas-provider/types"
+)
+
+// maxEvents is the number of recent events kept for subscribers which resume a stream
+const maxEvents = 1024
+
+// EventType is the kind of change to the federation's inventory
+type EventType string
+
+const (
+	// EventFunctionAdded a function was added to the cache
+	EventFunctionAdded EventType = "function-added"
+	// EventFunctionUpdated a cached function was deployed again with changes
+	EventFunctionUpdated EventType = "function-updated"
+	// EventFunctionRemoved a function was removed from the cache
+	EventFunctionRemoved EventType = "function-removed"
+	// EventProviderHealth the health status of a provider changed
+	EventProviderHealth EventType = "provider-health"
+)
+
+// Event is a change to the functions cached by the federation or to the health of a provider
+type Event struct {
+	ID   uint64    `json:"id"`
+	Type EventType `json:"type"`
+	Time time.Time `json:"time"`
+	// Function name qualified with its namespace, for function events
+	Function string `json:"function,omitempty"`
+	// Providers the function is placed on, for function events
+	Providers []string `json:"providers,omitempty"`
+	// Provider whose health changed, for provider events
+	Provider string `json:"provider,omitempty"`
+	// Status and PreviousStatus of the provider, for provider events
+	Status         ProviderStatus `json:"status,omitempty"`
+	PreviousStatus ProviderStatus `json:"previousStatus,omitempty"`
+}
+
+// EventLog keeps the most recent events so that a subscriber which reconnects with the ID of
+// the last event it received is sent the events it missed. IDs increase from the time the
+// log was created in microseconds, so the IDs of a restarted federation are later than those
+// it sent before, and stay below 2^53 so that JavaScript clients read them exactly
+type EventLog struct {
+	events   []Event
+	capacity int
+	// startID is the ID of the first event published to the log
+	startID     uint64
+	nextID      uint64
+	subscribers map[chan struct{}]bool
+	lock        sync.Mutex
+}
+
+// NewEventLog creates an EventLog keeping up to capacity events
+func NewEventLog(capacity int) *EventLog {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	startID := uint64(time.Now().UnixNano() / int64(time.Microsecond))
+	return &EventLog{
+		capacity:    capacity,
+		startID:     startID,
+		nextID:      startID,
+		subscribers: make(map[chan struct{}]bool),
+	}
+}
+
+// publish assigns the next ID to the event and notifies the subscribers
+func (l *EventLog) publish(e Event) {
+	if l == nil {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	e.ID = l.nextID
+	l.nextID++
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	if len(l.events) == l.capacity {
+		copy(l.events, l.events[1:])
+		l.events = l.events[:len(l.events)-1]
+	}
+	l.events = append(l.events, e)
+
+	for notify := range l.subscribers {
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// LastID returns the ID of the latest event, events after it have a greater ID
+func (l *EventLog) LastID() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.nextID - 1
+}
+
+// Since returns the events after the one with the given ID. It returns false when events
+// after it are no longer kept, or the ID was not issued by this log, in which case all of
+// the kept events are returned
+func (l *EventLog) Since(id uint64) ([]Event, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	complete := id+1 >= l.startID && id < l.nextID
+	if len(l.events) > 0 && id+1 < l.events[0].ID {
+		complete = false
+	}
+
+	var result []Event
+	for _, e := range l.events {
+		if e.ID > id || !complete {
+			result = append(result, e)
+		}
+	}
+
+	return result, complete
+}
+
+// Subscribe returns a channel which receives a value after events are published, and a
+// function to call when the subscriber is done
+func (l *EventLog) Subscribe() (<-chan struct{}, func()) {
+	notify := make(chan struct{}, 1)
+
+	l.lock.Lock()
+	l.subscribers[notify] = true
+	l.lock.Unlock()
+
+	return notify, func() {
+		l.lock.Lock()
+		delete(l.subscribers, notify)
+		l.lock.Unlock()
+	}
+}
+
+// Events returns the log of changes to the cached functions and to the health of the providers
+func (d *defaultProviderRouting) Events() *EventLog {
+	return d.events
+}
+
+// functionEvent describes a change to a cached function, callers must not hold the lock
+func (d *defaultProviderRouting) functionEvent(t EventType, key string, f *types.FunctionDeployment) Event {
+	e := Event{Type: t, Function: key, Providers: annotatedProviders(f)}
+	if providers, err := d.placement(f); err == nil {
+		e.Providers = nil
+		for name := range providers {
+			e.Providers = append(e.Providers, name)
+		}
+		sort.Strings(e.Providers)
+	}
+
+	return e
+}
@@ -0,0 +1,140 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+func Test_EventLog_Since(t *testing.T) {
+	l := NewEventLog(3)
+	start := l.LastID()
+	for i := 0; i < 5; i++ {
+		l.publish(Event{Type: EventFunctionAdded, Function: fmt.Sprintf("f%d", i)})
+	}
+
+	tests := []struct {
+		name         string
+		id           uint64
+		want         string
+		wantComplete bool
+	}{
+		{name: "latest event", id: start + 5, wantComplete: true},
+		{name: "resumed", id: start + 3, want: "f3,f4", wantComplete: true},
+		{name: "oldest kept", id: start + 2, want: "f2,f3,f4", wantComplete: true},
+		{name: "events no longer kept", id: start + 1, want: "f2,f3,f4"},
+		{name: "ID of a previous federation", id: 1, want: "f2,f3,f4"},
+		{name: "ID which was not issued", id: start + 10, want: "f2,f3,f4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, complete := l.Since(tt.id)
+			var got []string
+			for _, e := range events {
+				got = append(got, e.Function)
+			}
+
+			if strings.Join(got, ",") != tt.want || complete != tt.wantComplete {
+				t.Errorf("want %s complete %t, got %s complete %t", tt.want, tt.wantComplete, strings.Join(got, ","), complete)
+			}
+		})
+	}
+}
+
+func Test_NewEventLog_IDsAreExactInJavaScript(t *testing.T) {
+	previous := NewEventLog(1)
+	previous.publish(Event{Type: EventFunctionAdded})
+	time.Sleep(time.Millisecond)
+
+	l := NewEventLog(1)
+	if l.LastID() < previous.LastID() {
+		t.Errorf("want the IDs of a new log after %d, got %d", previous.LastID(), l.LastID())
+	}
+
+	// Number.MAX_SAFE_INTEGER, the largest integer a JavaScript client parses exactly
+	const maxSafeInteger = 1<<53 - 1
+	if l.LastID() > maxSafeInteger {
+		t.Errorf("want IDs up to %d, got %d", uint64(maxSafeInteger), l.LastID())
+	}
+}
+
+func Test_Events(t *testing.T) {
+	a := providertest.New(http.StatusOK)
+	a.Handle("/system/functions", http.StatusOK, `[]`)
+	defer a.Close()
+
+	d, err := NewDefaultProviderRouting([]Provider{
+		{Name: "a", URL: a.URL, Default: true},
+		{Name: "b", URL: "http://faas-provider-b:8080"},
+	}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notify, unsubscribe := d.Events().Subscribe()
+	defer unsubscribe()
+	start := d.Events().LastID()
+
+	d.AddFunction(&types.FunctionDeployment{Service: "echo", Image: "echo:1"})
+	d.AddFunction(&types.FunctionDeployment{Service: "echo", Image: "echo:1"})
+	d.AddFunction(&types.FunctionDeployment{Service: "echo", Image: "echo:2", Annotations: &map[string]string{federationProviderNameConstraint: "b"}})
+	d.AddFunction(&types.FunctionDeployment{Service: "cat", Annotations: &map[string]string{federationProviderNameConstraint: "a"}})
+	d.RemoveFunction("echo")
+	if _, err := d.ReconcileCache(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	d.UpdateProviderHealth(ProviderHealth{Name: "b", Status: ProviderStatusDown})
+	d.UpdateProviderHealth(ProviderHealth{Name: "b", Status: ProviderStatusDown})
+
+	select {
+	case <-notify:
+	default:
+		t.Error("want the subscriber to be notified")
+	}
+
+	events, complete := d.Events().Since(start)
+	if !complete {
+		t.Fatal("want every event to be kept")
+	}
+
+	var got []string
+	for _, e := range events {
+		if e.Type == EventProviderHealth {
+			got = append(got, fmt.Sprintf("%s %s %s->%s", e.Type, e.Provider, e.PreviousStatus, e.Status))
+			continue
+		}
+		got = append(got, fmt.Sprintf("%s %s %v", e.Type, e.Function, e.Providers))
+	}
+
+	want := []string{
+		"function-added echo [a]",
+		"function-updated echo [b]",
+		"function-added cat [a]",
+		"function-removed echo [b]",
+		"function-removed cat [a]",
+		"provider-health b unknown->down",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want events:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	a.Handle("/system/functions", http.StatusOK, `[{"name": "wc"}]`)
+	if _, err := d.ReconcileCache(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	events, _ = d.Events().Since(d.Events().LastID() - 1)
+	if len(events) != 1 || events[0].Type != EventFunctionAdded || events[0].Function != "wc" {
+		t.Errorf("want an event for the function added by reconciliation, got %+v", events)
+	}
+}
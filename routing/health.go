@@ -0,0 +1,158 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ProviderStatus is the last observed health of a provider
+type ProviderStatus string
+
+const (
+	// ProviderStatusUnknown the provider has not been checked yet
+	ProviderStatusUnknown ProviderStatus = "unknown"
+	// ProviderStatusUp the provider answered both /healthz and /system/info
+	ProviderStatusUp ProviderStatus = "up"
+	// ProviderStatusDegraded the provider answered /healthz but /system/info failed
+	ProviderStatusDegraded ProviderStatus = "degraded"
+	// ProviderStatusDown the provider failed /healthz more times than the failure threshold
+	ProviderStatusDown ProviderStatus = "down"
+)
+
+// ProviderHealth holds the health state of a single provider
+type ProviderHealth struct {
+	Name                string         `json:"name"`
+	URL                 string         `json:"url"`
+	Status              ProviderStatus `json:"status"`
+	LastChecked         time.Time      `json:"lastChecked"`
+	LastError           string         `json:"lastError,omitempty"`
+	ConsecutiveFailures int            `json:"consecutiveFailures"`
+}
+
+// IsDown returns true when the provider should not receive traffic
+func (p ProviderHealth) IsDown() bool {
+	return p.Status == ProviderStatusDown
+}
+
+// HealthChecker periodically probes each provider known to a ProviderRegistry
+// and records the outcome back into it
+type HealthChecker struct {
+	providerLookup   ProviderRegistry
+	client           *http.Client
+	failureThreshold int
+}
+
+// NewHealthChecker creates a HealthChecker, a provider is marked as down after
+// failureThreshold consecutive failed probes
+func NewHealthChecker(providerLookup ProviderRegistry, timeout time.Duration, failureThreshold int) *HealthChecker {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+
+	return &HealthChecker{
+		providerLookup:   providerLookup,
+		client:           &http.Client{Timeout: timeout, Transport: providerLookup.GetTransport()},
+		failureThreshold: failureThreshold,
+	}
+}
+
+// Run checks all providers every interval until done is closed
+func (h *HealthChecker) Run(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	h.CheckAll()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			h.CheckAll()
+		}
+	}
+}
+
+// CheckAll probes every provider in parallel and updates their health state
+func (h *HealthChecker) CheckAll() {
+	var wg sync.WaitGroup
+	for name, u := range h.providerLookup.GetProviders() {
+		wg.Add(1)
+		go func(name string, u *url.URL) {
+			defer wg.Done()
+			h.check(name, u)
+		}(name, u)
+	}
+
+	wg.Wait()
+}
+
+func (h *HealthChecker) check(name string, u *url.URL) {
+	previous, ok := h.providerLookup.GetProviderHealth(name)
+	if !ok {
+		previous = ProviderHealth{Status: ProviderStatusUnknown}
+	}
+
+	current := ProviderHealth{
+		Name:        name,
+		URL:         u.String(),
+		LastChecked: time.Now(),
+	}
+
+	if err := h.probe(name, u, "/healthz"); err != nil {
+		current.LastError = err.Error()
+		current.ConsecutiveFailures = previous.ConsecutiveFailures + 1
+		current.Status = previous.Status
+		if current.ConsecutiveFailures >= h.failureThreshold {
+			current.Status = ProviderStatusDown
+		} else if current.Status != ProviderStatusDown {
+			current.Status = ProviderStatusDegraded
+		}
+	} else if err := h.probe(name, u, "/system/info"); err != nil {
+		current.LastError = err.Error()
+		current.Status = ProviderStatusDegraded
+	} else {
+		current.Status = ProviderStatusUp
+	}
+
+	if current.Status != previous.Status {
+		log.Infof("provider %s health changed from %s to %s", name, previous.Status, current.Status)
+	}
+
+	h.providerLookup.UpdateProviderHealth(current)
+}
+
+// probe sends a GET to path on the provider with its credentials
+func (h *HealthChecker) probe(name string, u *url.URL, path string) error {
+	probeURL := *u
+	probeURL.Path = path
+
+	req, err := http.NewRequest(http.MethodGet, probeURL.String(), nil)
+	if err != nil {
+		return fmt.Errorf("error probing %s. %v", probeURL.String(), err)
+	}
+
+	p, _ := h.providerLookup.GetProvider(name)
+	if err := p.Credentials.Authorize(req); err != nil {
+		return fmt.Errorf("error probing %s. %v", probeURL.String(), err)
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error probing %s. %v", probeURL.String(), err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d probing %s", res.StatusCode, probeURL.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+)
+
+func Test_HealthChecker_CheckAll(t *testing.T) {
+	tests := []struct {
+		name             string
+		healthzStatus    int
+		infoStatus       int
+		failureThreshold int
+		rounds           int
+		want             ProviderStatus
+	}{
+		{name: "healthy provider is up", healthzStatus: http.StatusOK, infoStatus: http.StatusOK, failureThreshold: 1, rounds: 1, want: ProviderStatusUp},
+		{name: "failing info is degraded", healthzStatus: http.StatusOK, infoStatus: http.StatusInternalServerError, failureThreshold: 1, rounds: 1, want: ProviderStatusDegraded},
+		{name: "failing healthz below threshold is degraded", healthzStatus: http.StatusServiceUnavailable, infoStatus: http.StatusOK, failureThreshold: 3, rounds: 2, want: ProviderStatusDegraded},
+		{name: "failing healthz at threshold is down", healthzStatus: http.StatusServiceUnavailable, infoStatus: http.StatusOK, failureThreshold: 3, rounds: 3, want: ProviderStatusDown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := providertest.New(http.StatusNotFound)
+			s.Handle("/healthz", tt.healthzStatus, "")
+			s.Handle("/system/info", tt.infoStatus, "")
+			defer s.Close()
+
+			d := &defaultProviderRouting{
+				providers: map[string]*url.URL{"faas-provider-a": parseURL(s.URL)},
+				health:    map[string]ProviderHealth{},
+			}
+
+			h := NewHealthChecker(d, time.Second, tt.failureThreshold)
+			for i := 0; i < tt.rounds; i++ {
+				h.CheckAll()
+			}
+
+			got, ok := d.GetProviderHealth("faas-provider-a")
+			if !ok {
+				t.Fatal("want health state for faas-provider-a")
+			}
+
+			if got.Status != tt.want {
+				t.Errorf("want status %s, got %s", tt.want, got.Status)
+			}
+		})
+	}
+}
+
+func Test_HealthChecker_UnreachableProviderIsDown(t *testing.T) {
+	s := providertest.New(http.StatusOK)
+	providerURL := s.URL
+	s.Close()
+
+	d := &defaultProviderRouting{
+		providers: map[string]*url.URL{"faas-provider-a": parseURL(providerURL)},
+		health:    map[string]ProviderHealth{},
+	}
+
+	NewHealthChecker(d, time.Second, 1).CheckAll()
+
+	got, _ := d.GetProviderHealth("faas-provider-a")
+	if got.Status != ProviderStatusDown {
+		t.Errorf("want status %s, got %s", ProviderStatusDown, got.Status)
+	}
+
+	if len(got.LastError) == 0 {
+		t.Error("want last error to be recorded")
+	}
+}
+
+func Test_HealthChecker_SendsCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(path.Join(dir, "basic-auth-user"), []byte("admin\n"), 0600)
+	ioutil.WriteFile(path.Join(dir, "basic-auth-password"), []byte("secret\n"), 0600)
+
+	s := providertest.New(http.StatusOK)
+	s.RequireBasicAuth("admin", "secret")
+	defer s.Close()
+
+	d, err := NewDefaultProviderRouting([]Provider{
+		{Name: "faas-provider-a", URL: s.URL, Credentials: &Credentials{SecretMountPath: dir}, Default: true},
+	}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	NewHealthChecker(d, time.Second, 1).CheckAll()
+
+	got, _ := d.GetProviderHealth("faas-provider-a")
+	if got.Status != ProviderStatusUp {
+		t.Errorf("want status %s, got %s: %s", ProviderStatusUp, got.Status, got.LastError)
+	}
+}
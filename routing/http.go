@@ -2,8 +2,8 @@ package routing
 
 import (
 	"net/http"
-	"net/url"
 	"sort"
+	"time"
 )
 
 // Result to hold the result from each request including an Index
@@ -12,12 +12,17 @@ type Result struct {
 	Index    int
 	Response *http.Response
 	Err      error
+	// Latency until the response headers were received
+	Latency time.Duration
 }
 
-// Get sends requests in parallel but only up to a certain
+// DoWithClient sends requests in parallel but only up to a certain
 // limit, and furthermore it's only parallel up to the amount of CPUs but
 // is always concurrent up to the concurrency limit
-func Get(urls []*url.URL, concurrencyLimit int) []Result {
+func DoWithClient(client *http.Client, requests []*http.Request, concurrencyLimit int) []Result {
+	if len(requests) == 0 {
+		return nil
+	}
 
 	// this buffered channel will block at the concurrency limit
 	semaphoreChan := make(chan struct{}, concurrencyLimit)
@@ -31,11 +36,11 @@ func Get(urls []*url.URL, concurrencyLimit int) []Result {
 		close(resultsChan)
 	}()
 
-	// keen an Index and loop through every url we will send a request to
-	for i, u := range urls {
+	// keen an Index and loop through every request we will send
+	for i, req := range requests {
 
-		// start a go routine with the Index and url in a closure
-		go func(i int, u *url.URL) {
+		// start a go routine with the Index and request in a closure
+		go func(i int, req *http.Request) {
 
 			// this sends an empty struct into the semaphoreChan which
 			// is basically saying add one to the limit, but when the
@@ -45,8 +50,9 @@ func Get(urls []*url.URL, concurrencyLimit int) []Result {
 			// send the request and put the response in a result struct
 			// along with the Index so we can sort them later along with
 			// any error that might have occoured
-			res, err := http.Get(u.String())
-			result := &Result{i, res, err}
+			start := time.Now()
+			res, err := client.Do(req)
+			result := &Result{Index: i, Response: res, Err: err, Latency: time.Since(start)}
 
 			// now we can send the result struct through the resultsChan
 			resultsChan <- result
@@ -56,7 +62,7 @@ func Get(urls []*url.URL, concurrencyLimit int) []Result {
 			// another goroutine to start
 			<-semaphoreChan
 
-		}(i, u)
+		}(i, req)
 	}
 
 	// make a slice to hold the results we're expecting
@@ -68,8 +74,8 @@ func Get(urls []*url.URL, concurrencyLimit int) []Result {
 		result := <-resultsChan
 		results = append(results, *result)
 
-		// if we've reached the expected amount of urls then stop
-		if len(results) == len(urls) {
+		// if we've reached the expected amount of requests then stop
+		if len(results) == len(requests) {
 			break
 		}
 	}
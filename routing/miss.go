@@ -0,0 +1,149 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"sync"
+	"time"
+)
+
+// maxMissingFunctions bounds the negative cache so that lookups of random names can not
+// grow it without limit
+const maxMissingFunctions = 10000
+
+// CacheMissConfig limits the cache reloads triggered by lookups of functions which are not cached
+type CacheMissConfig struct {
+	// NegativeTTL for which a function found missing after a reload is not looked up again,
+	// 0 disables the negative cache
+	NegativeTTL time.Duration
+	// MinReloadInterval between reloads triggered by cache misses, 0 allows a reload for
+	// every miss which does not find one in progress
+	MinReloadInterval time.Duration
+}
+
+// CacheMissStats counts the lookups of functions which were not cached and how they were handled
+type CacheMissStats struct {
+	// Misses of the cache, including those answered by the negative cache
+	Misses uint64 `json:"misses"`
+	// Reloads of the cache triggered by misses
+	Reloads uint64 `json:"reloads"`
+	// Coalesced misses which waited for a reload started by another miss
+	Coalesced uint64 `json:"coalesced"`
+	// NegativeHits are misses of functions recently found missing, which did not reload the cache
+	NegativeHits uint64 `json:"negativeHits"`
+	// Throttled misses which did not reload the cache because it was reloaded within the minimum interval
+	Throttled uint64 `json:"throttled"`
+}
+
+// reloadCall is a cache reload shared by concurrent misses
+type reloadCall struct {
+	done chan struct{}
+	err  error
+}
+
+// cacheMisses coalesces the reloads of concurrent misses into one, and remembers the
+// functions which were missing after a reload for the negative TTL
+type cacheMisses struct {
+	config     CacheMissConfig
+	inflight   *reloadCall
+	lastReload time.Time
+	missing    map[string]time.Time
+	stats      CacheMissStats
+	lock       sync.Mutex
+	// now defaults to time.Now
+	now func() time.Time
+}
+
+func newCacheMisses(config CacheMissConfig) *cacheMisses {
+	return &cacheMisses{
+		config:  config,
+		missing: make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// reload reloads the cache with load for a missing function unless the function was found
+// missing within the negative TTL or the cache was reloaded within the minimum interval.
+// A reload in progress is waited for rather than starting another. It returns true when
+// the cache was reloaded, along with the error of the reload
+func (c *cacheMisses) reload(name string, load func() error) (bool, error) {
+	if c == nil {
+		return true, load()
+	}
+
+	c.lock.Lock()
+	c.stats.Misses++
+	now := c.now()
+
+	if expiry, ok := c.missing[name]; ok {
+		if now.Before(expiry) {
+			c.stats.NegativeHits++
+			c.lock.Unlock()
+			return false, nil
+		}
+		delete(c.missing, name)
+	}
+
+	if call := c.inflight; call != nil {
+		c.stats.Coalesced++
+		c.lock.Unlock()
+		<-call.done
+		return true, call.err
+	}
+
+	if c.config.MinReloadInterval > 0 && now.Sub(c.lastReload) < c.config.MinReloadInterval {
+		c.stats.Throttled++
+		c.lock.Unlock()
+		return false, nil
+	}
+
+	call := &reloadCall{done: make(chan struct{})}
+	c.inflight = call
+	c.lastReload = now
+	c.stats.Reloads++
+	c.lock.Unlock()
+
+	call.err = load()
+
+	c.lock.Lock()
+	c.inflight = nil
+	c.lock.Unlock()
+	close(call.done)
+
+	return true, call.err
+}
+
+// markMissing adds a function which was not found after a reload to the negative cache
+func (c *cacheMisses) markMissing(name string) {
+	if c == nil || c.config.NegativeTTL <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	if len(c.missing) >= maxMissingFunctions {
+		for k, expiry := range c.missing {
+			if !now.Before(expiry) {
+				delete(c.missing, k)
+			}
+		}
+	}
+
+	if len(c.missing) < maxMissingFunctions {
+		c.missing[name] = now.Add(c.config.NegativeTTL)
+	}
+}
+
+func (c *cacheMisses) getStats() CacheMissStats {
+	if c == nil {
+		return CacheMissStats{}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.stats
+}
@@ -0,0 +1,94 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+)
+
+func Test_CacheMiss_CoalescesReloads(t *testing.T) {
+	provider := providertest.New(http.StatusOK)
+	provider.Handle("/system/functions", http.StatusOK, providertest.Functions("echo"))
+	provider.SetDelay(100 * time.Millisecond)
+	defer provider.Close()
+
+	d, err := NewDefaultProviderRouting([]Provider{{Name: "a", URL: provider.URL, Default: true}}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Resolve("does-not-exist")
+		}()
+	}
+	wg.Wait()
+
+	if got := provider.Count("/system/functions"); got != 1 {
+		t.Errorf("want concurrent misses to share 1 reload, got %d", got)
+	}
+
+	stats := d.GetCacheMissStats()
+	if stats.Misses != 20 || stats.Reloads != 1 || stats.Coalesced != 19 {
+		t.Errorf("want 20 misses sharing 1 reload, got %+v", stats)
+	}
+}
+
+func Test_CacheMiss_NegativeCacheAndInterval(t *testing.T) {
+	provider := providertest.New(http.StatusOK)
+	provider.Handle("/system/functions", http.StatusOK, providertest.Functions("echo"))
+	defer provider.Close()
+
+	lookup, err := NewDefaultProviderRouting([]Provider{{Name: "a", URL: provider.URL, Default: true}}, Config{CacheMiss: CacheMissConfig{
+		NegativeTTL:       10 * time.Second,
+		MinReloadInterval: time.Second,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := lookup.(*defaultProviderRouting)
+
+	now := time.Now()
+	d.misses.now = func() time.Time { return now }
+
+	steps := []struct {
+		name         string
+		functionName string
+		advance      time.Duration
+		wantListings int
+		wantErr      bool
+	}{
+		{name: "first miss reloads", functionName: "missing", wantListings: 1, wantErr: true},
+		{name: "missing function is not reloaded", functionName: "missing", advance: 5 * time.Second, wantListings: 1, wantErr: true},
+		{name: "found function is not reloaded", functionName: "echo", wantListings: 1},
+		{name: "another miss reloads", functionName: "other", wantListings: 2, wantErr: true},
+		{name: "miss within interval is throttled", functionName: "third", advance: 500 * time.Millisecond, wantListings: 2, wantErr: true},
+		{name: "negative entry expires", functionName: "missing", advance: 10 * time.Second, wantListings: 3, wantErr: true},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		_, err := d.Resolve(step.functionName)
+		if (err != nil) != step.wantErr {
+			t.Errorf("%s: want error %t, got %v", step.name, step.wantErr, err)
+		}
+
+		if got := provider.Count("/system/functions"); got != step.wantListings {
+			t.Errorf("%s: want %d listings, got %d", step.name, step.wantListings, got)
+		}
+	}
+
+	stats := d.GetCacheMissStats()
+	if stats.Misses != 5 || stats.Reloads != 3 || stats.NegativeHits != 1 || stats.Throttled != 1 {
+		t.Errorf("want 5 misses with 3 reloads, 1 negative hit and 1 throttled, got %+v", stats)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"net/url"
+
+	types "github.com/openfaas/faas-provider/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// PlacementChange compares the providers a function was placed on with the providers its
+// update is placed on, keyed by provider name
+type PlacementChange struct {
+	// Added providers the function is deployed to
+	Added map[string]*url.URL
+	// Updated providers the function is already placed on
+	Updated map[string]*url.URL
+	// Removed providers the function is deleted from once it is deployed to its new providers
+	Removed map[string]*url.URL
+}
+
+// Moved returns true when the update places the function on different providers
+func (c *PlacementChange) Moved() bool {
+	return len(c.Added) > 0 || len(c.Removed) > 0
+}
+
+// ResolvePlacementChange compares the placement of the previous version of a function with
+// that of its update. Only an update with a gateway, selector or replicas-on annotation
+// moves a function, so that updating a function without restating its placement can not
+// delete it from its provider. Placement ignores the health of the providers
+func (d *defaultProviderRouting) ResolvePlacementChange(previous *types.FunctionDeployment, f *types.FunctionDeployment) (*PlacementChange, error) {
+	to, err := d.placement(f)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &PlacementChange{
+		Added:   map[string]*url.URL{},
+		Updated: map[string]*url.URL{},
+		Removed: map[string]*url.URL{},
+	}
+
+	if previous == nil || !explicitPlacement(f) {
+		change.Updated = to
+		return change, nil
+	}
+
+	from, err := d.placement(previous)
+	if err != nil {
+		log.Warnf("can not resolve the previous placement of function %s, deploying it to its new providers. %v", f.Service, err)
+		from = nil
+	}
+
+	for name, u := range to {
+		if _, ok := from[name]; ok {
+			change.Updated[name] = u
+		} else {
+			change.Added[name] = u
+		}
+	}
+
+	for name, u := range from {
+		if _, ok := to[name]; !ok {
+			change.Removed[name] = u
+		}
+	}
+
+	return change, nil
+}
+
+// placement returns the providers a function is placed on by its annotations, keyed by name
+func (d *defaultProviderRouting) placement(f *types.FunctionDeployment) (map[string]*url.URL, error) {
+	replicas, err := d.ResolveReplicas(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(replicas) > 0 {
+		return replicas, nil
+	}
+
+	pURL, err := d.place(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]*url.URL{d.ProviderName(pURL): pURL}, nil
+}
+
+// explicitPlacement returns true when a function names or selects the providers it is placed on
+func explicitPlacement(f *types.FunctionDeployment) bool {
+	if f.Annotations == nil {
+		return false
+	}
+
+	for _, k := range []string{federationProviderNameConstraint, federationSelectorConstraint, federationReplicasOnAnnotation} {
+		if _, ok := (*f.Annotations)[k]; ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,139 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/openfaas-incubator/faas-federation/metrics"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+func Test_ResolvePlacementChange(t *testing.T) {
+	d, err := NewDefaultProviderRouting([]Provider{
+		{Name: "a", URL: "http://faas-provider-a:8080", Default: true},
+		{Name: "b", URL: "http://faas-provider-b:8080"},
+		{Name: "c", URL: "http://faas-provider-c:8080"},
+	}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	function := func(annotations map[string]string) *types.FunctionDeployment {
+		return &types.FunctionDeployment{Service: "echo", Annotations: &annotations}
+	}
+
+	tests := []struct {
+		name      string
+		previous  *types.FunctionDeployment
+		update    *types.FunctionDeployment
+		want      string
+		wantMoved bool
+	}{
+		{
+			name:   "function which is not cached",
+			update: function(map[string]string{federationProviderNameConstraint: "b"}),
+			want:   "added [] updated [b] removed []",
+		},
+		{
+			name:     "same gateway",
+			previous: function(map[string]string{federationProviderNameConstraint: "b"}),
+			update:   function(map[string]string{federationProviderNameConstraint: "b"}),
+			want:     "added [] updated [b] removed []",
+		},
+		{
+			name:      "gateway changed",
+			previous:  function(map[string]string{federationProviderNameConstraint: "a"}),
+			update:    function(map[string]string{federationProviderNameConstraint: "b"}),
+			want:      "added [b] updated [] removed [a]",
+			wantMoved: true,
+		},
+		{
+			name:      "replicas changed",
+			previous:  function(map[string]string{federationReplicasOnAnnotation: "a,b"}),
+			update:    function(map[string]string{federationReplicasOnAnnotation: "b,c"}),
+			want:      "added [c] updated [b] removed [a]",
+			wantMoved: true,
+		},
+		{
+			name:     "update without placement is not moved",
+			previous: function(map[string]string{federationProviderNameConstraint: "b"}),
+			update:   function(nil),
+			want:     "added [] updated [a] removed []",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change, err := d.ResolvePlacementChange(tt.previous, tt.update)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := fmt.Sprintf("added %v updated %v removed %v", providerNames(change.Added), providerNames(change.Updated), providerNames(change.Removed))
+			if got != tt.want {
+				t.Errorf("want %s, got %s", tt.want, got)
+			}
+
+			if change.Moved() != tt.wantMoved {
+				t.Errorf("want moved %t, got %t", tt.wantMoved, change.Moved())
+			}
+		})
+	}
+}
+
+func Test_RemoveFunction(t *testing.T) {
+	d, err := NewDefaultProviderRouting([]Provider{{Name: "a", URL: "http://faas-provider-a:8080", Default: true}}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationWeightsAnnotation: "a=100"}})
+	d.AddFunction(&types.FunctionDeployment{Service: "cat", Namespace: "team-a"})
+	metrics.Invocations.Inc("echo", "a", "200")
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "echo", want: true},
+		{name: "echo", want: false},
+		{name: "cat", want: true},
+		{name: "wc", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := d.RemoveFunction(tt.name); got != tt.want {
+			t.Errorf("want RemoveFunction(%s) %t, got %t", tt.name, tt.want, got)
+		}
+	}
+
+	if _, ok := d.GetFunctionWeights("echo"); ok {
+		t.Error("want the weights of a removed function to be removed")
+	}
+
+	if functions := d.GetFunctions(); len(functions) != 0 {
+		t.Errorf("want an empty cache, got %d functions", len(functions))
+	}
+	var b bytes.Buffer
+	metrics.Default.Write(&b)
+	if strings.Contains(b.String(), `function="echo"`) {
+		t.Errorf("want the metrics of a removed function to be dropped, got:\n%s", b.String())
+	}
+}
+
+func providerNames(providers map[string]*url.URL) string {
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return "[" + strings.Join(names, ",") + "]"
+}
@@ -1,114 +1,909 @@
 package routing
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/openfaas-incubator/faas-federation/metrics"
 	types "github.com/openfaas/faas-provider/types"
 	log "github.com/sirupsen/logrus"
 )
 
-const federationProviderNameConstraint = "com.openfaas.federation.gateway"
+const (
+	federationProviderNameConstraint = "com.openfaas.federation.gateway"
+	federationFallbackConstraint     = "com.openfaas.federation.fallback-gateway"
+	federationSelectorConstraint     = "com.openfaas.federation.selector"
+	federationWeightsAnnotation      = "com.openfaas.federation.weights"
+	federationReplicasOnAnnotation   = "com.openfaas.federation.replicas-on"
+	federationRetryAnnotation        = "com.openfaas.federation.retry"
+)
+
+// Provider is a downstream OpenFaaS provider which is part of the federation
+type Provider struct {
+	// Name used by the com.openfaas.federation.gateway annotation
+	Name string
+	// URL of the provider's API
+	URL string
+	// Labels matched by the com.openfaas.federation.selector annotation
+	Labels map[string]string
+	// Timeout for invocations proxied to the provider and for calls to its /system endpoints,
+	// 0 uses the federation's read timeout
+	Timeout time.Duration
+	// Credentials for calls to the provider's /system endpoints, nil sends no credentials
+	Credentials *Credentials
+	// TLS of the connections to the provider, nil uses the system roots without a client certificate
+	TLS *TLSConfig
+	// Default marks the provider used when a function has no placement constraints
+	Default bool
+	// Namespaces placed on the provider when a function has no placement constraints,
+	// the functions in them are read into the cache along with the provider's default namespace
+	Namespaces []string
+}
 
 // ProviderLookup allows the federation to determine which provider
 // is currently responsible for a given function
 type ProviderLookup interface {
+	FunctionResolver
+	FunctionCache
+	ProviderRegistry
+}
+
+// FunctionResolver resolves the providers a function is placed on
+type FunctionResolver interface {
 	Resolve(functionName string) (providerURI *url.URL, err error)
+	ResolveFunction(f *types.FunctionDeployment) (providerURI *url.URL, err error)
+	ResolveWeighted(functionName string) (providerURI *url.URL, err error)
+	ResolveReplicas(f *types.FunctionDeployment) (map[string]*url.URL, error)
+	ResolveAlternates(functionName string) ([]*url.URL, error)
+	ResolvePlacementChange(previous *types.FunctionDeployment, f *types.FunctionDeployment) (*PlacementChange, error)
+}
+
+// FunctionCache holds the functions of the federation and the changes made to them
+type FunctionCache interface {
 	AddFunction(f *types.FunctionDeployment)
+	RemoveFunction(name string) bool
 	GetFunction(name string) (*types.FunctionDeployment, bool)
 	GetFunctions() []*types.FunctionDeployment
+	GetFunctionWeights(name string) ([]ProviderWeight, bool)
+	GetOrphanedFunctions() map[string]string
 	ReloadCache() error
+	ReconcileCache(ctx context.Context) (map[string]*CacheDiff, error)
+	CacheSnapshot() *CacheSnapshot
+	RestoreCacheSnapshot(s *CacheSnapshot) (int, error)
+	GetCacheMissStats() CacheMissStats
+	Events() *EventLog
+}
+
+// ProviderRegistry holds the providers of the federation along with their health
+type ProviderRegistry interface {
+	GetProviders() map[string]*url.URL
+	ListProviders() []Provider
+	GetProvider(name string) (Provider, bool)
+	ProviderName(providerURI *url.URL) string
+	GetTransport() http.RoundTripper
+	GetClient() *http.Client
+	GetProviderHealth(name string) (ProviderHealth, bool)
+	GetProvidersHealth() []ProviderHealth
+	UpdateProviderHealth(h ProviderHealth)
+	GetCircuitBreaker(providerURI *url.URL) *CircuitBreaker
+	GetProviderStats(name string) ProviderStats
+	UpdateProviders(providers []Provider) error
+	AddProvider(p Provider) error
+	UpdateProvider(p Provider) error
+	RemoveProvider(name string) error
+}
+
+// Config of the provider routing, the zero value leaves circuit breaking and the limits on
+// cache reloads disabled
+type Config struct {
+	// CircuitBreaker of each provider
+	CircuitBreaker CircuitBreakerConfig
+	// CacheMiss limits the cache reloads triggered by lookups of functions which are not cached
+	CacheMiss CacheMissConfig
+	// Timeout of the requests sent by GetClient to a provider without a timeout of its own,
+	// 0 waits without a limit
+	Timeout time.Duration
+}
+
+// ProviderStats summarises the functions cached for a provider
+type ProviderStats struct {
+	// Functions placed on or replicated to the provider
+	Functions int `json:"functions"`
+	// LastRefresh of the function cache from the provider
+	LastRefresh *time.Time `json:"lastRefresh,omitempty"`
+	// LastListing is the outcome of the last attempt to refresh the function cache from the provider
+	LastListing *ProviderListing `json:"lastListing,omitempty"`
 }
 
 type defaultProviderRouting struct {
 	cache           map[string]*types.FunctionDeployment
 	providers       map[string]*url.URL
+	labels          map[string]map[string]string
+	timeouts        map[string]time.Duration
+	credentials     map[string]*Credentials
+	tlsConfigs      map[string]*TLSConfig
+	namespaces      map[string][]string
+	transports      map[string]*providerTransport
+	health          map[string]ProviderHealth
+	breakers        map[string]*CircuitBreaker
+	weights         map[string][]ProviderWeight
+	defaultProvider *url.URL
+	breakerConfig   CircuitBreakerConfig
+	defaultTimeout  time.Duration
+	// orphaned functions keyed by name, with the name of the removed provider they were placed on
+	orphaned map[string]string
+	// refreshed is the time the function cache was last read from each provider
+	refreshed map[string]time.Time
+	// listings is the outcome of the last listing of each provider's functions
+	listings map[string]ProviderListing
+	lock     sync.RWMutex
+	// updateLock serialises changes to the providers
+	updateLock sync.Mutex
+	// registered providers added or replaced by AddProvider and UpdateProvider, they take
+	// precedence over the providers given to UpdateProviders, guarded by updateLock
+	registered map[string]Provider
+	// deregistered providers removed by RemoveProvider, they are left out of the providers
+	// given to UpdateProviders, guarded by updateLock
+	deregistered map[string]bool
+	// intn picks the random number used for weighted routing, defaults to rand.Intn
+	intn func(n int) int
+	// misses limits the cache reloads triggered by lookups of functions which are not cached
+	misses *cacheMisses
+	// events records the changes to the cache and to the health of the providers
+	events *EventLog
+}
+
+// NewDefaultProviderRouting creates a default way to resolve providers based on the name
+// constraint or a selector matched against each provider's labels, exactly one of the
+// providers must be the default. Each provider is given a circuit breaker, and the cache
+// reloads triggered by lookups of unknown functions are limited, as given by config
+func NewDefaultProviderRouting(providers []Provider, config Config) (ProviderLookup, error) {
+	d := &defaultProviderRouting{
+		cache:          make(map[string]*types.FunctionDeployment),
+		health:         make(map[string]ProviderHealth),
+		breakers:       make(map[string]*CircuitBreaker),
+		weights:        make(map[string][]ProviderWeight),
+		orphaned:       make(map[string]string),
+		refreshed:      make(map[string]time.Time),
+		listings:       make(map[string]ProviderListing),
+		registered:     make(map[string]Provider),
+		deregistered:   make(map[string]bool),
+		breakerConfig:  config.CircuitBreaker,
+		defaultTimeout: config.Timeout,
+		intn:           rand.Intn,
+		misses:         newCacheMisses(config.CacheMiss),
+		events:         NewEventLog(maxEvents),
+	}
+
+	if err := d.UpdateProviders(providers); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// providerSet is a validated list of providers
+type providerSet struct {
+	providers       map[string]*url.URL
+	labels          map[string]map[string]string
+	timeouts        map[string]time.Duration
+	credentials     map[string]*Credentials
+	tlsConfigs      map[string]*TLSConfig
+	namespaces      map[string][]string
 	defaultProvider *url.URL
-	lock            sync.RWMutex
 }
 
-// NewDefaultProviderRouting creates a default way to resolve providers currently based
-// on name constraint
-func NewDefaultProviderRouting(providers []string, defaultProvider string) (ProviderLookup, error) {
-	providerMap := map[string]*url.URL{}
+func parseProviders(providers []Provider) (providerSet, error) {
+	set := providerSet{
+		providers:   make(map[string]*url.URL),
+		labels:      make(map[string]map[string]string),
+		timeouts:    make(map[string]time.Duration),
+		credentials: make(map[string]*Credentials),
+		tlsConfigs:  make(map[string]*TLSConfig),
+		namespaces:  make(map[string][]string),
+	}
+
+	defaultName := ""
+	namespaceProviders := map[string]string{}
+	for _, p := range providers {
+		if len(p.Name) == 0 {
+			return set, fmt.Errorf("provider with URL %s has no name", p.URL)
+		}
 
-	for _, v := range providers {
-		pURL, err := url.Parse(v)
+		if _, ok := set.providers[p.Name]; ok {
+			return set, fmt.Errorf("provider %s is listed more than once", p.Name)
+		}
+
+		pURL, err := url.Parse(p.URL)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing URL using value %s. %v", v, err)
+			return set, fmt.Errorf("error parsing URL using value %s. %v", p.URL, err)
+		}
+
+		if len(pURL.Scheme) == 0 || len(pURL.Host) == 0 {
+			return set, fmt.Errorf("provider %s has an invalid URL %q, expected a URL such as http://gateway:8080", p.Name, p.URL)
 		}
-		providerMap[getHostNameWithoutPorts(pURL)] = pURL
+
+		set.providers[p.Name] = pURL
+		set.labels[p.Name] = p.Labels
+		set.timeouts[p.Name] = p.Timeout
+		set.credentials[p.Name] = p.Credentials
+		set.tlsConfigs[p.Name] = p.TLS
+		set.namespaces[p.Name] = p.Namespaces
+
+		for _, ns := range p.Namespaces {
+			if other, ok := namespaceProviders[ns]; ok {
+				return set, fmt.Errorf("namespace %s is mapped to providers %s and %s", ns, other, p.Name)
+			}
+			namespaceProviders[ns] = p.Name
+		}
+
+		if p.Default {
+			if set.defaultProvider != nil {
+				return set, fmt.Errorf("providers %s and %s are both marked as the default", defaultName, p.Name)
+			}
+			set.defaultProvider = pURL
+			defaultName = p.Name
+		}
+	}
+
+	if set.defaultProvider == nil {
+		return set, fmt.Errorf("no default provider given")
+	}
+
+	return set, nil
+}
+
+// UpdateProviders atomically replaces the providers of the federation. The changes made by
+// AddProvider, UpdateProvider and RemoveProvider are applied over the providers, so that a
+// reload of the configuration keeps them. Health and circuit breakers are kept for providers
+// whose URL did not change. Cached functions placed on a provider which was removed are
+// flagged as orphaned until they are found on another provider
+func (d *defaultProviderRouting) UpdateProviders(providers []Provider) error {
+	d.updateLock.Lock()
+	defer d.updateLock.Unlock()
+
+	return d.updateProviders(d.withRegistered(providers))
+}
+
+// withRegistered returns the providers without those deregistered or replaced through the
+// API, followed by the providers registered through the API. The default of providers is
+// cleared when a registered provider is the default, callers must hold the update lock
+func (d *defaultProviderRouting) withRegistered(providers []Provider) []Provider {
+	clearDefault := false
+	var names []string
+	for name, p := range d.registered {
+		names = append(names, name)
+		clearDefault = clearDefault || p.Default
+	}
+	sort.Strings(names)
+
+	var result []Provider
+	for _, p := range providers {
+		if _, ok := d.registered[p.Name]; ok || d.deregistered[p.Name] {
+			continue
+		}
+		if clearDefault {
+			p.Default = false
+		}
+		result = append(result, p)
+	}
+
+	for _, name := range names {
+		result = append(result, d.registered[name])
 	}
 
-	d, err := url.Parse(defaultProvider)
+	return result
+}
+
+// register records a provider added or replaced through the API, callers must hold the
+// update lock
+func (d *defaultProviderRouting) register(p Provider) {
+	if p.Default {
+		for name, r := range d.registered {
+			r.Default = false
+			d.registered[name] = r
+		}
+	}
+
+	d.registered[p.Name] = p
+	delete(d.deregistered, p.Name)
+}
+
+// AddProvider adds a provider to the federation, when it is the default it replaces the
+// current default provider
+func (d *defaultProviderRouting) AddProvider(p Provider) error {
+	d.updateLock.Lock()
+	defer d.updateLock.Unlock()
+
+	if _, ok := d.GetProvider(p.Name); ok {
+		return fmt.Errorf("provider %s already exists", p.Name)
+	}
+
+	if err := d.updateProviders(append(d.providerList(p.Default), p)); err != nil {
+		return err
+	}
+
+	d.register(p)
+	return nil
+}
+
+// UpdateProvider replaces an existing provider, when it is the default it replaces the
+// current default provider
+func (d *defaultProviderRouting) UpdateProvider(p Provider) error {
+	d.updateLock.Lock()
+	defer d.updateLock.Unlock()
+
+	if _, ok := d.GetProvider(p.Name); !ok {
+		return fmt.Errorf("provider %s does not exist", p.Name)
+	}
+
+	var providers []Provider
+	for _, v := range d.providerList(p.Default) {
+		if v.Name == p.Name {
+			v = p
+		}
+		providers = append(providers, v)
+	}
+
+	if err := d.updateProviders(providers); err != nil {
+		return err
+	}
+
+	d.register(p)
+	return nil
+}
+
+// RemoveProvider removes a provider from the federation, the default provider can not be removed
+func (d *defaultProviderRouting) RemoveProvider(name string) error {
+	d.updateLock.Lock()
+	defer d.updateLock.Unlock()
+
+	existing, ok := d.GetProvider(name)
+	if !ok {
+		return fmt.Errorf("provider %s does not exist", name)
+	}
+
+	if existing.Default {
+		return fmt.Errorf("provider %s is the default provider and can not be removed", name)
+	}
+
+	var providers []Provider
+	for _, v := range d.providerList(false) {
+		if v.Name != name {
+			providers = append(providers, v)
+		}
+	}
+
+	if err := d.updateProviders(providers); err != nil {
+		return err
+	}
+
+	delete(d.registered, name)
+	d.deregistered[name] = true
+	return nil
+}
+
+// GetTransport returns an http.RoundTripper which sends each request with the TLS config of
+// the provider its URL belongs to
+func (d *defaultProviderRouting) GetTransport() http.RoundTripper {
+	return providersRoundTripper{d: d}
+}
+
+type providersRoundTripper struct {
+	d *defaultProviderRouting
+}
+
+func (p providersRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	p.d.lock.RLock()
+	var transport http.RoundTripper
+	for name, u := range p.d.providers {
+		if u.Scheme == req.URL.Scheme && u.Host == req.URL.Host {
+			if t, ok := p.d.transports[name]; ok {
+				transport = t
+			}
+			break
+		}
+	}
+	p.d.lock.RUnlock()
+
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	return transport.RoundTrip(req)
+}
+
+// GetClient returns a client which sends each request with GetTransport and ends it after the
+// timeout of the provider its URL belongs to, or the default timeout when the provider has none
+func (d *defaultProviderRouting) GetClient() *http.Client {
+	return &http.Client{Transport: timeoutRoundTripper{d: d, next: d.GetTransport()}}
+}
+
+type timeoutRoundTripper struct {
+	d    *defaultProviderRouting
+	next http.RoundTripper
+}
+
+func (t timeoutRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	timeout := t.d.requestTimeout(req.URL)
+	if timeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	res, err := t.next.RoundTrip(req.WithContext(ctx))
 	if err != nil {
-		return nil, fmt.Errorf("error parsing default provider URL using value %s. %v", defaultProvider, err)
+		cancel()
+		return nil, err
 	}
 
-	return &defaultProviderRouting{
-		cache:           make(map[string]*types.FunctionDeployment),
-		providers:       providerMap,
-		defaultProvider: d,
-	}, nil
+	res.Body = cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
 }
 
-func (d *defaultProviderRouting) ReloadCache() error {
-	log.Info("reloading cache starting...")
-	var urls []string
-	for _, v := range d.providers {
-		urls = append(urls, v.String())
+// requestTimeout returns the timeout of the provider u belongs to
+func (d *defaultProviderRouting) requestTimeout(u *url.URL) time.Duration {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	for name, pURL := range d.providers {
+		if pURL.Scheme == u.Scheme && pURL.Host == u.Host {
+			if timeout := d.timeouts[name]; timeout > 0 {
+				return timeout
+			}
+			break
+		}
 	}
 
-	result, err := ReadServices(urls)
+	return d.defaultTimeout
+}
+
+// cancelBody releases the deadline of a request once its response has been read
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// ListProviders returns the providers of the federation sorted by name
+func (d *defaultProviderRouting) ListProviders() []Provider {
+	return d.providerList(false)
+}
+
+// providerList returns the current providers sorted by name, clearing the default flag
+// when clearDefault is set
+func (d *defaultProviderRouting) providerList(clearDefault bool) []Provider {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	var names []string
+	for name := range d.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result []Provider
+	for _, name := range names {
+		p := d.provider(name)
+		if clearDefault {
+			p.Default = false
+		}
+		result = append(result, p)
+	}
+
+	return result
+}
+
+// updateProviders swaps in the providers, callers must hold the update lock
+func (d *defaultProviderRouting) updateProviders(providers []Provider) error {
+	set, err := parseProviders(providers)
 	if err != nil {
-		return fmt.Errorf("could not reload cache. %v", err)
+		return err
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	previous := d.providers
+	transports := make(map[string]*providerTransport)
+	for name := range set.providers {
+		if t, ok := d.transports[name]; ok && set.tlsConfigs[name].equal(d.tlsConfigs[name]) {
+			transports[name] = t
+			continue
+		}
+
+		t, err := newProviderTransport(set.tlsConfigs[name])
+		if err != nil {
+			return fmt.Errorf("invalid TLS config for provider %s. %v", name, err)
+		}
+		transports[name] = t
 	}
 
-	for k, v := range result.Providers {
-		for _, f := range v {
-			cf := requestToCreate(f)
-			pURL, _ := url.Parse(k)
-			ensureAnnotation(cf, getHostNameWithoutPorts(pURL))
-			d.AddFunction(cf)
+	for name, t := range d.transports {
+		if transports[name] != t {
+			t.closeIdleConnections()
 		}
+	}
 
-		log.Infof("   added %d functions for provider %s", len(v), k)
+	breakers := make(map[string]*CircuitBreaker)
+	health := make(map[string]ProviderHealth)
+	for name, pURL := range set.providers {
+		if old, ok := previous[name]; ok && old.String() == pURL.String() {
+			if b, ok := d.breakers[name]; ok {
+				breakers[name] = b
+			}
+			if h, ok := d.health[name]; ok {
+				health[name] = h
+			}
+			continue
+		}
+
+		if previous != nil {
+			log.Infof("provider %s added with URL %s", name, pURL.String())
+		}
+		if b := NewCircuitBreaker(d.breakerConfig); b != nil {
+			breakers[name] = b
+		}
+	}
+
+	for name := range previous {
+		if _, ok := set.providers[name]; !ok {
+			log.Infof("provider %s removed", name)
+		}
 	}
 
-	log.Info("reloading cache completed successfully")
+	d.providers = set.providers
+	d.labels = set.labels
+	d.timeouts = set.timeouts
+	d.credentials = set.credentials
+	d.tlsConfigs = set.tlsConfigs
+	d.namespaces = set.namespaces
+	d.transports = transports
+	d.defaultProvider = set.defaultProvider
+	d.breakers = breakers
+	d.health = health
+	for name := range d.refreshed {
+		if _, ok := set.providers[name]; !ok {
+			delete(d.refreshed, name)
+		}
+	}
+	for name := range d.listings {
+		if _, ok := set.providers[name]; !ok {
+			delete(d.listings, name)
+		}
+	}
+	d.flagOrphans(previous)
+
 	return nil
 }
 
+// flagOrphans flags cached functions placed on a provider which is no longer part of the
+// federation, and clears the flag of those whose provider is known again. Only providers
+// in previous are considered so that a function deployed with an unknown gateway name is
+// not flagged. Callers must hold the lock
+func (d *defaultProviderRouting) flagOrphans(previous map[string]*url.URL) {
+	if d.orphaned == nil {
+		d.orphaned = make(map[string]string)
+	}
+
+	for key, f := range d.cache {
+		if _, ok := providerNamed(d.providers, gatewayName(f)); ok {
+			delete(d.orphaned, key)
+			continue
+		}
+
+		if provider, ok := providerNamed(previous, gatewayName(f)); ok {
+			log.Warnf("function %s is orphaned, its provider %s was removed", key, provider)
+			d.orphaned[key] = provider
+		}
+	}
+}
+
+// providerNamed returns the name of the provider matching name without regard to case, as
+// the com.openfaas.federation.gateway annotation is matched, preferring an exact match
+func providerNamed(providers map[string]*url.URL, name string) (string, bool) {
+	if _, ok := providers[name]; ok {
+		return name, true
+	}
+
+	var names []string
+	for n := range providers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return n, true
+		}
+	}
+
+	return "", false
+}
+
+// gatewayName returns the value of the com.openfaas.federation.gateway annotation
+func gatewayName(f *types.FunctionDeployment) string {
+	if f.Annotations == nil {
+		return ""
+	}
+
+	return (*f.Annotations)[federationProviderNameConstraint]
+}
+
+// FunctionKey is the name a function is cached and invoked by, a function in a namespace is
+// qualified with it such as echo.team-a
+func FunctionKey(service string, namespace string) string {
+	if len(namespace) == 0 || strings.HasSuffix(service, "."+namespace) {
+		return service
+	}
+
+	return service + "." + namespace
+}
+
+func functionKey(f *types.FunctionDeployment) string {
+	return FunctionKey(f.Service, f.Namespace)
+}
+
+// recordListing keeps the outcome of listing a provider's functions, the provider is marked
+// as refreshed when its functions were listed
+func (d *defaultProviderRouting) recordListing(l ProviderListing) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.refreshed == nil {
+		d.refreshed = make(map[string]time.Time)
+	}
+	if d.listings == nil {
+		d.listings = make(map[string]ProviderListing)
+	}
+
+	if _, ok := d.providers[l.Provider]; !ok {
+		return
+	}
+
+	d.listings[l.Provider] = l
+	if l.OK {
+		d.refreshed[l.Provider] = time.Now()
+	}
+}
+
+// GetCacheMissStats counts the lookups of functions which were not cached
+func (d *defaultProviderRouting) GetCacheMissStats() CacheMissStats {
+	return d.misses.getStats()
+}
+
+// GetOrphanedFunctions returns the functions whose provider was removed, keyed by function
+// name with the name of the removed provider
+func (d *defaultProviderRouting) GetOrphanedFunctions() map[string]string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	result := make(map[string]string, len(d.orphaned))
+	for k, v := range d.orphaned {
+		result[k] = v
+	}
+
+	return result
+}
+
+// ReloadCache reconciles the cache with the functions listed by each provider
+func (d *defaultProviderRouting) ReloadCache() error {
+	_, err := d.ReconcileCache(context.Background())
+	return err
+}
+
 func (d *defaultProviderRouting) Resolve(functionName string) (providerURI *url.URL, err error) {
-	f, ok := d.GetFunction(functionName)
+	f, err := d.lookupFunction(functionName)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.ResolveFunction(f)
+}
+
+// ResolveWeighted resolves the provider for a single invocation, when the function has
+// weights a provider is picked at random in proportion to its weight, skipping providers
+// which are down
+func (d *defaultProviderRouting) ResolveWeighted(functionName string) (providerURI *url.URL, err error) {
+	f, err := d.lookupFunction(functionName)
+	if err != nil {
+		return nil, err
+	}
+
+	weights, ok := d.GetFunctionWeights(functionKey(f))
 	if !ok {
-		log.Warnf("can not find function %s in cache map, will attempt cache reload", functionName)
-		if err := d.ReloadCache(); err != nil {
+		return d.ResolveFunction(f)
+	}
+
+	var eligible []ProviderWeight
+	providers := d.GetProviders()
+	for _, w := range weights {
+		pURL, ok := providers[w.Provider]
+		if !ok || d.isDown(pURL) {
+			continue
+		}
+		eligible = append(eligible, w)
+	}
+
+	intn := d.intn
+	if intn == nil {
+		intn = rand.Intn
+	}
+
+	name, ok := pickWeighted(eligible, intn)
+	if !ok {
+		log.Warnf("no weighted provider available for function %s, using placement", functionName)
+		return d.ResolveFunction(f)
+	}
+
+	return providers[name], nil
+}
+
+func (d *defaultProviderRouting) lookupFunction(functionName string) (*types.FunctionDeployment, error) {
+	f, ok := d.GetFunction(functionName)
+	if ok {
+		metrics.CacheLookups.Inc("hit")
+	} else {
+		metrics.CacheLookups.Inc("miss")
+		reloaded, err := d.misses.reload(functionName, func() error {
+			log.Warnf("can not find function %s in cache map, will attempt cache reload", functionName)
+			metrics.CacheReloads.Inc()
+			return d.ReloadCache()
+		})
+		if err != nil {
 			return nil, fmt.Errorf("can not find function %s in cache map. Attempted to reload cache failed. %v", functionName, err)
 		}
 
 		f, ok = d.GetFunction(functionName)
 		if !ok {
+			if reloaded {
+				d.misses.markMissing(functionName)
+			}
 			return nil, fmt.Errorf("can not find function %s in cache map", functionName)
 		}
 	}
 
-	c, ok := (*f.Annotations)[federationProviderNameConstraint]
-	if !ok {
-		log.Infof("%s constraint not found using default provider %s", federationProviderNameConstraint, d.defaultProvider.String())
-		return d.defaultProvider, nil
+	d.lock.RLock()
+	provider, orphaned := d.orphaned[functionKey(f)]
+	d.lock.RUnlock()
+	if orphaned {
+		return nil, fmt.Errorf("function %s is orphaned, its provider %s was removed from the federation", functionName, provider)
+	}
+
+	return f, nil
+}
+
+func (d *defaultProviderRouting) ResolveFunction(f *types.FunctionDeployment) (providerURI *url.URL, err error) {
+	if _, err := d.functionWeights(f); err != nil {
+		return nil, err
 	}
 
-	pURL := d.matchBasedOnName(c)
-	if pURL == nil {
-		log.Infof("%s constraint value found but does not exist in provider list, using default provider %s", c, d.defaultProvider.String())
+	primary, err := d.place(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if !d.isDown(primary) {
+		return primary, nil
+	}
 
-		return d.defaultProvider, nil
+	if fallback := d.resolveFallback(f, primary); fallback != nil {
+		log.Warnf("provider %s is down, using fallback provider %s for function %s", primary.String(), fallback.String(), f.Service)
+		return fallback, nil
 	}
 
-	return pURL, nil
+	log.Warnf("provider %s is down and no healthy fallback exists for function %s", primary.String(), f.Service)
+	return primary, nil
+}
+
+// place picks the provider for a function from its name constraint, its selector, the
+// provider its namespace is mapped to or the default provider, in that order
+func (d *defaultProviderRouting) place(f *types.FunctionDeployment) (*url.URL, error) {
+	annotations := map[string]string{}
+	if f.Annotations != nil {
+		annotations = *f.Annotations
+	}
+
+	if c, ok := annotations[federationProviderNameConstraint]; ok {
+		pURL, err := d.matchSelector(nameSelector(c))
+		if err != nil {
+			defaultProvider := d.getDefaultProvider()
+			log.Infof("%s constraint value found but does not exist in provider list, using default provider %s", c, defaultProvider.String())
+			return defaultProvider, nil
+		}
+
+		return pURL, nil
+	}
+
+	if c, ok := annotations[federationSelectorConstraint]; ok {
+		s, err := parseSelector(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation for function %s. %v", federationSelectorConstraint, f.Service, err)
+		}
+
+		pURL, err := d.matchSelector(s)
+		if err != nil {
+			return nil, fmt.Errorf("can not place function %s. %v", f.Service, err)
+		}
+
+		return pURL, nil
+	}
+
+	if pURL, ok := d.namespaceProvider(f.Namespace); ok {
+		return pURL, nil
+	}
+
+	defaultProvider := d.getDefaultProvider()
+	log.Infof("%s constraint not found using default provider %s", federationProviderNameConstraint, defaultProvider.String())
+	return defaultProvider, nil
+}
+
+// namespaceProvider returns the provider the namespace is mapped to
+func (d *defaultProviderRouting) namespaceProvider(namespace string) (*url.URL, bool) {
+	if len(namespace) == 0 {
+		return nil, false
+	}
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	for name, namespaces := range d.namespaces {
+		for _, ns := range namespaces {
+			if ns == namespace {
+				return d.providers[name], true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+func (d *defaultProviderRouting) getDefaultProvider() *url.URL {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.defaultProvider
+}
+
+// resolveFallback returns the secondary provider from the fallback annotation or
+// the default provider when the primary is unavailable, nil when neither is usable
+func (d *defaultProviderRouting) resolveFallback(f *types.FunctionDeployment, primary *url.URL) *url.URL {
+	var candidates []*url.URL
+	if f.Annotations != nil {
+		if c, ok := (*f.Annotations)[federationFallbackConstraint]; ok {
+			if pURL, err := d.matchSelector(nameSelector(c)); err == nil {
+				candidates = append(candidates, pURL)
+			} else {
+				log.Infof("%s constraint value %s does not exist in provider list", federationFallbackConstraint, c)
+			}
+		}
+	}
+	candidates = append(candidates, d.getDefaultProvider())
+
+	for _, u := range candidates {
+		if u.String() != primary.String() && !d.isDown(u) {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// isDown returns true when the provider failed its health checks or its circuit breaker is open
+func (d *defaultProviderRouting) isDown(u *url.URL) bool {
+	h, ok := d.GetProviderHealth(d.ProviderName(u))
+	return (ok && h.IsDown()) || !d.GetCircuitBreaker(u).Available()
 }
 
 func ensureAnnotation(f *types.FunctionDeployment, defaultValue string) {
@@ -124,34 +919,296 @@ func ensureAnnotation(f *types.FunctionDeployment, defaultValue string) {
 	}
 }
 
-func (d *defaultProviderRouting) matchBasedOnName(v string) *url.URL {
-	for _, u := range d.providers {
-		if strings.EqualFold(getHostNameWithoutPorts(u), v) {
-			return u
+// matchSelector returns the provider whose labels match s, preferring the default provider
+// and otherwise the first matching provider by name
+func (d *defaultProviderRouting) matchSelector(s selector) (*url.URL, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	var names []string
+	for name := range d.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var matched []string
+	for _, name := range names {
+		if s.Matches(d.providerLabels(name)) {
+			matched = append(matched, name)
 		}
 	}
 
-	return nil
+	if len(matched) == 0 {
+		var available []string
+		for _, name := range names {
+			available = append(available, fmt.Sprintf("%s [%s]", name, FormatLabels(d.providerLabels(name))))
+		}
+
+		return nil, fmt.Errorf("no provider matches selector %q, available providers: %s", s.String(), strings.Join(available, ", "))
+	}
+
+	defaultName := d.nameOf(d.defaultProvider)
+	for _, name := range matched {
+		if name == defaultName {
+			return d.providers[name], nil
+		}
+	}
+
+	return d.providers[matched[0]], nil
+}
+
+// providerLabels returns the configured labels of a provider along with its name label,
+// callers must hold the lock
+func (d *defaultProviderRouting) providerLabels(name string) map[string]string {
+	result := map[string]string{}
+	for k, v := range d.labels[name] {
+		result[k] = v
+	}
+	result[providerNameLabel] = name
+
+	return result
+}
+
+// ResolveAlternates returns the providers an invocation may be retried against, in order of
+// preference: those listed in the function's weights or replicas, then its fallback provider.
+// Providers which are down are left out
+func (d *defaultProviderRouting) ResolveAlternates(functionName string) ([]*url.URL, error) {
+	f, err := d.lookupFunction(functionName)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	if weights, ok := d.GetFunctionWeights(functionKey(f)); ok {
+		for _, w := range weights {
+			if w.Weight > 0 {
+				names = append(names, w.Provider)
+			}
+		}
+	}
+
+	if f.Annotations != nil {
+		if c, ok := (*f.Annotations)[federationFallbackConstraint]; ok {
+			names = append(names, c)
+		}
+	}
+
+	var result []*url.URL
+	seen := map[string]bool{}
+	for _, name := range names {
+		pURL, err := d.matchSelector(nameSelector(name))
+		if err != nil || seen[pURL.String()] || d.isDown(pURL) {
+			continue
+		}
+
+		seen[pURL.String()] = true
+		result = append(result, pURL)
+	}
+
+	return result, nil
+}
+
+// ResolveReplicas returns the providers a replicated function is deployed to, keyed by
+// provider name, or nil when the function is not replicated
+func (d *defaultProviderRouting) ResolveReplicas(f *types.FunctionDeployment) (map[string]*url.URL, error) {
+	if f.Annotations == nil {
+		return nil, nil
+	}
+
+	v, ok := (*f.Annotations)[federationReplicasOnAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	names := parseProviderNames(v)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("invalid %s annotation for function %s, no providers listed", federationReplicasOnAnnotation, f.Service)
+	}
+
+	providers := d.GetProviders()
+	result := map[string]*url.URL{}
+	for _, name := range names {
+		pURL, ok := providers[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid %s annotation for function %s, provider %s does not exist", federationReplicasOnAnnotation, f.Service, name)
+		}
+		result[name] = pURL
+	}
+
+	return result, nil
+}
+
+// functionWeights parses the weights annotation of a function, a replicated function without
+// weights has an equal weight on each of its providers, nil is returned for other functions
+func (d *defaultProviderRouting) functionWeights(f *types.FunctionDeployment) ([]ProviderWeight, error) {
+	if f.Annotations == nil {
+		return nil, nil
+	}
+
+	replicas, err := d.ResolveReplicas(f)
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := (*f.Annotations)[federationWeightsAnnotation]
+	if !ok {
+		var weights []ProviderWeight
+		for _, name := range parseProviderNames((*f.Annotations)[federationReplicasOnAnnotation]) {
+			weights = append(weights, ProviderWeight{Provider: name, Weight: 1})
+		}
+
+		return weights, nil
+	}
+
+	weights, err := parseWeights(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation for function %s. %v", federationWeightsAnnotation, f.Service, err)
+	}
+
+	for _, w := range weights {
+		if _, ok := replicas[w.Provider]; replicas != nil && !ok {
+			return nil, fmt.Errorf("invalid %s annotation for function %s, provider %s is not listed in %s", federationWeightsAnnotation, f.Service, w.Provider, federationReplicasOnAnnotation)
+		}
+	}
+
+	providers := d.GetProviders()
+	for _, w := range weights {
+		if _, ok := providers[w.Provider]; !ok {
+			return nil, fmt.Errorf("invalid %s annotation for function %s, provider %s does not exist", federationWeightsAnnotation, f.Service, w.Provider)
+		}
+	}
+
+	return weights, nil
+}
+
+// IsRetryable returns true when a function has opted in to having failed invocations
+// retried against another provider, which is only safe for idempotent functions
+func IsRetryable(f *types.FunctionDeployment) bool {
+	if f == nil || f.Annotations == nil {
+		return false
+	}
+
+	return strings.EqualFold((*f.Annotations)[federationRetryAnnotation], "true")
 }
 
-func getHostNameWithoutPorts(v *url.URL) string {
-	return strings.Split(v.Host, ":")[0]
+// parseProviderNames parses a comma separated list of provider names
+func parseProviderNames(v string) []string {
+	var result []string
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			result = append(result, name)
+		}
+	}
+
+	return result
 }
 
+func nameSelector(name string) selector {
+	return selector{{key: providerNameLabel, operator: selectorEquals, value: name}}
+}
+
+// FormatLabels formats labels as comma separated key=value pairs, sorted by key
+func FormatLabels(labels map[string]string) string {
+	var parts []string
+	for k, v := range labels {
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, ",")
+}
+
+// AddFunction stores a function deployed or updated through the federation, its weights
+// annotation replaces any weights previously held for the function
 func (d *defaultProviderRouting) AddFunction(f *types.FunctionDeployment) {
+	weights, err := d.functionWeights(f)
+	if err != nil {
+		log.Warnf("ignoring weights for function %s. %v", f.Service, err)
+	}
+
+	key := functionKey(f)
+	event := d.functionEvent(EventFunctionAdded, key, f)
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	d.cache[f.Service] = f
+	if previous, ok := d.cache[key]; !ok {
+		d.events.publish(event)
+	} else if !sameDeployment(previous, f) {
+		event.Type = EventFunctionUpdated
+		d.events.publish(event)
+	}
+	d.cache[key] = f
+	delete(d.orphaned, key)
+	if d.weights == nil {
+		d.weights = make(map[string][]ProviderWeight)
+	}
+
+	if weights != nil {
+		d.weights[key] = weights
+	} else {
+		delete(d.weights, key)
+	}
 }
 
-func (d *defaultProviderRouting) GetFunction(name string) (*types.FunctionDeployment, bool) {
+// RemoveFunction removes a function deleted through the federation from the cache along
+// with its weights, the name is resolved as by GetFunction. It returns false when no function
+// was cached by that name
+func (d *defaultProviderRouting) RemoveFunction(name string) bool {
+	f, ok := d.GetFunction(name)
+	if !ok {
+		return false
+	}
+
+	key := functionKey(f)
+	event := d.functionEvent(EventFunctionRemoved, key, f)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.cache[key] != f {
+		log.Infof("function %s was redeployed while it was deleted, keeping it in the cache", key)
+		return false
+	}
+
+	delete(d.cache, key)
+	delete(d.weights, key)
+	delete(d.orphaned, key)
+	d.events.publish(event)
+	metrics.Default.DeleteFunction(key)
+
+	return true
+}
+
+func (d *defaultProviderRouting) GetFunctionWeights(name string) ([]ProviderWeight, bool) {
 	d.lock.RLock()
 	defer d.lock.RUnlock()
-	v, ok := d.cache[name]
+	v, ok := d.weights[name]
 
 	return v, ok
 }
 
+// GetFunction returns a cached function by its name qualified with its namespace, a name
+// without a namespace also finds a function in a namespace when it is the only one by that name
+func (d *defaultProviderRouting) GetFunction(name string) (*types.FunctionDeployment, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if v, ok := d.cache[name]; ok {
+		return v, true
+	}
+
+	var found *types.FunctionDeployment
+	for _, v := range d.cache {
+		if v.Service != name {
+			continue
+		}
+
+		if found != nil {
+			log.Warnf("function %s exists in namespaces %s and %s, qualify the name with its namespace", name, found.Namespace, v.Namespace)
+			return nil, false
+		}
+		found = v
+	}
+
+	return found, found != nil
+}
+
 func (d *defaultProviderRouting) GetFunctions() []*types.FunctionDeployment {
 	d.lock.RLock()
 	defer d.lock.RUnlock()
@@ -162,3 +1219,145 @@ func (d *defaultProviderRouting) GetFunctions() []*types.FunctionDeployment {
 
 	return result
 }
+
+func (d *defaultProviderRouting) GetProviders() map[string]*url.URL {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	result := make(map[string]*url.URL, len(d.providers))
+	for k, v := range d.providers {
+		result[k] = v
+	}
+
+	return result
+}
+
+func (d *defaultProviderRouting) GetProviderHealth(name string) (ProviderHealth, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	v, ok := d.health[name]
+
+	return v, ok
+}
+
+func (d *defaultProviderRouting) GetProvidersHealth() []ProviderHealth {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	var result []ProviderHealth
+	for _, v := range d.health {
+		result = append(result, v)
+	}
+
+	return result
+}
+
+func (d *defaultProviderRouting) UpdateProviderHealth(h ProviderHealth) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.health == nil {
+		d.health = make(map[string]ProviderHealth)
+	}
+
+	// a check which raced with UpdateProviders may report on a removed provider
+	pURL, ok := d.providers[h.Name]
+	if !ok || (len(h.URL) > 0 && h.URL != pURL.String()) {
+		return
+	}
+
+	previous, ok := d.health[h.Name]
+	if !ok {
+		previous.Status = ProviderStatusUnknown
+	}
+	if previous.Status != h.Status {
+		d.events.publish(Event{Type: EventProviderHealth, Provider: h.Name, Status: h.Status, PreviousStatus: previous.Status})
+	}
+	d.health[h.Name] = h
+}
+
+func (d *defaultProviderRouting) GetCircuitBreaker(providerURI *url.URL) *CircuitBreaker {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.breakers[d.nameOf(providerURI)]
+}
+
+func (d *defaultProviderRouting) GetProvider(name string) (Provider, bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if _, ok := d.providers[name]; !ok {
+		return Provider{}, false
+	}
+
+	return d.provider(name), true
+}
+
+// provider returns a provider by name, callers must hold the lock
+func (d *defaultProviderRouting) provider(name string) Provider {
+	pURL := d.providers[name]
+	return Provider{
+		Name:        name,
+		URL:         pURL.String(),
+		Labels:      d.labels[name],
+		Timeout:     d.timeouts[name],
+		Credentials: d.credentials[name],
+		TLS:         d.tlsConfigs[name],
+		Namespaces:  d.namespaces[name],
+		Default:     d.defaultProvider != nil && pURL.String() == d.defaultProvider.String(),
+	}
+}
+
+// GetProviderStats counts the cached functions placed on or replicated to a provider
+func (d *defaultProviderRouting) GetProviderStats(name string) ProviderStats {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	stats := ProviderStats{}
+	for _, f := range d.cache {
+		if strings.EqualFold(gatewayName(f), name) {
+			stats.Functions++
+			continue
+		}
+
+		if f.Annotations == nil {
+			continue
+		}
+		for _, replica := range parseProviderNames((*f.Annotations)[federationReplicasOnAnnotation]) {
+			if replica == name {
+				stats.Functions++
+				break
+			}
+		}
+	}
+
+	if refreshed, ok := d.refreshed[name]; ok {
+		stats.LastRefresh = &refreshed
+	}
+
+	if listing, ok := d.listings[name]; ok {
+		stats.LastListing = &listing
+	}
+
+	return stats
+}
+
+// ProviderName returns the name of the provider with the given URL, or an empty string
+func (d *defaultProviderRouting) ProviderName(providerURI *url.URL) string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.nameOf(providerURI)
+}
+
+// nameOf finds the name of a provider by its URL, callers must hold the lock
+func (d *defaultProviderRouting) nameOf(providerURI *url.URL) string {
+	if providerURI == nil {
+		return ""
+	}
+
+	for name, u := range d.providers {
+		if u == providerURI || u.String() == providerURI.String() {
+			return name
+		}
+	}
+
+	return ""
+}
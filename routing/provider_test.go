@@ -1,10 +1,14 @@
 package routing
 
 import (
+	"fmt"
+	"net/http"
 	"net/url"
 	"testing"
+	"time"
 
 	acc "github.com/openfaas-incubator/faas-federation/testing"
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
 	types "github.com/openfaas/faas-provider/types"
 )
 
@@ -12,6 +16,8 @@ func Test_defaultProviderRouting_Resolve(t *testing.T) {
 	type fields struct {
 		cache           map[string]*types.FunctionDeployment
 		providers       map[string]*url.URL
+		labels          map[string]map[string]string
+		health          map[string]ProviderHealth
 		defaultProvider string
 	}
 	type args struct {
@@ -52,6 +58,19 @@ func Test_defaultProviderRouting_Resolve(t *testing.T) {
 				defaultProvider: "http://faas-provider-a:8080",
 			}, args: args{functionName: "cat"}, wantProviderHostName: "faas-provider-b:8080", wantErr: false,
 		},
+		{
+			name: "provider b is resolved, when the constraint differs in case",
+			fields: fields{
+				cache: map[string]*types.FunctionDeployment{
+					"cat": {Service: "cat", Annotations: &map[string]string{federationProviderNameConstraint: "FAAS-Provider-B"}},
+				},
+				providers: map[string]*url.URL{
+					"faas-provider-a": parseURL("http://faas-provider-a:8080"),
+					"faas-provider-b": parseURL("http://faas-provider-b:8080"),
+				},
+				defaultProvider: "http://faas-provider-a:8080",
+			}, args: args{functionName: "cat"}, wantProviderHostName: "faas-provider-b:8080", wantErr: false,
+		},
 		{
 			name: "default provider is resolved, when constraint is missing",
 			fields: fields{
@@ -66,12 +85,167 @@ func Test_defaultProviderRouting_Resolve(t *testing.T) {
 				defaultProvider: "http://faas-provider-a:8080",
 			}, args: args{functionName: "cat"}, wantProviderHostName: "faas-provider-a:8080", wantErr: false,
 		},
+		{
+			name: "default provider is resolved, when constrained provider is down",
+			fields: fields{
+				cache: map[string]*types.FunctionDeployment{
+					"cat": {Service: "cat", Annotations: &map[string]string{federationProviderNameConstraint: "faas-provider-b"}},
+				},
+				providers: map[string]*url.URL{
+					"faas-provider-a": parseURL("http://faas-provider-a:8080"),
+					"faas-provider-b": parseURL("http://faas-provider-b:8080"),
+				},
+				health: map[string]ProviderHealth{
+					"faas-provider-b": {Name: "faas-provider-b", Status: ProviderStatusDown},
+				},
+				defaultProvider: "http://faas-provider-a:8080",
+			}, args: args{functionName: "cat"}, wantProviderHostName: "faas-provider-a:8080", wantErr: false,
+		},
+		{
+			name: "fallback provider is resolved, when constrained provider is down",
+			fields: fields{
+				cache: map[string]*types.FunctionDeployment{
+					"cat": {Service: "cat", Annotations: &map[string]string{
+						federationProviderNameConstraint: "faas-provider-b",
+						federationFallbackConstraint:     "faas-provider-c",
+					}},
+				},
+				providers: map[string]*url.URL{
+					"faas-provider-a": parseURL("http://faas-provider-a:8080"),
+					"faas-provider-b": parseURL("http://faas-provider-b:8080"),
+					"faas-provider-c": parseURL("http://faas-provider-c:8080"),
+				},
+				health: map[string]ProviderHealth{
+					"faas-provider-b": {Name: "faas-provider-b", Status: ProviderStatusDown},
+				},
+				defaultProvider: "http://faas-provider-a:8080",
+			}, args: args{functionName: "cat"}, wantProviderHostName: "faas-provider-c:8080", wantErr: false,
+		},
+		{
+			name: "default provider is resolved, when fallback provider is also down",
+			fields: fields{
+				cache: map[string]*types.FunctionDeployment{
+					"cat": {Service: "cat", Annotations: &map[string]string{
+						federationProviderNameConstraint: "faas-provider-b",
+						federationFallbackConstraint:     "faas-provider-c",
+					}},
+				},
+				providers: map[string]*url.URL{
+					"faas-provider-a": parseURL("http://faas-provider-a:8080"),
+					"faas-provider-b": parseURL("http://faas-provider-b:8080"),
+					"faas-provider-c": parseURL("http://faas-provider-c:8080"),
+				},
+				health: map[string]ProviderHealth{
+					"faas-provider-b": {Name: "faas-provider-b", Status: ProviderStatusDown},
+					"faas-provider-c": {Name: "faas-provider-c", Status: ProviderStatusDown},
+				},
+				defaultProvider: "http://faas-provider-a:8080",
+			}, args: args{functionName: "cat"}, wantProviderHostName: "faas-provider-a:8080", wantErr: false,
+		},
+		{
+			name: "degraded constrained provider is still resolved",
+			fields: fields{
+				cache: map[string]*types.FunctionDeployment{
+					"cat": {Service: "cat", Annotations: &map[string]string{federationProviderNameConstraint: "faas-provider-b"}},
+				},
+				providers: map[string]*url.URL{
+					"faas-provider-a": parseURL("http://faas-provider-a:8080"),
+					"faas-provider-b": parseURL("http://faas-provider-b:8080"),
+				},
+				health: map[string]ProviderHealth{
+					"faas-provider-b": {Name: "faas-provider-b", Status: ProviderStatusDegraded},
+				},
+				defaultProvider: "http://faas-provider-a:8080",
+			}, args: args{functionName: "cat"}, wantProviderHostName: "faas-provider-b:8080", wantErr: false,
+		},
+		{
+			name: "provider is resolved by selector",
+			fields: fields{
+				cache: map[string]*types.FunctionDeployment{
+					"echo": {Service: "echo", Annotations: &map[string]string{federationSelectorConstraint: "region=eu-west,arch=arm64"}},
+				},
+				providers: map[string]*url.URL{
+					"faas-provider-a": parseURL("http://faas-provider-a:8080"),
+					"faas-provider-b": parseURL("http://faas-provider-b:8080"),
+				},
+				labels: map[string]map[string]string{
+					"faas-provider-a": {"region": "eu-west", "arch": "amd64"},
+					"faas-provider-b": {"region": "eu-west", "arch": "arm64"},
+				},
+				defaultProvider: "http://faas-provider-a:8080",
+			}, args: args{functionName: "echo"}, wantProviderHostName: "faas-provider-b:8080", wantErr: false,
+		},
+		{
+			name: "default provider is preferred when several providers match the selector",
+			fields: fields{
+				cache: map[string]*types.FunctionDeployment{
+					"echo": {Service: "echo", Annotations: &map[string]string{federationSelectorConstraint: "region=eu-west"}},
+				},
+				providers: map[string]*url.URL{
+					"faas-provider-a": parseURL("http://faas-provider-a:8080"),
+					"faas-provider-b": parseURL("http://faas-provider-b:8080"),
+				},
+				labels: map[string]map[string]string{
+					"faas-provider-a": {"region": "eu-west"},
+					"faas-provider-b": {"region": "eu-west"},
+				},
+				defaultProvider: "http://faas-provider-b:8080",
+			}, args: args{functionName: "echo"}, wantProviderHostName: "faas-provider-b:8080", wantErr: false,
+		},
+		{
+			name: "name constraint takes precedence over selector",
+			fields: fields{
+				cache: map[string]*types.FunctionDeployment{
+					"echo": {Service: "echo", Annotations: &map[string]string{
+						federationProviderNameConstraint: "faas-provider-a",
+						federationSelectorConstraint:     "kind=lambda",
+					}},
+				},
+				providers: map[string]*url.URL{
+					"faas-provider-a": parseURL("http://faas-provider-a:8080"),
+					"faas-provider-b": parseURL("http://faas-provider-b:8080"),
+				},
+				labels: map[string]map[string]string{
+					"faas-provider-b": {"kind": "lambda"},
+				},
+				defaultProvider: "http://faas-provider-b:8080",
+			}, args: args{functionName: "echo"}, wantProviderHostName: "faas-provider-a:8080", wantErr: false,
+		},
+		{
+			name: "error when no provider matches the selector",
+			fields: fields{
+				cache: map[string]*types.FunctionDeployment{
+					"echo": {Service: "echo", Annotations: &map[string]string{federationSelectorConstraint: "region=us-east"}},
+				},
+				providers: map[string]*url.URL{
+					"faas-provider-a": parseURL("http://faas-provider-a:8080"),
+				},
+				labels: map[string]map[string]string{
+					"faas-provider-a": {"region": "eu-west"},
+				},
+				defaultProvider: "http://faas-provider-a:8080",
+			}, args: args{functionName: "echo"}, wantErr: true,
+		},
+		{
+			name: "error when the selector is invalid",
+			fields: fields{
+				cache: map[string]*types.FunctionDeployment{
+					"echo": {Service: "echo", Annotations: &map[string]string{federationSelectorConstraint: "region"}},
+				},
+				providers: map[string]*url.URL{
+					"faas-provider-a": parseURL("http://faas-provider-a:8080"),
+				},
+				defaultProvider: "http://faas-provider-a:8080",
+			}, args: args{functionName: "echo"}, wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			d := &defaultProviderRouting{
 				cache:           tt.fields.cache,
 				providers:       tt.fields.providers,
+				labels:          tt.fields.labels,
+				health:          tt.fields.health,
 				defaultProvider: parseURL(tt.fields.defaultProvider),
 			}
 			gotProviderHostName, err := d.Resolve(tt.args.functionName)
@@ -80,6 +254,10 @@ func Test_defaultProviderRouting_Resolve(t *testing.T) {
 				return
 			}
 
+			if tt.wantErr {
+				return
+			}
+
 			if gotProviderHostName == nil {
 				t.Errorf("defaultProviderRouting.Resolve() = nil")
 			}
@@ -182,3 +360,161 @@ func Test_ensureAnnotation(t *testing.T) {
 		})
 	}
 }
+
+func Test_GatewayNameIgnoresCase(t *testing.T) {
+	d, err := NewDefaultProviderRouting([]Provider{
+		{Name: "faas-provider-a", URL: "http://faas-provider-a:8080", Default: true},
+		{Name: "faas-provider-b", URL: "http://faas-provider-b:8080"},
+	}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationProviderNameConstraint: "FAAS-Provider-B"}})
+
+	if stats := d.GetProviderStats("faas-provider-b"); stats.Functions != 1 {
+		t.Errorf("want the function counted for faas-provider-b, got %d", stats.Functions)
+	}
+
+	if got := d.(*defaultProviderRouting).placedOn(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationProviderNameConstraint: "FAAS-Provider-B"}}); fmt.Sprint(got) != "[faas-provider-b]" {
+		t.Errorf("want the function placed on faas-provider-b, got %v", got)
+	}
+
+	if err := d.UpdateProviders([]Provider{{Name: "faas-provider-a", URL: "http://faas-provider-a:8080", Default: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"echo": "faas-provider-b"}
+	if got := d.GetOrphanedFunctions(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("want orphaned functions %v, got %v", want, got)
+	}
+}
+
+func Test_AddProvider_ReplacesDefault(t *testing.T) {
+	d, err := NewDefaultProviderRouting([]Provider{
+		{Name: "faas-provider-a", URL: "http://faas-provider-a:8080", Default: true},
+	}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationProviderNameConstraint: "faas-provider-a"}})
+	d.AddFunction(&types.FunctionDeployment{Service: "cat", Annotations: &map[string]string{federationReplicasOnAnnotation: "faas-provider-a,faas-provider-b"}})
+
+	if err := d.AddProvider(Provider{Name: "faas-provider-b", URL: "http://faas-provider-b:8080", Default: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if a, _ := d.GetProvider("faas-provider-a"); a.Default {
+		t.Error("want faas-provider-a to no longer be the default")
+	}
+
+	if got, _ := d.ResolveFunction(&types.FunctionDeployment{Service: "wc"}); got.Host != "faas-provider-b:8080" {
+		t.Errorf("want new default faas-provider-b:8080, got %s", got.Host)
+	}
+
+	if got := d.GetProviderStats("faas-provider-a").Functions; got != 2 {
+		t.Errorf("want 2 functions on faas-provider-a, got %d", got)
+	}
+
+	if err := d.AddProvider(Provider{Name: "faas-provider-c", URL: "faas-provider-c"}); err == nil {
+		t.Error("want error for a URL without a scheme")
+	}
+}
+
+func Test_Namespaces(t *testing.T) {
+	d, err := NewDefaultProviderRouting([]Provider{
+		{Name: "faas-provider-a", URL: "http://faas-provider-a:8080", Default: true},
+		{Name: "faas-provider-b", URL: "http://faas-provider-b:8080", Namespaces: []string{"team-b"}},
+	}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.AddFunction(&types.FunctionDeployment{Service: "echo"})
+	d.AddFunction(&types.FunctionDeployment{Service: "echo", Namespace: "team-b"})
+	d.AddFunction(&types.FunctionDeployment{Service: "cat", Namespace: "team-b"})
+	d.AddFunction(&types.FunctionDeployment{Service: "wc", Namespace: "team-b", Annotations: &map[string]string{federationProviderNameConstraint: "faas-provider-a"}})
+
+	tests := []struct {
+		functionName string
+		wantHost     string
+		wantErr      bool
+	}{
+		{functionName: "echo", wantHost: "faas-provider-a:8080"},
+		{functionName: "echo.team-b", wantHost: "faas-provider-b:8080"},
+		{functionName: "cat", wantHost: "faas-provider-b:8080"},
+		{functionName: "cat.team-b", wantHost: "faas-provider-b:8080"},
+		{functionName: "wc.team-b", wantHost: "faas-provider-a:8080"},
+		{functionName: "cat.team-a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.functionName, func(t *testing.T) {
+			got, err := d.Resolve(tt.functionName)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("want error, got %s", got)
+				}
+				return
+			}
+
+			if err != nil || got.Host != tt.wantHost {
+				t.Errorf("want %s, got %v with error %v", tt.wantHost, got, err)
+			}
+		})
+	}
+
+	d.AddFunction(&types.FunctionDeployment{Service: "cat", Namespace: "team-c"})
+	if _, ok := d.GetFunction("cat"); ok {
+		t.Error("want no function for a name found in several namespaces")
+	}
+
+	if _, err := NewDefaultProviderRouting([]Provider{
+		{Name: "faas-provider-a", URL: "http://faas-provider-a:8080", Default: true, Namespaces: []string{"team-b"}},
+		{Name: "faas-provider-b", URL: "http://faas-provider-b:8080", Namespaces: []string{"team-b"}},
+	}, Config{}); err == nil {
+		t.Error("want error for a namespace mapped to two providers")
+	}
+}
+
+func Test_GetClient_Timeout(t *testing.T) {
+	slow := providertest.New(http.StatusOK)
+	slow.SetDelay(time.Second)
+	defer slow.Close()
+
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		provider time.Duration
+		wantErr  bool
+	}{
+		{name: "provider timeout", timeout: time.Minute, provider: 50 * time.Millisecond, wantErr: true},
+		{name: "default timeout", timeout: 50 * time.Millisecond, wantErr: true},
+		{name: "provider timeout longer than the response", timeout: 50 * time.Millisecond, provider: time.Minute},
+		{name: "no timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := NewDefaultProviderRouting([]Provider{
+				{Name: "faas-provider-a", URL: slow.URL, Timeout: tt.provider, Default: true},
+			}, Config{Timeout: tt.timeout})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := d.GetClient().Get(slow.URL + "/system/functions")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("want the request to time out, got status %d", res.StatusCode)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+		})
+	}
+}
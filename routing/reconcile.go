@@ -0,0 +1,409 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/openfaas-incubator/faas-federation/metrics"
+	types "github.com/openfaas/faas-provider/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// CacheDiff lists the functions added to, updated in and removed from the cache for a provider
+type CacheDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Updated []string `json:"updated,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Empty returns true when the cache did not change
+func (c *CacheDiff) Empty() bool {
+	return len(c.Added) == 0 && len(c.Updated) == 0 && len(c.Removed) == 0
+}
+
+func (c *CacheDiff) String() string {
+	var parts []string
+	for _, v := range []struct {
+		name      string
+		functions []string
+	}{{"added", c.Added}, {"updated", c.Updated}, {"removed", c.Removed}} {
+		if len(v.functions) > 0 {
+			sort.Strings(v.functions)
+			parts = append(parts, fmt.Sprintf("%s %s", v.name, strings.Join(v.functions, ",")))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// CacheReconciler periodically reconciles a FunctionCache with the
+// functions deployed to each provider
+type CacheReconciler struct {
+	providerLookup FunctionCache
+	// snapshotPath the cache is written to after each reconciliation, empty disables snapshots
+	snapshotPath string
+}
+
+// NewCacheReconciler creates a CacheReconciler which writes a snapshot of the cache to
+// snapshotPath after each reconciliation, an empty path writes no snapshot
+func NewCacheReconciler(providerLookup FunctionCache, snapshotPath string) *CacheReconciler {
+	return &CacheReconciler{providerLookup: providerLookup, snapshotPath: snapshotPath}
+}
+
+// Reconcile reconciles the cache once and writes its snapshot when the reconciliation succeeded
+func (c *CacheReconciler) Reconcile(ctx context.Context) error {
+	if _, err := c.providerLookup.ReconcileCache(ctx); err != nil {
+		return err
+	}
+
+	if len(c.snapshotPath) == 0 {
+		return nil
+	}
+
+	if err := WriteCacheSnapshot(c.snapshotPath, c.providerLookup.CacheSnapshot()); err != nil {
+		return fmt.Errorf("could not write the cache snapshot %s. %v", c.snapshotPath, err)
+	}
+
+	return nil
+}
+
+// Run reconciles the cache every interval until done is closed, a reconciliation which is
+// in progress when done is closed is cancelled
+func (c *CacheReconciler) Run(interval time.Duration, done <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := c.Reconcile(ctx); err != nil {
+				log.Errorf("reconciling the function cache failed, error: %v", err)
+			}
+		}
+	}
+}
+
+// ReconcileCache lists the functions of each provider and replaces the cache with them. A
+// cached function is removed when every provider it is placed on was listed without it, the
+// functions of a provider which could not be listed are kept. A function deployed through
+// the federation while the providers were listed is kept as it was deployed. Each listing
+// ends after the timeout of its provider, as sent by GetClient. The changes
+// are returned by the name of the provider they were listed on, or placed on when removed.
+// A function removed through the federation while the providers were listed stays removed.
+// An error is returned, and the cache is left as it was, when no provider could be listed
+func (d *defaultProviderRouting) ReconcileCache(ctx context.Context) (map[string]*CacheDiff, error) {
+	log.Info("reloading cache starting...")
+
+	d.lock.RLock()
+	snapshot := make(map[string]*types.FunctionDeployment, len(d.cache))
+	for k, v := range d.cache {
+		snapshot[k] = v
+	}
+	d.lock.RUnlock()
+
+	providers := d.ListProviders()
+	result, err := readServices(ctx, d.GetClient(), providerListings(providers))
+	if err != nil {
+		return nil, fmt.Errorf("could not reload cache. %v", err)
+	}
+
+	for _, l := range result.Listings {
+		d.recordListing(*l)
+	}
+
+	if failed := result.Failed(); len(failed) > 0 && len(failed) == len(result.Listings) {
+		return nil, fmt.Errorf("could not reload cache, no provider could be listed: %s", strings.Join(failed, ", "))
+	}
+
+	listed, listedBy := d.listedFunctions(result, snapshot)
+	weights := map[string][]ProviderWeight{}
+	for key, f := range listed {
+		if w, _ := d.functionWeights(f); w != nil {
+			weights[key] = w
+		}
+	}
+
+	listedOK := map[string]bool{}
+	for name, l := range result.Listings {
+		listedOK[name] = l.OK
+	}
+
+	// placing a function takes the lock, so the providers of the cached functions which were
+	// not listed are resolved beforehand
+	owners := map[string][]string{}
+	for key, f := range snapshot {
+		if _, ok := listed[key]; !ok {
+			owners[key] = d.placedOn(f)
+		}
+	}
+
+	diffs := map[string]*CacheDiff{}
+	diff := func(provider string) *CacheDiff {
+		if _, ok := diffs[provider]; !ok {
+			diffs[provider] = &CacheDiff{}
+		}
+		return diffs[provider]
+	}
+
+	var changes []cacheChange
+	d.lock.Lock()
+	cache := make(map[string]*types.FunctionDeployment, len(d.cache))
+	for key, f := range d.cache {
+		if f != snapshot[key] {
+			cache[key] = f
+			continue
+		}
+
+		if lf, ok := listed[key]; ok {
+			cache[key] = lf
+			if !sameDeployment(f, lf) {
+				diff(listedBy[key]).Updated = append(diff(listedBy[key]).Updated, key)
+				changes = append(changes, cacheChange{EventFunctionUpdated, key, lf})
+			}
+			continue
+		}
+
+		if !removable(owners[key], listedOK) {
+			cache[key] = f
+			continue
+		}
+
+		diff(owners[key][0]).Removed = append(diff(owners[key][0]).Removed, key)
+		changes = append(changes, cacheChange{EventFunctionRemoved, key, f})
+		delete(d.weights, key)
+		delete(d.orphaned, key)
+	}
+
+	for key, lf := range listed {
+		if _, ok := d.cache[key]; ok {
+			continue
+		}
+
+		if _, ok := snapshot[key]; ok {
+			// removed through the federation while the providers were listed
+			continue
+		}
+
+		cache[key] = lf
+		diff(listedBy[key]).Added = append(diff(listedBy[key]).Added, key)
+		changes = append(changes, cacheChange{EventFunctionAdded, key, lf})
+	}
+
+	if d.weights == nil {
+		d.weights = make(map[string][]ProviderWeight)
+	}
+	for key := range listed {
+		if cache[key] != listed[key] {
+			continue
+		}
+
+		// the listed function replaced the cached one, and so do its weights
+		if w, ok := weights[key]; ok {
+			d.weights[key] = w
+		} else {
+			delete(d.weights, key)
+		}
+		delete(d.orphaned, key)
+	}
+	d.cache = cache
+	d.lock.Unlock()
+
+	d.publishChanges(changes)
+	logCacheDiffs(result, diffs)
+
+	return diffs, nil
+}
+
+// cacheChange is a function added to, updated in or removed from the cache
+type cacheChange struct {
+	eventType EventType
+	key       string
+	f         *types.FunctionDeployment
+}
+
+// publishChanges publishes an event for each change and drops the metrics of the removed
+// functions, callers must not hold the lock
+func (d *defaultProviderRouting) publishChanges(changes []cacheChange) {
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].key < changes[j].key
+	})
+
+	for _, c := range changes {
+		d.events.publish(d.functionEvent(c.eventType, c.key, c.f))
+		if c.eventType == EventFunctionRemoved {
+			metrics.Default.DeleteFunction(c.key)
+		}
+	}
+}
+
+// listedFunctions returns the listed functions by cache key, each with the name of the
+// provider it was listed on. A function listed on several providers is taken from the first
+// provider by name which it is placed on in the cache, otherwise from the first provider by
+// name, so that a function moved to another provider is not moved back while it is still
+// listed by the provider it was moved from
+func (d *defaultProviderRouting) listedFunctions(result *ReadServicesResult, cache map[string]*types.FunctionDeployment) (map[string]*types.FunctionDeployment, map[string]string) {
+	var names []string
+	for name := range result.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	listed := map[string]*types.FunctionDeployment{}
+	listedBy := map[string]string{}
+	for _, name := range names {
+		for _, f := range result.Providers[name] {
+			cf := requestToCreate(f)
+			ensureAnnotation(cf, name)
+
+			key := functionKey(cf)
+			if _, ok := listed[key]; ok && !d.placedOnProvider(cache[key], name, listedBy[key]) {
+				continue
+			}
+
+			listed[key] = cf
+			listedBy[key] = name
+		}
+	}
+
+	return listed, listedBy
+}
+
+// placedOnProvider returns true when a cached function is placed on the provider but not on
+// the provider it was listed on before
+func (d *defaultProviderRouting) placedOnProvider(f *types.FunctionDeployment, provider string, listedBy string) bool {
+	if f == nil {
+		return false
+	}
+
+	placed := false
+	for _, name := range d.placedOn(f) {
+		if name == listedBy {
+			return false
+		}
+		placed = placed || name == provider
+	}
+
+	return placed
+}
+
+// placedOn returns the names of the providers a function is placed on, sorted by name. The
+// providers named by its replicas-on or gateway annotation are taken as they are, so that a
+// function placed on a removed provider is left for the orphan handling, otherwise the
+// function is placed as it is by the router. Callers must not hold the lock
+func (d *defaultProviderRouting) placedOn(f *types.FunctionDeployment) []string {
+	if names := annotatedProviders(f); len(names) > 0 {
+		providers := d.GetProviders()
+		for i, name := range names {
+			if n, ok := providerNamed(providers, name); ok {
+				names[i] = n
+			}
+		}
+		sort.Strings(names)
+
+		return names
+	}
+
+	providers, err := d.placement(f)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// annotatedProviders returns the names of the providers a function is placed on by its
+// replicas-on or gateway annotation, sorted by name
+func annotatedProviders(f *types.FunctionDeployment) []string {
+	if f.Annotations == nil {
+		return nil
+	}
+
+	var names []string
+	if v, ok := (*f.Annotations)[federationReplicasOnAnnotation]; ok {
+		names = parseProviderNames(v)
+	} else if name := gatewayName(f); len(name) > 0 {
+		names = []string{name}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// removable returns true when each of the providers was listed, a function whose providers
+// are not known is left for the orphan handling
+func removable(providers []string, listedOK map[string]bool) bool {
+	if len(providers) == 0 {
+		return false
+	}
+
+	for _, name := range providers {
+		if ok, found := listedOK[name]; !found || !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameDeployment compares the fields of a function which are listed by a provider
+func sameDeployment(a *types.FunctionDeployment, b *types.FunctionDeployment) bool {
+	return a.Service == b.Service &&
+		a.Namespace == b.Namespace &&
+		a.Image == b.Image &&
+		a.EnvProcess == b.EnvProcess &&
+		reflect.DeepEqual(a.Labels, b.Labels) &&
+		reflect.DeepEqual(a.Annotations, b.Annotations)
+}
+
+func logCacheDiffs(result *ReadServicesResult, diffs map[string]*CacheDiff) {
+	var names []string
+	for name := range result.Listings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var added, updated, removed int
+	for _, name := range names {
+		d, ok := diffs[name]
+		if !ok || d.Empty() {
+			continue
+		}
+
+		added += len(d.Added)
+		updated += len(d.Updated)
+		removed += len(d.Removed)
+		log.Infof("   provider %s: %s", name, d)
+	}
+
+	if failed := result.Failed(); len(failed) > 0 {
+		log.Warnf("reloading cache completed with %d added, %d updated and %d removed, keeping the cached functions of providers which could not be listed: %s", added, updated, removed, strings.Join(failed, ", "))
+		return
+	}
+
+	log.Infof("reloading cache completed successfully with %d added, %d updated and %d removed", added, updated, removed)
+}
@@ -0,0 +1,353 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+func Test_ReconcileCache(t *testing.T) {
+	a := providertest.New(http.StatusOK)
+	a.Handle("/system/functions", http.StatusOK, `[{"name": "echo", "image": "echo:1"}, {"name": "cat"}]`)
+	defer a.Close()
+	b := providertest.New(http.StatusOK)
+	b.Handle("/system/functions", http.StatusOK, `[{"name": "wc"}]`)
+	defer b.Close()
+
+	d, err := NewDefaultProviderRouting([]Provider{
+		{Name: "a", URL: a.URL, Default: true},
+		{Name: "b", URL: b.URL},
+	}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name     string
+		a        string
+		b        string
+		bStatus  int
+		want     map[string]string
+		wantKeys string
+	}{
+		{
+			name:     "functions are added",
+			a:        `[{"name": "echo", "image": "echo:1"}, {"name": "cat"}]`,
+			b:        `[{"name": "wc"}]`,
+			want:     map[string]string{"a": "added cat,echo", "b": "added wc"},
+			wantKeys: "cat,echo,wc",
+		},
+		{
+			name:     "functions are updated",
+			a:        `[{"name": "echo", "image": "echo:2"}]`,
+			b:        `[{"name": "wc"}, {"name": "cat", "annotations": {"com.openfaas.federation.gateway": "b"}}]`,
+			want:     map[string]string{"a": "updated echo", "b": "updated cat"},
+			wantKeys: "cat,echo,wc",
+		},
+		{
+			name:     "functions of a provider which fails are kept",
+			a:        `[]`,
+			bStatus:  http.StatusInternalServerError,
+			want:     map[string]string{"a": "removed echo"},
+			wantKeys: "cat,wc",
+		},
+		{
+			name:     "functions deleted from a provider are removed",
+			a:        `[]`,
+			b:        `[{"name": "wc"}]`,
+			want:     map[string]string{"b": "removed cat"},
+			wantKeys: "wc",
+		},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			a.Handle("/system/functions", http.StatusOK, step.a)
+			bStatus := step.bStatus
+			if bStatus == 0 {
+				bStatus = http.StatusOK
+			}
+			b.Handle("/system/functions", bStatus, step.b)
+
+			diffs, err := d.ReconcileCache(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]string{}
+			for name, diff := range diffs {
+				got[name] = diff.String()
+			}
+			if fmt.Sprint(got) != fmt.Sprint(step.want) {
+				t.Errorf("want changes %v, got %v", step.want, got)
+			}
+
+			var keys []string
+			for _, f := range d.GetFunctions() {
+				keys = append(keys, functionKey(f))
+			}
+			sort.Strings(keys)
+			if got := strings.Join(keys, ","); got != step.wantKeys {
+				t.Errorf("want cached functions %s, got %s", step.wantKeys, got)
+			}
+		})
+	}
+}
+
+func Test_ReconcileCache_KeepsFunctionsDeployedWhileListing(t *testing.T) {
+	listing := make(chan struct{})
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-listing
+		w.Write([]byte(`[]`))
+	}))
+	defer provider.Close()
+
+	d, err := NewDefaultProviderRouting([]Provider{{Name: "a", URL: provider.URL, Default: true}}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		d.ReconcileCache(context.Background())
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	d.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationProviderNameConstraint: "a"}})
+	close(listing)
+	<-done
+
+	if _, ok := d.GetFunction("echo"); !ok {
+		t.Error("want a function deployed while the providers were listed to be kept")
+	}
+}
+
+func Test_ReconcileCache_KeepsMovedFunctionPlacement(t *testing.T) {
+	a := providertest.New(http.StatusOK)
+	a.Handle("/system/functions", http.StatusOK, `[{"name": "echo"}]`)
+	defer a.Close()
+	b := providertest.New(http.StatusOK)
+	b.Handle("/system/functions", http.StatusOK, `[{"name": "echo", "annotations": {"com.openfaas.federation.gateway": "b"}}]`)
+	defer b.Close()
+
+	d, err := NewDefaultProviderRouting([]Provider{
+		{Name: "a", URL: a.URL, Default: true},
+		{Name: "b", URL: b.URL},
+	}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationProviderNameConstraint: "b"}})
+	if _, err := d.ReconcileCache(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := d.Resolve("echo")
+	if err != nil || got.String() != b.URL {
+		t.Errorf("want a function moved to b to stay on b while a still lists it, got %v with error %v", got, err)
+	}
+}
+
+func Test_ReconcileCache_RemovesFunctionsPlacedBySelector(t *testing.T) {
+	tests := []struct {
+		name    string
+		bStatus int
+		want    map[string]string
+		wantOK  bool
+	}{
+		{
+			name:    "removed once the selected provider is listed without it",
+			bStatus: http.StatusOK,
+			want:    map[string]string{"b": "removed echo"},
+		},
+		{
+			name:    "kept while the selected provider can not be listed",
+			bStatus: http.StatusInternalServerError,
+			want:    map[string]string{},
+			wantOK:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := providertest.New(http.StatusOK)
+			a.Handle("/system/functions", http.StatusOK, `[]`)
+			defer a.Close()
+			b := providertest.New(http.StatusOK)
+			b.Handle("/system/functions", tt.bStatus, `[]`)
+			defer b.Close()
+
+			d, err := NewDefaultProviderRouting([]Provider{
+				{Name: "a", URL: a.URL, Default: true},
+				{Name: "b", URL: b.URL, Labels: map[string]string{"region": "eu-west"}},
+			}, Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationSelectorConstraint: "region=eu-west"}})
+			diffs, err := d.ReconcileCache(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]string{}
+			for name, diff := range diffs {
+				got[name] = diff.String()
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("want changes %v, got %v", tt.want, got)
+			}
+
+			if _, ok := d.GetFunction("echo"); ok != tt.wantOK {
+				t.Errorf("want echo cached %v, got %v", tt.wantOK, ok)
+			}
+		})
+	}
+}
+
+func Test_ReconcileCache_FailsWhenNoProviderIsListed(t *testing.T) {
+	a := providertest.New(http.StatusOK)
+	a.Handle("/system/functions", http.StatusInternalServerError, "")
+	defer a.Close()
+	b := providertest.New(http.StatusOK)
+	b.Handle("/system/functions", http.StatusBadGateway, "")
+	defer b.Close()
+
+	d, err := NewDefaultProviderRouting([]Provider{
+		{Name: "a", URL: a.URL, Default: true},
+		{Name: "b", URL: b.URL},
+	}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationProviderNameConstraint: "a"}})
+
+	if _, err := d.ReconcileCache(context.Background()); err == nil || !strings.Contains(err.Error(), "a, b") {
+		t.Errorf("want an error naming every provider when none could be listed, got %v", err)
+	}
+	if _, ok := d.GetFunction("echo"); !ok {
+		t.Error("want the cache kept when no provider could be listed")
+	}
+
+	if _, err := d.Resolve("cat"); err == nil || !strings.Contains(err.Error(), "reload cache failed") {
+		t.Errorf("want the lookup of an unknown function to report the failed reload, got %v", err)
+	}
+}
+
+func Test_ReconcileCache_TimesOutSlowProviders(t *testing.T) {
+	a := providertest.New(http.StatusOK)
+	a.Handle("/system/functions", http.StatusOK, `[{"name": "echo"}]`)
+	defer a.Close()
+	slow := providertest.New(http.StatusOK)
+	slow.Handle("/system/functions", http.StatusOK, `[]`)
+	slow.SetDelay(time.Second)
+	defer slow.Close()
+
+	d, err := NewDefaultProviderRouting([]Provider{
+		{Name: "a", URL: a.URL, Default: true},
+		{Name: "slow", URL: slow.URL, Timeout: 50 * time.Millisecond},
+	}, Config{Timeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.AddFunction(&types.FunctionDeployment{Service: "cat", Annotations: &map[string]string{federationProviderNameConstraint: "slow"}})
+
+	start := time.Now()
+	if _, err := d.ReconcileCache(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("want the listing of the slow provider to time out, took %s", elapsed)
+	}
+	if stats := d.GetProviderStats("slow"); stats.LastListing == nil || stats.LastListing.OK {
+		t.Errorf("want the listing of the slow provider to fail, got %+v", stats.LastListing)
+	}
+	if _, ok := d.GetFunction("cat"); !ok {
+		t.Error("want the functions of the slow provider to be kept")
+	}
+	if _, ok := d.GetFunction("echo"); !ok {
+		t.Error("want the functions of the other providers to be listed")
+	}
+}
+
+func Test_ReconcileCache_KeepsFunctionsRemovedWhileListing(t *testing.T) {
+	listing := make(chan struct{})
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-listing
+		w.Write([]byte(`[{"name": "echo"}]`))
+	}))
+	defer provider.Close()
+
+	d, err := NewDefaultProviderRouting([]Provider{{Name: "a", URL: provider.URL, Default: true}}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationProviderNameConstraint: "a"}})
+
+	done := make(chan struct{})
+	go func() {
+		d.ReconcileCache(context.Background())
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	d.RemoveFunction("echo")
+	close(listing)
+	<-done
+
+	if _, ok := d.GetFunction("echo"); ok {
+		t.Error("want a function removed while the providers were listed to stay removed")
+	}
+}
+
+func Test_CacheReconciler_Run(t *testing.T) {
+	listed := make(chan struct{}, 1)
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case listed <- struct{}{}:
+		default:
+		}
+		w.Write([]byte(`[{"name": "echo"}]`))
+	}))
+	defer provider.Close()
+
+	d, err := NewDefaultProviderRouting([]Provider{{Name: "a", URL: provider.URL, Default: true}}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		NewCacheReconciler(d, "").Run(10*time.Millisecond, done)
+		close(stopped)
+	}()
+
+	select {
+	case <-listed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the providers to be listed")
+	}
+	close(done)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want Run to return when done is closed")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ProviderReloader replaces the providers of a ProviderLookup when their configuration changes
+type ProviderReloader struct {
+	providerLookup ProviderLookup
+	load           func() ([]Provider, error)
+	lock           sync.Mutex
+}
+
+// NewProviderReloader creates a ProviderReloader which reads the providers using load
+func NewProviderReloader(providerLookup ProviderLookup, load func() ([]Provider, error)) *ProviderReloader {
+	return &ProviderReloader{
+		providerLookup: providerLookup,
+		load:           load,
+	}
+}
+
+// Reload loads the providers, swaps them into the lookup and reconciles the function cache
+// against the new providers. The current providers are kept when the configuration is invalid
+func (r *ProviderReloader) Reload() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	providers, err := r.load()
+	if err != nil {
+		return fmt.Errorf("could not load providers. %v", err)
+	}
+
+	if err := r.providerLookup.UpdateProviders(providers); err != nil {
+		return fmt.Errorf("could not update providers. %v", err)
+	}
+
+	if err := r.providerLookup.ReloadCache(); err != nil {
+		return err
+	}
+
+	for function, provider := range r.providerLookup.GetOrphanedFunctions() {
+		log.Warnf("function %s is orphaned, its provider %s is no longer part of the federation", function, provider)
+	}
+
+	return nil
+}
+
+// Watch reloads the providers when a signal is received or when the file at path changes,
+// which is checked every interval. An empty path or an interval of 0 disables watching the
+// file. Watch returns when done is closed
+func (r *ProviderReloader) Watch(path string, interval time.Duration, signals <-chan os.Signal, done <-chan struct{}) {
+	var tick <-chan time.Time
+	var checksum [sha256.Size]byte
+	if len(path) > 0 && interval > 0 {
+		checksum, _ = fileChecksum(path)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case s := <-signals:
+			log.Infof("received %s, reloading providers", s)
+		case <-tick:
+			current, err := fileChecksum(path)
+			if err != nil {
+				log.Errorf("unable to read providers config %s, error: %v", path, err)
+				continue
+			}
+
+			if current == checksum {
+				continue
+			}
+			checksum = current
+			log.Infof("providers config %s changed, reloading providers", path)
+		}
+
+		if err := r.Reload(); err != nil {
+			log.Errorf("reloading providers failed, error: %v", err)
+			continue
+		}
+		log.Info("reloading providers completed successfully")
+	}
+}
+
+func fileChecksum(path string) ([sha256.Size]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+
+	return sha256.Sum256(data), nil
+}
@@ -0,0 +1,203 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+func Test_UpdateProviders(t *testing.T) {
+	d, err := NewDefaultProviderRouting([]Provider{
+		{Name: "faas-provider-a", URL: "http://faas-provider-a:8080", Default: true},
+		{Name: "faas-provider-b", URL: "http://faas-provider-b:8080"},
+	}, Config{CircuitBreaker: CircuitBreakerConfig{FailureThreshold: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationProviderNameConstraint: "faas-provider-b"}})
+	d.AddFunction(&types.FunctionDeployment{Service: "cat", Annotations: &map[string]string{federationProviderNameConstraint: "faas-provider-a"}})
+	d.AddFunction(&types.FunctionDeployment{Service: "wc", Annotations: &map[string]string{federationProviderNameConstraint: "unknown"}})
+
+	breakerA := d.GetCircuitBreaker(parseURL("http://faas-provider-a:8080"))
+
+	err = d.UpdateProviders([]Provider{
+		{Name: "faas-provider-a", URL: "http://faas-provider-a:8080", Default: true},
+		{Name: "faas-provider-c", URL: "http://faas-provider-c:8080"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := d.GetProviders()["faas-provider-c"]; !ok {
+		t.Error("want faas-provider-c to be added")
+	}
+
+	if got := d.GetCircuitBreaker(parseURL("http://faas-provider-a:8080")); got != breakerA {
+		t.Error("want circuit breaker of unchanged provider to be kept")
+	}
+
+	want := map[string]string{"echo": "faas-provider-b"}
+	if got := d.GetOrphanedFunctions(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("want orphaned functions %v, got %v", want, got)
+	}
+
+	if _, err := d.Resolve("echo"); err == nil || !strings.Contains(err.Error(), "orphaned") {
+		t.Errorf("want orphaned function to fail to resolve, got %v", err)
+	}
+
+	if got, err := d.Resolve("cat"); err != nil || got.Host != "faas-provider-a:8080" {
+		t.Errorf("want cat on faas-provider-a:8080, got %v, error: %v", got, err)
+	}
+
+	err = d.UpdateProviders([]Provider{
+		{Name: "faas-provider-a", URL: "http://faas-provider-a:8080", Default: true},
+		{Name: "faas-provider-b", URL: "http://faas-provider-b:8081"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := d.GetOrphanedFunctions(); len(got) != 0 {
+		t.Errorf("want no orphaned functions once the provider is back, got %v", got)
+	}
+
+	if err := d.UpdateProviders([]Provider{{Name: "faas-provider-a", URL: "http://faas-provider-a:8080"}}); err == nil {
+		t.Error("want error without a default provider")
+	}
+
+	if _, ok := d.GetProviders()["faas-provider-b"]; !ok {
+		t.Error("want providers to be kept when the update is invalid")
+	}
+}
+
+func Test_UpdateProviders_KeepsAPIChanges(t *testing.T) {
+	configured := []Provider{
+		{Name: "faas-provider-a", URL: "http://faas-provider-a:8080", Default: true},
+		{Name: "faas-provider-b", URL: "http://faas-provider-b:8080"},
+		{Name: "faas-provider-c", URL: "http://faas-provider-c:8080"},
+	}
+	d, err := NewDefaultProviderRouting(configured, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.AddProvider(Provider{Name: "edge-1", URL: "http://edge-1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.UpdateProvider(Provider{Name: "faas-provider-b", URL: "http://faas-provider-b:8081"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RemoveProvider("faas-provider-c"); err != nil {
+		t.Fatal(err)
+	}
+	d.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationProviderNameConstraint: "edge-1"}})
+
+	if err := d.UpdateProviders(configured); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"faas-provider-a": "http://faas-provider-a:8080",
+		"faas-provider-b": "http://faas-provider-b:8081",
+		"edge-1":          "http://edge-1:8080",
+	}
+	got := map[string]string{}
+	for name, u := range d.GetProviders() {
+		got[name] = u.String()
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("want providers %v after reload, got %v", want, got)
+	}
+
+	if orphaned := d.GetOrphanedFunctions(); len(orphaned) != 0 {
+		t.Errorf("want no orphaned functions after reload, got %v", orphaned)
+	}
+
+	if err := d.AddProvider(Provider{Name: "edge-2", URL: "http://edge-2:8080", Default: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.UpdateProviders(configured); err != nil {
+		t.Fatal(err)
+	}
+
+	if p, _ := d.GetProvider("edge-2"); !p.Default {
+		t.Error("want the default registered through the API to be kept after reload")
+	}
+	if p, _ := d.GetProvider("faas-provider-a"); p.Default {
+		t.Error("want the configured default to be replaced by the one registered through the API")
+	}
+
+	if err := d.AddProvider(Provider{Name: "faas-provider-c", URL: "http://faas-provider-c:8080"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.UpdateProviders(configured); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.GetProvider("faas-provider-c"); !ok {
+		t.Error("want a provider registered again after being deregistered to be kept")
+	}
+}
+
+func Test_ProviderReloader_Reload(t *testing.T) {
+	providerA := providertest.New(http.StatusOK)
+	providerA.Handle("/system/functions", http.StatusOK, providertest.Functions("cat"))
+	defer providerA.Close()
+	providerB := providertest.New(http.StatusOK)
+	providerB.Handle("/system/functions", http.StatusOK, providertest.Functions("echo"))
+	defer providerB.Close()
+	providerC := providertest.New(http.StatusOK)
+	providerC.Handle("/system/functions", http.StatusOK, providertest.Functions("echo"))
+	defer providerC.Close()
+
+	providers := []Provider{
+		{Name: "faas-provider-a", URL: providerA.URL, Default: true},
+		{Name: "faas-provider-b", URL: providerB.URL},
+	}
+
+	d, err := NewDefaultProviderRouting(providers, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.ReloadCache(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloader := NewProviderReloader(d, func() ([]Provider, error) {
+		return providers, nil
+	})
+
+	providers = []Provider{
+		{Name: "faas-provider-a", URL: providerA.URL, Default: true},
+		{Name: "faas-provider-c", URL: providerC.URL},
+	}
+
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := d.GetOrphanedFunctions(); len(got) != 0 {
+		t.Errorf("want echo to be found on faas-provider-c, got orphaned functions %v", got)
+	}
+
+	providers = []Provider{
+		{Name: "faas-provider-a", URL: providerA.URL, Default: true},
+	}
+
+	if err := reloader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"echo": "faas-provider-c"}
+	if got := d.GetOrphanedFunctions(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("want orphaned functions %v, got %v", want, got)
+	}
+}
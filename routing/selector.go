@@ -0,0 +1,114 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// providerNameLabel is set on every provider using its name, which defaults to the host name
+// of its URL, this allows the com.openfaas.federation.gateway annotation to be expressed as a
+// selector. Like every label it is matched without regard to case
+const providerNameLabel = "name"
+
+type selectorOperator string
+
+const (
+	selectorEquals    selectorOperator = "="
+	selectorNotEquals selectorOperator = "!="
+)
+
+type requirement struct {
+	key      string
+	operator selectorOperator
+	value    string
+}
+
+// selector is a set of requirements which must all match a provider's labels
+type selector []requirement
+
+// parseSelector parses a comma separated list of requirements such as `region=eu-west,arch!=arm64`
+func parseSelector(v string) (selector, error) {
+	var result selector
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		r := requirement{operator: selectorEquals}
+		var kv []string
+		switch {
+		case strings.Contains(part, "!="):
+			r.operator = selectorNotEquals
+			kv = strings.SplitN(part, "!=", 2)
+		case strings.Contains(part, "=="):
+			kv = strings.SplitN(part, "==", 2)
+		case strings.Contains(part, "="):
+			kv = strings.SplitN(part, "=", 2)
+		default:
+			return nil, fmt.Errorf("invalid selector requirement %q, expected key=value or key!=value", part)
+		}
+
+		r.key = strings.TrimSpace(kv[0])
+		r.value = strings.TrimSpace(kv[1])
+		if len(r.key) == 0 {
+			return nil, fmt.Errorf("invalid selector requirement %q, key is empty", part)
+		}
+
+		result = append(result, r)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("selector %q has no requirements", v)
+	}
+
+	return result, nil
+}
+
+// Matches returns true when every requirement is satisfied by labels
+func (s selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		v, ok := labels[r.key]
+		switch r.operator {
+		case selectorEquals:
+			if !ok || !strings.EqualFold(v, r.value) {
+				return false
+			}
+		case selectorNotEquals:
+			if ok && strings.EqualFold(v, r.value) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (s selector) String() string {
+	var parts []string
+	for _, r := range s {
+		parts = append(parts, r.key+string(r.operator)+r.value)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// MatchesSelector returns true when the labels of the provider, including its name label,
+// satisfy a selector such as `region=eu-west,arch!=arm64`
+func (p Provider) MatchesSelector(v string) (bool, error) {
+	s, err := parseSelector(v)
+	if err != nil {
+		return false, err
+	}
+
+	labels := map[string]string{}
+	for k, v := range p.Labels {
+		labels[k] = v
+	}
+	labels[providerNameLabel] = p.Name
+
+	return s.Matches(labels), nil
+}
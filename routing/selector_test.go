@@ -0,0 +1,64 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import "testing"
+
+func Test_parseSelector(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "single requirement", value: "region=eu-west", want: "region=eu-west"},
+		{name: "multiple requirements with spaces", value: "region = eu-west, arch=arm64", want: "region=eu-west,arch=arm64"},
+		{name: "double equals", value: "kind==lambda", want: "kind=lambda"},
+		{name: "not equals", value: "arch!=arm64", want: "arch!=arm64"},
+		{name: "missing operator", value: "region", wantErr: true},
+		{name: "missing key", value: "=eu-west", wantErr: true},
+		{name: "empty", value: " , ", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSelector(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSelector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && got.String() != tt.want {
+				t.Errorf("want %s, got %s", tt.want, got.String())
+			}
+		})
+	}
+}
+
+func Test_selector_Matches(t *testing.T) {
+	labels := map[string]string{"region": "eu-west", "arch": "arm64"}
+	tests := []struct {
+		name  string
+		value string
+		want  bool
+	}{
+		{name: "all requirements match", value: "region=eu-west,arch=arm64", want: true},
+		{name: "values match case insensitively", value: "region=EU-West", want: true},
+		{name: "one requirement does not match", value: "region=eu-west,arch=amd64", want: false},
+		{name: "missing label does not match", value: "kind=lambda", want: false},
+		{name: "not equals matches a different value", value: "arch!=amd64", want: true},
+		{name: "not equals matches a missing label", value: "kind!=lambda", want: true},
+		{name: "not equals does not match the same value", value: "arch!=arm64", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSelector(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := s.Matches(labels); got != tt.want {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -1,64 +1,213 @@
 package routing
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/url"
+	"sort"
+	"time"
 
+	"github.com/openfaas-incubator/faas-federation/metrics"
 	types "github.com/openfaas/faas-provider/types"
 	log "github.com/sirupsen/logrus"
 )
 
-// ReadServicesResult list of deployed functions by provider
+// ReadServicesResult list of deployed functions by provider name
 type ReadServicesResult struct {
 	Providers map[string][]*types.FunctionStatus
+	// Listings is the outcome of listing the functions of each provider by provider name
+	Listings map[string]*ProviderListing
 }
 
-// ReadServices queries each of the given providers to list deployed functions
-func ReadServices(providers []string) (*ReadServicesResult, error) {
-	var urls []*url.URL
+// Failed returns the names of the providers whose functions could not all be listed, sorted
+func (r *ReadServicesResult) Failed() []string {
+	var result []string
+	for name, l := range r.Listings {
+		if !l.OK {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
 
-	for _, v := range providers {
-		u, _ := url.Parse(v)
-		u.Path = "/system/functions"
-		urls = append(urls, u)
+	return result
+}
+
+// ProviderListing is the outcome of listing the functions of a provider, a provider with
+// namespaces has failed when any of its namespaces could not be listed
+type ProviderListing struct {
+	Provider string `json:"provider"`
+	OK       bool   `json:"ok"`
+	// StatusCode of the first failed request, or of the last request when none failed
+	StatusCode int    `json:"statusCode,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Latency of the slowest request
+	Latency   time.Duration `json:"-"`
+	Functions int           `json:"functions"`
+}
+
+// MarshalJSON writes the latency in the form "25ms"
+func (l ProviderListing) MarshalJSON() ([]byte, error) {
+	type listing ProviderListing
+	return json.Marshal(struct {
+		listing
+		Latency string `json:"latency"`
+	}{listing(l), l.Latency.String()})
+}
+
+func (l *ProviderListing) fail(statusCode int, err error) {
+	if !l.OK {
+		return
+	}
+
+	l.OK = false
+	l.StatusCode = statusCode
+	l.Error = err.Error()
+}
+
+// ReadServices queries each of the given providers to list deployed functions with client,
+// using the credentials of each provider. The functions in a provider's default namespace are
+// listed without a namespace, followed by those in each namespace mapped to the provider
+func ReadServices(client *http.Client, providers []Provider) (*ReadServicesResult, error) {
+	return readServices(context.Background(), client, providerListings(providers))
+}
+
+// ReadNamespaceServices queries each of the given providers to list the functions deployed
+// to namespace, the listings are cancelled with ctx
+func ReadNamespaceServices(ctx context.Context, client *http.Client, providers []Provider, namespace string) (*ReadServicesResult, error) {
+	var listings []serviceListing
+	for _, p := range providers {
+		listings = append(listings, serviceListing{provider: p, namespace: namespace})
+	}
+
+	return readServices(ctx, client, listings)
+}
+
+// providerListings lists the default namespace and each mapped namespace of the providers
+func providerListings(providers []Provider) []serviceListing {
+	var listings []serviceListing
+	for _, p := range providers {
+		listings = append(listings, serviceListing{provider: p})
+		for _, ns := range p.Namespaces {
+			listings = append(listings, serviceListing{provider: p, namespace: ns})
+		}
+	}
+
+	return listings
+}
+
+// serviceListing is a request for the functions in a namespace of a provider, an empty
+// namespace lists the provider's default namespace
+type serviceListing struct {
+	provider  Provider
+	namespace string
+}
+
+// readServices sends every listing, a listing which fails is recorded against its provider
+// and the other listings carry on
+func readServices(ctx context.Context, client *http.Client, listings []serviceListing) (*ReadServicesResult, error) {
+	serviceResult := &ReadServicesResult{
+		Providers: map[string][]*types.FunctionStatus{},
+		Listings:  map[string]*ProviderListing{},
 	}
 
-	results := Get(urls, len(providers))
-	serviceResult := &ReadServicesResult{Providers: map[string][]*types.FunctionStatus{}}
-	for _, v := range results {
-		if v.Err != nil {
-			log.Errorf("error fetching function list for %s. %v", providers[v.Index], v.Err)
-			break
+	var requests []*http.Request
+	var requestListings []serviceListing
+	for _, l := range listings {
+		p := l.provider
+		if _, ok := serviceResult.Listings[p.Name]; !ok {
+			serviceResult.Listings[p.Name] = &ProviderListing{Provider: p.Name, OK: true}
 		}
 
-		if v.Response.StatusCode > 399 {
-			log.Errorf("unexpected error code %d while fetching function list for %s. %v", v.Response.StatusCode, providers[v.Index], v.Err)
-			break
+		u, err := url.Parse(p.URL)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing URL for %s. %v", p.Name, err)
+		}
+		u.Path = "/system/functions"
+		if len(l.namespace) > 0 {
+			u.RawQuery = url.Values{"namespace": []string{l.namespace}}.Encode()
 		}
 
-		var function []*types.FunctionStatus
-		functionBytes, err := ioutil.ReadAll(v.Response.Body)
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
 		if err != nil {
-			return nil, fmt.Errorf("error reading response for %s. %v", providers[v.Index], err)
+			return nil, fmt.Errorf("error creating request for %s. %v", p.Name, err)
+		}
+		req = req.WithContext(ctx)
+
+		if err := p.Credentials.Authorize(req); err != nil {
+			log.Errorf("error authorizing request for %s. %v", p.Name, err)
+			serviceResult.Listings[p.Name].fail(0, err)
+			metrics.ListingErrors.Inc(p.Name)
+			continue
+		}
+		requests = append(requests, req)
+		requestListings = append(requestListings, l)
+	}
+
+	for _, v := range DoWithClient(client, requests, len(requests)) {
+		name := requestListings[v.Index].provider.Name
+		listing := serviceResult.Listings[name]
+		if v.Latency > listing.Latency {
+			listing.Latency = v.Latency
 		}
+		metrics.ListingDuration.Observe(v.Latency.Seconds(), name)
 
-		_ = v.Response.Body.Close()
-		err = json.Unmarshal(functionBytes, &function)
+		functions, err := readFunctionList(v)
 		if err != nil {
-			return nil, fmt.Errorf("error unmarshalling response for %s. %v", providers[v.Index], err)
+			log.Errorf("error fetching function list for %s. %v", name, err)
+			metrics.ListingErrors.Inc(name)
+			statusCode := 0
+			if v.Response != nil {
+				statusCode = v.Response.StatusCode
+			}
+			listing.fail(statusCode, err)
+			continue
+		}
+
+		if listing.OK {
+			listing.StatusCode = v.Response.StatusCode
 		}
 
-		serviceResult.Providers[providers[v.Index]] = append(serviceResult.Providers[providers[v.Index]], function...)
+		for _, f := range functions {
+			f.Namespace = requestListings[v.Index].namespace
+		}
+
+		listing.Functions += len(functions)
+		serviceResult.Providers[name] = append(serviceResult.Providers[name], functions...)
 	}
 
 	return serviceResult, nil
 }
 
+func readFunctionList(v Result) ([]*types.FunctionStatus, error) {
+	if v.Err != nil {
+		return nil, v.Err
+	}
+
+	defer v.Response.Body.Close()
+	if v.Response.StatusCode > 399 {
+		return nil, fmt.Errorf("unexpected status code %d", v.Response.StatusCode)
+	}
+
+	functionBytes, err := ioutil.ReadAll(v.Response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response. %v", err)
+	}
+
+	var functions []*types.FunctionStatus
+	if err := json.Unmarshal(functionBytes, &functions); err != nil {
+		return nil, fmt.Errorf("error unmarshalling response. %v", err)
+	}
+
+	return functions, nil
+}
+
 func createToRequest(request *types.FunctionDeployment) *types.FunctionStatus {
 	return &types.FunctionStatus{
 		Name:              request.Service,
+		Namespace:         request.Namespace,
 		Annotations:       request.Annotations,
 		EnvProcess:        request.EnvProcess,
 		Image:             request.Image,
@@ -71,6 +220,7 @@ func createToRequest(request *types.FunctionDeployment) *types.FunctionStatus {
 func requestToCreate(f *types.FunctionStatus) *types.FunctionDeployment {
 	return &types.FunctionDeployment{
 		Service:     f.Name,
+		Namespace:   f.Namespace,
 		Annotations: f.Annotations,
 		Labels:      f.Labels,
 		Image:       f.Image,
@@ -4,29 +4,31 @@
 package routing
 
 import (
+	"net/http"
 	"testing"
 
 	acc "github.com/openfaas-incubator/faas-federation/testing"
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
 )
 
 // Test_readServices requires `make up` and `cd examples && faas-cli up`
 func Test_readServices(t *testing.T) {
 	acc.PreCheckAcc(t)
 	type args struct {
-		providers []string
+		providers []Provider
 	}
 	tests := []struct {
 		name string
 		args args
 	}{
-		{name: "list", args: args{providers: []string{
-			"http://faas-provider-a:8082",
-			"http://faas-provider-b:8083",
+		{name: "list", args: args{providers: []Provider{
+			{Name: "faas-provider-a", URL: "http://faas-provider-a:8082"},
+			{Name: "faas-provider-b", URL: "http://faas-provider-b:8083"},
 		}}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := ReadServices(tt.args.providers)
+			got, err := ReadServices(http.DefaultClient, tt.args.providers)
 			if err != nil {
 				t.Error(err)
 			}
@@ -37,3 +39,67 @@ func Test_readServices(t *testing.T) {
 		})
 	}
 }
+
+func Test_ReadServices_PartialFailure(t *testing.T) {
+	failing := providertest.New(http.StatusInternalServerError)
+	defer failing.Close()
+	unreachable := providertest.New(http.StatusNotFound)
+	unreachable.Close()
+	healthy := providertest.New(http.StatusOK)
+	healthy.Handle("/system/functions", http.StatusOK, providertest.Functions("echo"))
+	defer healthy.Close()
+
+	got, err := ReadServices(http.DefaultClient, []Provider{
+		{Name: "a-failing", URL: failing.URL},
+		{Name: "b-unreachable", URL: unreachable.URL},
+		{Name: "c-healthy", URL: healthy.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Providers["c-healthy"]) != 1 {
+		t.Errorf("want the functions of the providers after a failure, got %v", got.Providers)
+	}
+
+	if failed := got.Failed(); len(failed) != 2 || failed[0] != "a-failing" || failed[1] != "b-unreachable" {
+		t.Errorf("want a-failing and b-unreachable failed, got %v", failed)
+	}
+
+	if l := got.Listings["a-failing"]; l.StatusCode != http.StatusInternalServerError || len(l.Error) == 0 {
+		t.Errorf("want the status code and error of a-failing, got %+v", l)
+	}
+
+	if l := got.Listings["c-healthy"]; !l.OK || l.StatusCode != http.StatusOK || l.Functions != 1 {
+		t.Errorf("want c-healthy listed, got %+v", l)
+	}
+}
+
+func Test_ReloadCache_KeepsFailedProviders(t *testing.T) {
+	provider := providertest.New(http.StatusOK)
+	provider.Handle("/system/functions", http.StatusOK, providertest.Functions("echo"))
+	defer provider.Close()
+
+	d, err := NewDefaultProviderRouting([]Provider{{Name: "a", URL: provider.URL, Default: true}}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.ReloadCache(); err != nil {
+		t.Fatal(err)
+	}
+
+	provider.Handle("/system/functions", http.StatusBadGateway, "")
+	if err := d.ReloadCache(); err == nil {
+		t.Error("want an error when no provider could be listed")
+	}
+
+	if _, ok := d.GetFunction("echo"); !ok {
+		t.Error("want echo kept in the cache while its provider can not be listed")
+	}
+
+	stats := d.GetProviderStats("a")
+	if stats.LastListing == nil || stats.LastListing.OK || stats.LastListing.StatusCode != http.StatusBadGateway {
+		t.Errorf("want the failed listing in the provider stats, got %+v", stats.LastListing)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	types "github.com/openfaas/faas-provider/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// cacheSnapshotVersion is the format of the cache snapshot, a snapshot of another version is
+// not restored
+const cacheSnapshotVersion = 1
+
+// CacheSnapshot is the function cache as written to disk, so that functions can be routed
+// from the last known state before the providers have been listed
+type CacheSnapshot struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Providers in the federation when the snapshot was taken
+	Providers []string `json:"providers"`
+	// Functions in the cache
+	Functions []*types.FunctionDeployment `json:"functions"`
+	// Weights held for the functions, keyed by function name
+	Weights map[string][]ProviderWeight `json:"weights,omitempty"`
+}
+
+// CacheSnapshot returns a copy of the function cache
+func (d *defaultProviderRouting) CacheSnapshot() *CacheSnapshot {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	s := &CacheSnapshot{
+		Version: cacheSnapshotVersion,
+		Created: time.Now().UTC(),
+		Weights: make(map[string][]ProviderWeight, len(d.weights)),
+	}
+
+	for name := range d.providers {
+		s.Providers = append(s.Providers, name)
+	}
+	sort.Strings(s.Providers)
+
+	var keys []string
+	for key := range d.cache {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s.Functions = append(s.Functions, d.cache[key])
+		if w, ok := d.weights[key]; ok {
+			s.Weights[key] = w
+		}
+	}
+
+	return s
+}
+
+// RestoreCacheSnapshot adds the functions of a snapshot which are not already cached, and
+// returns how many were restored. A function placed on a provider which was in the
+// federation when the snapshot was taken, but is no longer, is orphaned
+func (d *defaultProviderRouting) RestoreCacheSnapshot(s *CacheSnapshot) (int, error) {
+	if s.Version != cacheSnapshotVersion {
+		return 0, fmt.Errorf("cache snapshot version %d is not supported, want %d", s.Version, cacheSnapshotVersion)
+	}
+
+	var changes []cacheChange
+	defer func() {
+		d.publishChanges(changes)
+	}()
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.weights == nil {
+		d.weights = make(map[string][]ProviderWeight)
+	}
+
+	restored := 0
+	for _, f := range s.Functions {
+		if f == nil || len(f.Service) == 0 {
+			continue
+		}
+
+		key := functionKey(f)
+		if _, ok := d.cache[key]; ok {
+			continue
+		}
+
+		d.cache[key] = f
+		changes = append(changes, cacheChange{EventFunctionAdded, key, f})
+		if w, ok := s.Weights[key]; ok {
+			d.weights[key] = w
+		}
+		restored++
+	}
+
+	previous := map[string]*url.URL{}
+	for _, name := range s.Providers {
+		previous[name] = nil
+	}
+	d.flagOrphans(previous)
+
+	return restored, nil
+}
+
+// WriteCacheSnapshot writes the snapshot as JSON to a temporary file which is renamed to
+// path, so that a snapshot which is read is never partially written
+func WriteCacheSnapshot(path string, s *CacheSnapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadCacheSnapshot reads the snapshot written to path, nil is returned when there is none
+func ReadCacheSnapshot(path string) (*CacheSnapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &CacheSnapshot{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid cache snapshot %s. %v", path, err)
+	}
+
+	return s, nil
+}
+
+// LoadCacheSnapshot restores the cache of providerLookup from the snapshot written to path,
+// it returns the number of functions restored
+func LoadCacheSnapshot(providerLookup FunctionCache, path string) (int, error) {
+	s, err := ReadCacheSnapshot(path)
+	if err != nil || s == nil {
+		return 0, err
+	}
+
+	restored, err := providerLookup.RestoreCacheSnapshot(s)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Infof("restored %d functions from the cache snapshot %s taken at %s", restored, path, s.Created.Format(time.RFC3339))
+	return restored, nil
+}
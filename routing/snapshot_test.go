@@ -0,0 +1,100 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+func Test_CacheSnapshot_RoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshotPath := path.Join(dir, "cache.json")
+
+	if s, err := ReadCacheSnapshot(snapshotPath); s != nil || err != nil {
+		t.Fatalf("want no snapshot before one is written, got %v with error %v", s, err)
+	}
+
+	from, err := NewDefaultProviderRouting([]Provider{
+		{Name: "a", URL: "http://faas-provider-a:8080", Default: true},
+		{Name: "b", URL: "http://faas-provider-b:8080"},
+	}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	from.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationWeightsAnnotation: "a=90,b=10"}})
+	from.AddFunction(&types.FunctionDeployment{Service: "cat", Namespace: "team-a", Annotations: &map[string]string{federationProviderNameConstraint: "b"}})
+
+	if err := WriteCacheSnapshot(snapshotPath, from.CacheSnapshot()); err != nil {
+		t.Fatal(err)
+	}
+
+	to, err := NewDefaultProviderRouting([]Provider{{Name: "a", URL: "http://faas-provider-a:8080", Default: true}}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := LoadCacheSnapshot(to, snapshotPath)
+	if err != nil || restored != 2 {
+		t.Fatalf("want 2 functions restored, got %d with error %v", restored, err)
+	}
+
+	if weights, ok := to.GetFunctionWeights("echo"); !ok || len(weights) != 2 || weights[0].Weight != 90 {
+		t.Errorf("want the weights of echo restored, got %v", weights)
+	}
+
+	if got := to.GetOrphanedFunctions(); got["cat.team-a"] != "b" {
+		t.Errorf("want cat.team-a orphaned by the removal of provider b, got %v", got)
+	}
+
+	if _, err := to.RestoreCacheSnapshot(&CacheSnapshot{Version: cacheSnapshotVersion + 1}); err == nil {
+		t.Error("want an error restoring a snapshot of another version")
+	}
+}
+
+func Test_CacheReconciler_WritesSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshotPath := path.Join(dir, "cache.json")
+
+	provider := providertest.New(http.StatusOK)
+	provider.Handle("/system/functions", http.StatusOK, providertest.Functions("echo"))
+	defer provider.Close()
+
+	d, err := NewDefaultProviderRouting([]Provider{{Name: "a", URL: provider.URL, Default: true}}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewCacheReconciler(d, snapshotPath).Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ReadCacheSnapshot(snapshotPath)
+	if err != nil || s == nil {
+		t.Fatalf("want a snapshot after reconciling, got error %v", err)
+	}
+
+	if len(s.Functions) != 1 || s.Functions[0].Service != "echo" {
+		t.Errorf("want echo in the snapshot, got %v", s.Functions)
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("want only the snapshot left in its directory, got %d files", len(files))
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tlsCheckInterval is the minimum time between checks of the certificate files for changes
+const tlsCheckInterval = time.Second
+
+// TLSConfig of the connections to a provider, the files are reloaded when they change
+type TLSConfig struct {
+	// CAFile is a PEM bundle used to verify the provider, the system roots are used when empty
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile and KeyFile are the PEM client certificate and key presented to the provider
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// ServerName overrides the host name used to verify the provider's certificate
+	ServerName string `json:"serverName,omitempty"`
+}
+
+func (c *TLSConfig) files() []string {
+	var result []string
+	for _, f := range []string{c.CAFile, c.CertFile, c.KeyFile} {
+		if len(f) > 0 {
+			result = append(result, f)
+		}
+	}
+
+	return result
+}
+
+func (c *TLSConfig) load() (*tls.Config, error) {
+	if (len(c.CertFile) == 0) != (len(c.KeyFile) == 0) {
+		return nil, fmt.Errorf("both a client certificate and key are required")
+	}
+
+	config := &tls.Config{ServerName: c.ServerName}
+	if len(c.CAFile) > 0 {
+		ca, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle %s, error: %v", c.CAFile, err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", c.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if len(c.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate %s, error: %v", c.CertFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// equal compares two possibly nil TLS configs
+func (c *TLSConfig) equal(other *TLSConfig) bool {
+	if c == nil || other == nil {
+		return c == other
+	}
+
+	return *c == *other
+}
+
+// providerTransport is the http.RoundTripper used for all calls to a provider, a new
+// transport is built when the certificate files of the provider change
+type providerTransport struct {
+	config    *TLSConfig
+	transport *http.Transport
+	// watch of the certificate files, nil without a TLS config
+	watch *FileWatch
+	lock  sync.Mutex
+}
+
+// newProviderTransport creates the transport of a provider, the certificate files are
+// loaded straight away so that an invalid TLS config is reported
+func newProviderTransport(config *TLSConfig) (*providerTransport, error) {
+	t := &providerTransport{config: config}
+	if config == nil {
+		t.transport = newTransport(nil)
+		return t, nil
+	}
+
+	tlsConfig, err := config.load()
+	if err != nil {
+		return nil, err
+	}
+
+	t.transport = newTransport(tlsConfig)
+	t.watch = NewFileWatch(config.files()...)
+	return t, nil
+}
+
+func newTransport(tlsConfig *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// RoundTrip sends the request using the current certificates of the provider
+func (t *providerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.current().RoundTrip(req)
+}
+
+// current returns the transport, rebuilding it when the certificate files changed. When
+// the new files can not be loaded, for instance while they are being written, the previous
+// transport is kept
+func (t *providerTransport) current() *http.Transport {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.watch == nil {
+		return t.transport
+	}
+
+	t.watch.Reload(func() error {
+		tlsConfig, err := t.config.load()
+		if err != nil {
+			log.Errorf("unable to reload certificates, keeping the previous ones. %v", err)
+			return err
+		}
+
+		log.Infof("reloaded certificates %v", t.config.files())
+		previous := t.transport
+		t.transport = newTransport(tlsConfig)
+		previous.CloseIdleConnections()
+		return nil
+	})
+
+	return t.transport
+}
+
+// closeIdleConnections is called when the provider is removed or its TLS config changes
+func (t *providerTransport) closeIdleConnections() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.transport.CloseIdleConnections()
+}
+
+// FileWatch reloads a set of files when their modification time changes, the files are
+// checked at most once every tlsCheckInterval. Callers must serialise the calls to Reload
+type FileWatch struct {
+	files    []string
+	modified time.Time
+	checked  time.Time
+}
+
+// NewFileWatch creates a FileWatch for files which have just been loaded
+func NewFileWatch(files ...string) *FileWatch {
+	return &FileWatch{
+		files:    files,
+		modified: lastModified(files),
+		checked:  time.Now(),
+	}
+}
+
+// Reload calls load when the files were modified since they were last loaded, a load which
+// fails, for instance while the files are being written, is retried at the next check
+func (w *FileWatch) Reload(load func() error) {
+	if time.Since(w.checked) < tlsCheckInterval {
+		return
+	}
+	w.checked = time.Now()
+
+	modified := lastModified(w.files)
+	if !modified.After(w.modified) {
+		return
+	}
+
+	if err := load(); err != nil {
+		return
+	}
+	w.modified = modified
+}
+
+func lastModified(files []string) time.Time {
+	var result time.Time
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(result) {
+			result = info.ModTime()
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,153 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM certificate and key signed by the CA
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func Test_Transport_MutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+
+	serverCert, serverKey := ca.issue(t, "faas-provider-a", x509.ExtKeyUsageServerAuth)
+	keyPair, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients := x509.NewCertPool()
+	clients.AddCert(ca.cert)
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	s.TLS = &tls.Config{
+		Certificates: []tls.Certificate{keyPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clients,
+	}
+	s.StartTLS()
+	defer s.Close()
+
+	clientCert, clientKey := ca.issue(t, "faas-federation", x509.ExtKeyUsageClientAuth)
+	files := map[string][]byte{"ca.pem": otherCA.pem, "client.pem": clientCert, "client-key.pem": clientKey}
+	for name, data := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tlsConfig := &TLSConfig{
+		CAFile:     path.Join(dir, "ca.pem"),
+		CertFile:   path.Join(dir, "client.pem"),
+		KeyFile:    path.Join(dir, "client-key.pem"),
+		ServerName: "faas-provider-a",
+	}
+
+	d, err := NewDefaultProviderRouting([]Provider{{Name: "faas-provider-a", URL: s.URL, TLS: tlsConfig, Default: true}}, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: d.GetTransport()}
+	if _, err := client.Get(s.URL); err == nil {
+		t.Fatal("want provider certificate to be rejected by the wrong CA")
+	}
+
+	// rotate the CA bundle on disk
+	if err := ioutil.WriteFile(tlsConfig.CAFile, ca.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(tlsConfig.CAFile, future, future)
+	d.(*defaultProviderRouting).transports["faas-provider-a"].watch.checked = time.Time{}
+
+	res, err := client.Get(s.URL)
+	if err != nil {
+		t.Fatalf("want request to succeed after the CA was rotated, got %v", err)
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if string(body) != "faas-federation" {
+		t.Errorf("want client certificate faas-federation presented, got %q", string(body))
+	}
+
+	_, err = NewDefaultProviderRouting([]Provider{{Name: "faas-provider-a", URL: s.URL, TLS: &TLSConfig{CertFile: tlsConfig.CertFile}, Default: true}}, Config{})
+	if err == nil {
+		t.Error("want error for a client certificate without a key")
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ProviderWeight is the relative share of invocations a provider receives for a function
+type ProviderWeight struct {
+	Provider string `json:"provider"`
+	Weight   int    `json:"weight"`
+}
+
+// parseWeights parses a comma separated list of provider weights such as `faas-netes=90,faas-lambda=10`
+func parseWeights(v string) ([]ProviderWeight, error) {
+	var result []ProviderWeight
+	total := 0
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 {
+			return nil, fmt.Errorf("invalid weight %q, expected provider=weight", part)
+		}
+
+		weight, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight %q, weight must be a positive integer", part)
+		}
+
+		total += weight
+		result = append(result, ProviderWeight{Provider: strings.TrimSpace(kv[0]), Weight: weight})
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("weights %q must add up to more than zero", v)
+	}
+
+	return result, nil
+}
+
+// pickWeighted returns the provider selected by a random number drawn from intn
+// in proportion to its weight, or false when all weights are zero
+func pickWeighted(weights []ProviderWeight, intn func(n int) int) (string, bool) {
+	total := 0
+	for _, w := range weights {
+		total += w.Weight
+	}
+
+	if total == 0 {
+		return "", false
+	}
+
+	n := intn(total)
+	for _, w := range weights {
+		if n < w.Weight {
+			return w.Provider, true
+		}
+		n -= w.Weight
+	}
+
+	return "", false
+}
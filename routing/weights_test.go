@@ -0,0 +1,170 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package routing
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/openfaas-incubator/faas-federation/testing/providertest"
+	types "github.com/openfaas/faas-provider/types"
+)
+
+func Test_parseWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []ProviderWeight
+		wantErr bool
+	}{
+		{name: "two providers", value: "faas-netes=90, faas-lambda=10", want: []ProviderWeight{{"faas-netes", 90}, {"faas-lambda", 10}}},
+		{name: "zero weight is allowed", value: "faas-netes=100,faas-lambda=0", want: []ProviderWeight{{"faas-netes", 100}, {"faas-lambda", 0}}},
+		{name: "all zero weights", value: "faas-netes=0", wantErr: true},
+		{name: "negative weight", value: "faas-netes=-1", wantErr: true},
+		{name: "missing weight", value: "faas-netes", wantErr: true},
+		{name: "not a number", value: "faas-netes=ninety", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseWeights(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWeights() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("want %v, got %v", tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_ResolveWeighted_Distribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights string
+		health  map[string]ProviderHealth
+		want    map[string]float64
+	}{
+		{
+			name:    "90/10 split",
+			weights: "faas-provider-a=90,faas-provider-b=10",
+			want:    map[string]float64{"faas-provider-a:8080": 0.9, "faas-provider-b:8080": 0.1},
+		},
+		{
+			name:    "50/50 split",
+			weights: "faas-provider-a=50,faas-provider-b=50",
+			want:    map[string]float64{"faas-provider-a:8080": 0.5, "faas-provider-b:8080": 0.5},
+		},
+		{
+			name:    "three way split",
+			weights: "faas-provider-a=60,faas-provider-b=30,faas-provider-c=10",
+			want:    map[string]float64{"faas-provider-a:8080": 0.6, "faas-provider-b:8080": 0.3, "faas-provider-c:8080": 0.1},
+		},
+		{
+			name:    "provider which is down receives no traffic",
+			weights: "faas-provider-a=60,faas-provider-b=30,faas-provider-c=10",
+			health: map[string]ProviderHealth{
+				"faas-provider-a": {Name: "faas-provider-a", Status: ProviderStatusDown},
+			},
+			want: map[string]float64{"faas-provider-b:8080": 0.75, "faas-provider-c:8080": 0.25},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &defaultProviderRouting{
+				cache: map[string]*types.FunctionDeployment{},
+				providers: map[string]*url.URL{
+					"faas-provider-a": parseURL("http://faas-provider-a:8080"),
+					"faas-provider-b": parseURL("http://faas-provider-b:8080"),
+					"faas-provider-c": parseURL("http://faas-provider-c:8080"),
+				},
+				health:          tt.health,
+				defaultProvider: parseURL("http://faas-provider-a:8080"),
+				intn:            rand.New(rand.NewSource(1)).Intn,
+			}
+			d.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationWeightsAnnotation: tt.weights}})
+
+			const samples = 20000
+			counts := map[string]int{}
+			for i := 0; i < samples; i++ {
+				u, err := d.ResolveWeighted("echo")
+				if err != nil {
+					t.Fatal(err)
+				}
+				counts[u.Host]++
+			}
+
+			for host, share := range tt.want {
+				got := float64(counts[host]) / samples
+				if math.Abs(got-share) > 0.02 {
+					t.Errorf("want %s to receive %.2f of invocations, got %.3f", host, share, got)
+				}
+			}
+
+			for host := range counts {
+				if _, ok := tt.want[host]; !ok {
+					t.Errorf("want no invocations for %s, got %d", host, counts[host])
+				}
+			}
+		})
+	}
+}
+
+func Test_ReloadCache_RefreshesWeights(t *testing.T) {
+	tests := []struct {
+		name        string
+		listed      string
+		wantWeights []int
+	}{
+		{
+			name:        "listed weights replace the cached weights",
+			listed:      `[{"name": "echo", "annotations": {"com.openfaas.federation.gateway": "faas-provider-a", "com.openfaas.federation.weights": "faas-provider-a=90,faas-provider-b=10"}}]`,
+			wantWeights: []int{90, 10},
+		},
+		{
+			name:   "listed function without weights removes the cached weights",
+			listed: `[{"name": "echo", "annotations": {"com.openfaas.federation.gateway": "faas-provider-a"}}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := providertest.New(http.StatusOK)
+			provider.Handle("/system/functions", http.StatusOK, tt.listed)
+			defer provider.Close()
+
+			d, err := NewDefaultProviderRouting([]Provider{
+				{Name: "faas-provider-a", URL: provider.URL, Default: true},
+				{Name: "faas-provider-b", URL: "http://faas-provider-b:8080"},
+			}, Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			d.AddFunction(&types.FunctionDeployment{Service: "echo", Annotations: &map[string]string{federationWeightsAnnotation: "faas-provider-a=50,faas-provider-b=50"}})
+			if err := d.ReloadCache(); err != nil {
+				t.Fatal(err)
+			}
+
+			weights, ok := d.GetFunctionWeights("echo")
+			var got []int
+			for _, w := range weights {
+				got = append(got, w.Weight)
+			}
+			if ok != (len(tt.wantWeights) > 0) || fmt.Sprint(got) != fmt.Sprint(tt.wantWeights) {
+				t.Errorf("want weights %v, got %v", tt.wantWeights, weights)
+			}
+		})
+	}
+}
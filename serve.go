@@ -0,0 +1,163 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/openfaas-incubator/faas-federation/handlers"
+	"github.com/openfaas-incubator/faas-federation/routing"
+	bootstrap "github.com/openfaas/faas-provider"
+	"github.com/openfaas/faas-provider/auth"
+	bootTypes "github.com/openfaas/faas-provider/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// serve registers the handlers on bootstrap.Router() with the same routes as bootstrap.Serve
+// and serves them, over TLS when tlsCertFile and tlsKeyFile are set. When basic auth is
+// enabled every route apart from the function proxy and health endpoint requires it,
+// including routes added to bootstrap.Router() by the federation. When jwtAuth is given
+// it is used for every route instead. This function is blocking until done is closed, when
+// in-flight requests are given up to the write timeout to complete
+func serve(h *bootTypes.FaaSHandlers, config *bootTypes.FaaSConfig, tlsCertFile string, tlsKeyFile string, jwtAuth mux.MiddlewareFunc, done <-chan struct{}) error {
+	r := bootstrap.Router()
+
+	if jwtAuth != nil {
+		r.Use(jwtAuth)
+	} else if config.EnableBasicAuth {
+		reader := auth.ReadBasicAuthFromDisk{
+			SecretMountPath: config.SecretMountPath,
+		}
+
+		credentials, err := reader.Read()
+		if err != nil {
+			return fmt.Errorf("could not read basic auth credentials, error: %v", err)
+		}
+
+		r.Use(handlers.MakeBasicAuthMiddleware(credentials))
+	}
+
+	// System (auth) endpoints
+	r.HandleFunc("/system/functions", h.FunctionReader).Methods(http.MethodGet)
+	r.HandleFunc("/system/functions", h.DeployHandler).Methods(http.MethodPost)
+	r.HandleFunc("/system/functions", h.DeleteHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/system/functions", h.UpdateHandler).Methods(http.MethodPut)
+
+	r.HandleFunc("/system/function/{name:["+bootstrap.NameExpression+"]+}", h.ReplicaReader).Methods(http.MethodGet)
+	r.HandleFunc("/system/scale-function/{name:["+bootstrap.NameExpression+"]+}", h.ReplicaUpdater).Methods(http.MethodPost)
+	r.HandleFunc("/system/info", h.InfoHandler).Methods(http.MethodGet)
+
+	if h.SecretHandler != nil {
+		r.HandleFunc("/system/secrets", h.SecretHandler).Methods(http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete)
+	}
+	if h.LogHandler != nil {
+		r.HandleFunc("/system/logs", h.LogHandler).Methods(http.MethodGet)
+	}
+	if h.ListNamespaceHandler != nil {
+		r.HandleFunc("/system/namespaces", h.ListNamespaceHandler).Methods(http.MethodGet)
+	}
+
+	// Open endpoints
+	r.HandleFunc("/function/{name:["+bootstrap.NameExpression+"]+}", h.FunctionProxy)
+	r.HandleFunc("/function/{name:["+bootstrap.NameExpression+"]+}/", h.FunctionProxy)
+	r.HandleFunc("/function/{name:["+bootstrap.NameExpression+"]+}/{params:.*}", h.FunctionProxy)
+
+	if config.EnableHealth {
+		r.HandleFunc("/healthz", h.HealthHandler).Methods(http.MethodGet)
+	}
+
+	tcpPort := 8080
+	if config.TCPPort != nil {
+		tcpPort = *config.TCPPort
+	}
+
+	s := &http.Server{
+		Addr:           fmt.Sprintf(":%d", tcpPort),
+		ReadTimeout:    config.ReadTimeout,
+		WriteTimeout:   config.WriteTimeout,
+		MaxHeaderBytes: http.DefaultMaxHeaderBytes,
+		Handler:        r,
+	}
+
+	go func() {
+		<-done
+		ctx, cancel := context.WithTimeout(context.Background(), config.WriteTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			log.Errorf("error shutting down the server. %v", err)
+		}
+	}()
+
+	var err error
+	if len(tlsCertFile) == 0 && len(tlsKeyFile) == 0 {
+		err = s.ListenAndServe()
+	} else {
+		certificate, certErr := newCertificateReloader(tlsCertFile, tlsKeyFile)
+		if certErr != nil {
+			return certErr
+		}
+
+		s.TLSConfig = &tls.Config{GetCertificate: certificate.GetCertificate}
+		err = s.ListenAndServeTLS("", "")
+	}
+
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+// certificateReloader serves a certificate which is reloaded from disk when it changes
+type certificateReloader struct {
+	certFile    string
+	keyFile     string
+	certificate *tls.Certificate
+	watch       *routing.FileWatch
+	lock        sync.Mutex
+}
+
+func newCertificateReloader(certFile string, keyFile string) (*certificateReloader, error) {
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return nil, fmt.Errorf("both tls_cert_file and tls_key_file are required to serve TLS")
+	}
+
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load certificate %s, error: %v", certFile, err)
+	}
+
+	return &certificateReloader{
+		certFile:    certFile,
+		keyFile:     keyFile,
+		certificate: &certificate,
+		watch:       routing.NewFileWatch(certFile, keyFile),
+	}, nil
+}
+
+// GetCertificate checks the files for changes at most once a second, the previous
+// certificate is kept when the new files can not be loaded
+func (c *certificateReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.watch.Reload(func() error {
+		certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err != nil {
+			log.Errorf("unable to reload certificate %s, keeping the previous one. %v", c.certFile, err)
+			return err
+		}
+
+		log.Infof("reloaded certificate %s", c.certFile)
+		c.certificate = &certificate
+		return nil
+	})
+
+	return c.certificate, nil
+}
@@ -0,0 +1,147 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+// Package providertest runs fake OpenFaaS providers for the tests of the federation
+package providertest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider is a fake OpenFaaS provider which records the requests it receives. A request
+// for a route is answered by the route's handler, any other request with the default status
+type Provider struct {
+	*httptest.Server
+	status   int
+	routes   map[string]http.HandlerFunc
+	delay    time.Duration
+	user     string
+	password string
+	received []string
+	lock     sync.Mutex
+}
+
+// New starts a Provider which answers requests without a route with status
+func New(status int) *Provider {
+	p := &Provider{status: status, routes: map[string]http.HandlerFunc{}}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serve))
+
+	return p
+}
+
+func (p *Provider) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	p.lock.Lock()
+	p.received = append(p.received, strings.TrimSpace(r.Method+" "+r.URL.Path+" "+string(body)))
+	handler, ok := p.routes[r.Method+" "+r.URL.Path]
+	if !ok {
+		handler, ok = p.routes[r.URL.Path]
+	}
+	status, delay, user, password := p.status, p.delay, p.user, p.password
+	p.lock.Unlock()
+
+	time.Sleep(delay)
+
+	if len(user) > 0 {
+		if u, pw, found := r.BasicAuth(); !found || u != user || pw != password {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if ok {
+		handler(w, r)
+		return
+	}
+
+	w.WriteHeader(status)
+}
+
+// Handle answers the requests for route with status and body. A route is a path such as
+// /system/functions, optionally preceded by a method such as "GET /system/secrets"
+func (p *Provider) Handle(route string, status int, body string) {
+	p.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	})
+}
+
+// HandleFunc answers the requests for route with handler
+func (p *Provider) HandleFunc(route string, handler http.HandlerFunc) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.routes[route] = handler
+}
+
+// SetStatus replaces the status of requests without a route
+func (p *Provider) SetStatus(status int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.status = status
+}
+
+// SetDelay delays each response
+func (p *Provider) SetDelay(delay time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.delay = delay
+}
+
+// RequireBasicAuth answers requests without the credentials with a 401
+func (p *Provider) RequireBasicAuth(user string, password string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.user, p.password = user, password
+}
+
+// Received returns each request received so far as its method, path and body, such as
+// "POST /system/functions {...}"
+func (p *Provider) Received() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return append([]string{}, p.received...)
+}
+
+// Count returns the number of requests received for route
+func (p *Provider) Count(route string) int {
+	count := 0
+	for _, v := range p.Received() {
+		method := strings.SplitN(v, " ", 2)[0]
+		path := strings.SplitN(v, " ", 3)[1]
+		if route == path || route == method+" "+path {
+			count++
+		}
+	}
+
+	return count
+}
+
+// HostURL returns the URL of the provider with host in place of 127.0.0.1, so that
+// providers on the same loopback address can be told apart by name
+func (p *Provider) HostURL(host string) string {
+	return strings.Replace(p.URL, "127.0.0.1", host, 1)
+}
+
+// Functions returns the listing of functions with the given names, as served by
+// /system/functions
+func Functions(names ...string) string {
+	functions := []map[string]string{}
+	for _, name := range names {
+		functions = append(functions, map[string]string{"name": name})
+	}
+
+	functionBytes, _ := json.Marshal(functions)
+	return string(functionBytes)
+}
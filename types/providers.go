@@ -0,0 +1,224 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ProviderConfig describes a provider which is part of the federation
+type ProviderConfig struct {
+	// Name of the provider used by the com.openfaas.federation.gateway annotation
+	Name string `yaml:"name" json:"name"`
+	// URL of the provider's API
+	URL string `yaml:"url" json:"url"`
+	// Labels matched by the com.openfaas.federation.selector annotation
+	Labels map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	// Credentials used when calling the provider
+	Credentials *ProviderCredentials `yaml:"credentials,omitempty" json:"credentials,omitempty"`
+	// TLS of the connections to the provider
+	TLS *ProviderTLS `yaml:"tls,omitempty" json:"tls,omitempty"`
+	// Timeout for invocations proxied to the provider and for calls to its /system endpoints, 0 uses read_timeout
+	Timeout Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	// Default provider used when a function has no placement constraints
+	Default bool `yaml:"default,omitempty" json:"default,omitempty"`
+	// Namespaces placed on the provider when a function has no placement constraints
+	Namespaces []string `yaml:"namespaces,omitempty" json:"namespaces,omitempty"`
+}
+
+// ProviderCredentials references the credentials of a provider on disk
+type ProviderCredentials struct {
+	// SecretMountPath containing basic-auth-user and basic-auth-password
+	SecretMountPath string `yaml:"secretMountPath,omitempty" json:"secretMountPath,omitempty"`
+	// TokenFile containing a bearer token
+	TokenFile string `yaml:"tokenFile,omitempty" json:"tokenFile,omitempty"`
+}
+
+// ProviderTLS references the certificates used to connect to a provider, they are reloaded
+// from disk when they change
+type ProviderTLS struct {
+	// CAFile is a PEM bundle used to verify the provider
+	CAFile string `yaml:"caFile,omitempty" json:"caFile,omitempty"`
+	// CertFile and KeyFile are the PEM client certificate and key for mutual TLS
+	CertFile string `yaml:"certFile,omitempty" json:"certFile,omitempty"`
+	KeyFile  string `yaml:"keyFile,omitempty" json:"keyFile,omitempty"`
+	// ServerName used to verify the provider's certificate
+	ServerName string `yaml:"serverName,omitempty" json:"serverName,omitempty"`
+}
+
+// ProvidersFile is the format of the file given by the providers_config env-var
+type ProvidersFile struct {
+	Providers []ProviderConfig `yaml:"providers" json:"providers"`
+}
+
+// Duration accepts either a Go duration such as "30s" or a number of seconds
+type Duration time.Duration
+
+// UnmarshalYAML parses a duration in YAML
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var val string
+	if err := unmarshal(&val); err != nil {
+		return err
+	}
+
+	return d.parse(val)
+}
+
+// UnmarshalJSON parses a duration in JSON
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var val interface{}
+	if err := json.Unmarshal(data, &val); err != nil {
+		return err
+	}
+
+	return d.parse(strings.Trim(fmt.Sprint(val), " "))
+}
+
+// MarshalJSON writes the duration in the form "30s"
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) parse(val string) error {
+	if len(val) == 0 {
+		*d = 0
+		return nil
+	}
+
+	if seconds, err := strconv.Atoi(val); err == nil && seconds >= 0 {
+		*d = Duration(time.Duration(seconds) * time.Second)
+		return nil
+	}
+
+	duration, err := time.ParseDuration(val)
+	if err != nil || duration < 0 {
+		return fmt.Errorf("invalid duration %q", val)
+	}
+
+	*d = Duration(duration)
+	return nil
+}
+
+// ReadProvidersFile reads a YAML or JSON file listing the providers of the federation
+func ReadProvidersFile(path string) ([]ProviderConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read providers config %s, error: %v", path, err)
+	}
+
+	return ParseProviders(data)
+}
+
+// ParseProviders parses and validates a YAML or JSON providers config
+func ParseProviders(data []byte) ([]ProviderConfig, error) {
+	file := ProvidersFile{}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("unable to parse providers config, error: %v", err)
+	}
+
+	if err := ValidateProviders(file.Providers); err != nil {
+		return nil, err
+	}
+
+	return file.Providers, nil
+}
+
+// ValidateProviders checks each provider has a unique name and a valid URL, that each
+// namespace is mapped to a single provider and that exactly one provider is the default
+func ValidateProviders(providers []ProviderConfig) error {
+	if len(providers) == 0 {
+		return fmt.Errorf("no providers given")
+	}
+
+	names := map[string]bool{}
+	namespaces := map[string]string{}
+	var defaults []string
+	for i, p := range providers {
+		if len(p.Name) == 0 {
+			return fmt.Errorf("provider %d has no name", i)
+		}
+
+		if names[p.Name] {
+			return fmt.Errorf("provider %s is listed more than once", p.Name)
+		}
+		names[p.Name] = true
+
+		u, err := url.Parse(p.URL)
+		if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			return fmt.Errorf("provider %s has an invalid URL %q, expected a URL such as http://gateway:8080", p.Name, p.URL)
+		}
+
+		if p.TLS != nil && (len(p.TLS.CertFile) == 0) != (len(p.TLS.KeyFile) == 0) {
+			return fmt.Errorf("provider %s needs both tls.certFile and tls.keyFile for mutual TLS", p.Name)
+		}
+
+		for _, ns := range p.Namespaces {
+			if other, ok := namespaces[ns]; ok {
+				return fmt.Errorf("namespace %s is mapped to providers %s and %s", ns, other, p.Name)
+			}
+			namespaces[ns] = p.Name
+		}
+
+		if p.Default {
+			defaults = append(defaults, p.Name)
+		}
+	}
+
+	if len(defaults) == 0 {
+		return fmt.Errorf("no default provider given")
+	}
+
+	if len(defaults) > 1 {
+		return fmt.Errorf("only one default provider is allowed, found: %s", strings.Join(defaults, ", "))
+	}
+
+	return nil
+}
+
+// providersFromEnv builds the providers from the comma separated providers env-var, each
+// provider is named by the host name of its URL
+func providersFromEnv(providerURLs string, defaultProvider string, labels map[string]map[string]string) []ProviderConfig {
+	var result []ProviderConfig
+	foundDefault := false
+	for _, v := range strings.Split(providerURLs, ",") {
+		v = strings.Trim(v, " ")
+		if len(v) == 0 {
+			continue
+		}
+
+		p := ProviderConfig{Name: hostNameWithoutPort(v), URL: v}
+		if v == defaultProvider || p.Name == hostNameWithoutPort(defaultProvider) {
+			p.Default = !foundDefault
+			foundDefault = true
+		}
+		result = append(result, p)
+	}
+
+	if !foundDefault && len(defaultProvider) > 0 {
+		result = append(result, ProviderConfig{Name: hostNameWithoutPort(defaultProvider), URL: defaultProvider, Default: true})
+	}
+
+	for i := range result {
+		result[i].Labels = labels[result[i].Name]
+	}
+
+	return result
+}
+
+func hostNameWithoutPort(v string) string {
+	u, err := url.Parse(v)
+	if err != nil || len(u.Host) == 0 {
+		return v
+	}
+
+	return strings.Split(u.Host, ":")[0]
+}
@@ -0,0 +1,187 @@
+// Copyright (c) OpenFaaS Author(s) 2019. All rights reserved.
+// Licensed under the MIT license. See LICENSE file in the project root for full license information.
+
+package types
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testEnv map[string]string
+
+func (e testEnv) Getenv(key string) string {
+	return e[key]
+}
+
+func Test_ParseProviders(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    []ProviderConfig
+		wantErr string
+	}{
+		{
+			name: "yaml",
+			config: `providers:
+- name: faas-netes
+  url: http://faas-netes:8080
+  default: true
+  timeout: 30s
+  labels:
+    region: eu-west
+- name: faas-lambda
+  url: https://faas-lambda:8080
+  timeout: 10
+  credentials:
+    secretMountPath: /var/secrets/faas-lambda
+`,
+			want: []ProviderConfig{
+				{Name: "faas-netes", URL: "http://faas-netes:8080", Default: true, Timeout: Duration(30 * time.Second), Labels: map[string]string{"region": "eu-west"}},
+				{Name: "faas-lambda", URL: "https://faas-lambda:8080", Timeout: Duration(10 * time.Second), Credentials: &ProviderCredentials{SecretMountPath: "/var/secrets/faas-lambda"}},
+			},
+		},
+		{
+			name:   "json",
+			config: `{"providers": [{"name": "faas-netes", "url": "http://faas-netes:8080", "default": true, "credentials": {"tokenFile": "/var/secrets/token"}}]}`,
+			want: []ProviderConfig{
+				{Name: "faas-netes", URL: "http://faas-netes:8080", Default: true, Credentials: &ProviderCredentials{TokenFile: "/var/secrets/token"}},
+			},
+		},
+		{
+			name:    "no providers",
+			config:  `providers: []`,
+			wantErr: "no providers given",
+		},
+		{
+			name:    "unknown field",
+			config:  "providers:\n- name: a\n  url: http://a:8080\n  default: true\n  weight: 1\n",
+			wantErr: "unable to parse providers config",
+		},
+		{
+			name:    "missing name",
+			config:  "providers:\n- url: http://a:8080\n  default: true\n",
+			wantErr: "provider 0 has no name",
+		},
+		{
+			name:    "duplicate name",
+			config:  "providers:\n- name: a\n  url: http://a:8080\n  default: true\n- name: a\n  url: http://b:8080\n",
+			wantErr: "provider a is listed more than once",
+		},
+		{
+			name:    "invalid URL",
+			config:  "providers:\n- name: a\n  url: faas-netes\n  default: true\n",
+			wantErr: "provider a has an invalid URL",
+		},
+		{
+			name:    "invalid timeout",
+			config:  "providers:\n- name: a\n  url: http://a:8080\n  default: true\n  timeout: soon\n",
+			wantErr: `invalid duration "soon"`,
+		},
+		{
+			name:   "namespaces",
+			config: "providers:\n- name: a\n  url: http://a:8080\n  default: true\n  namespaces: [team-a, team-b]\n",
+			want:   []ProviderConfig{{Name: "a", URL: "http://a:8080", Default: true, Namespaces: []string{"team-a", "team-b"}}},
+		},
+		{
+			name:    "namespace mapped twice",
+			config:  "providers:\n- name: a\n  url: http://a:8080\n  default: true\n  namespaces: [team-a]\n- name: b\n  url: http://b:8080\n  namespaces: [team-a]\n",
+			wantErr: "namespace team-a is mapped to providers a and b",
+		},
+		{
+			name:    "no default",
+			config:  "providers:\n- name: a\n  url: http://a:8080\n",
+			wantErr: "no default provider given",
+		},
+		{
+			name:    "several defaults",
+			config:  "providers:\n- name: a\n  url: http://a:8080\n  default: true\n- name: b\n  url: http://b:8080\n  default: true\n",
+			wantErr: "only one default provider is allowed, found: a, b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseProviders([]byte(tt.config))
+			if len(tt.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseProviders() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseProviders() unexpected error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseProviders() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Read_ProvidersFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     testEnv
+		want    []ProviderConfig
+		wantErr string
+	}{
+		{
+			name: "default in list",
+			env: testEnv{
+				"providers":        "http://faas-netes:8080, http://faas-lambda:8081",
+				"default_provider": "http://faas-netes:8080",
+				"provider_labels":  "faas-lambda:kind=lambda",
+			},
+			want: []ProviderConfig{
+				{Name: "faas-netes", URL: "http://faas-netes:8080", Default: true},
+				{Name: "faas-lambda", URL: "http://faas-lambda:8081", Labels: map[string]string{"kind": "lambda"}},
+			},
+		},
+		{
+			name: "default not in list",
+			env: testEnv{
+				"providers":        "http://faas-lambda:8081",
+				"default_provider": "http://faas-netes:8080",
+			},
+			want: []ProviderConfig{
+				{Name: "faas-lambda", URL: "http://faas-lambda:8081"},
+				{Name: "faas-netes", URL: "http://faas-netes:8080", Default: true},
+			},
+		},
+		{
+			name:    "missing default",
+			env:     testEnv{"providers": "http://faas-netes:8080"},
+			wantErr: "the environment variable `default_provider` must be set",
+		},
+		{
+			name:    "missing config file",
+			env:     testEnv{"providers_config": "/does/not/exist.yaml"},
+			wantErr: "unable to read providers config /does/not/exist.yaml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ReadConfig{}.Read(tt.env)
+			if len(tt.wantErr) > 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Read() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Read() unexpected error = %v", err)
+			}
+
+			if !reflect.DeepEqual(cfg.Providers, tt.want) {
+				t.Errorf("Read() providers = %+v, want %+v", cfg.Providers, tt.want)
+			}
+		})
+	}
+}
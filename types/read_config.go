@@ -56,6 +56,16 @@ func parseIntOrDurationValue(val string, fallback time.Duration) time.Duration {
 	return duration
 }
 
+func parseFloatValue(val string, fallback float64) float64 {
+	if len(val) > 0 {
+		parsedVal, parseErr := strconv.ParseFloat(val, 64)
+		if parseErr == nil && parsedVal >= 0 {
+			return parsedVal
+		}
+	}
+	return fallback
+}
+
 func parseBoolValue(val string, fallback bool) bool {
 	if len(val) > 0 {
 		return val == "true"
@@ -70,9 +80,39 @@ func parseString(val string, fallback string) string {
 	return fallback
 }
 
-// Read fetches config from environmental variables.
-func (r ReadConfig) Read(hasEnv HasEnv) BootstrapConfig {
-	r.ensureRequired()
+// parseProviderLabels parses labels for each provider in the form
+// `provider-a:region=eu-west,arch=amd64;provider-b:kind=lambda`, malformed entries are skipped
+func parseProviderLabels(val string) map[string]map[string]string {
+	result := map[string]map[string]string{}
+	for _, entry := range strings.Split(val, ";") {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		name := strings.TrimSpace(parts[0])
+		if len(name) == 0 {
+			continue
+		}
+
+		labels := map[string]string{}
+		for _, pair := range strings.Split(parts[1], ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 {
+				continue
+			}
+			labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+
+		result[name] = labels
+	}
+
+	return result
+}
+
+// Read fetches config from environmental variables, the providers are read from the
+// file given by providers_config or otherwise from the providers env-var.
+func (r ReadConfig) Read(hasEnv HasEnv) (BootstrapConfig, error) {
 	defaultTCPPort := 8080
 
 	cfg := BootstrapConfig{}
@@ -81,31 +121,144 @@ func (r ReadConfig) Read(hasEnv HasEnv) BootstrapConfig {
 	cfg.WriteTimeout = parseIntOrDurationValue(hasEnv.Getenv("write_timeout"), time.Minute*3)
 	cfg.Port = parseIntValue(hasEnv.Getenv("port"), defaultTCPPort)
 
-	providers := strings.Split(os.Getenv("providers"), ",")
-	for i, v := range providers {
-		providers[i] = strings.Trim(v, " ")
+	cfg.SecretMountPath = parseString(hasEnv.Getenv("secret_mount_path"), "/var/secrets/")
+	cfg.JWTJWKS = hasEnv.Getenv("jwt_jwks")
+	cfg.JWTIssuer = hasEnv.Getenv("jwt_issuer")
+	cfg.JWTAudience = hasEnv.Getenv("jwt_audience")
+	cfg.JWTPermissionsClaim = parseString(hasEnv.Getenv("jwt_permissions_claim"), "permissions")
+	cfg.EnableBasicAuth = parseBoolValue(hasEnv.Getenv("basic_auth"), len(hasEnv.Getenv("secret_mount_path")) > 0 && len(cfg.JWTJWKS) == 0)
+	if cfg.EnableBasicAuth && len(cfg.JWTJWKS) > 0 {
+		return cfg, fmt.Errorf("`basic_auth` and `jwt_jwks` can not both be enabled")
+	}
+	cfg.TLSCertFile = hasEnv.Getenv("tls_cert_file")
+	cfg.TLSKeyFile = hasEnv.Getenv("tls_key_file")
+	if (len(cfg.TLSCertFile) == 0) != (len(cfg.TLSKeyFile) == 0) {
+		return cfg, fmt.Errorf("both `tls_cert_file` and `tls_key_file` must be set to serve TLS")
 	}
 
-	cfg.Providers = providers
-	cfg.DefaultProvider = os.Getenv("default_provider")
-	return cfg
+	cfg.ProvidersConfigPath = hasEnv.Getenv("providers_config")
+	cfg.ProvidersConfigReloadInterval = parseIntOrDurationValue(hasEnv.Getenv("providers_config_reload_interval"), time.Second*10)
+	cfg.ProviderCredentialsDir = hasEnv.Getenv("provider_credentials_dir")
+	if len(cfg.ProvidersConfigPath) > 0 {
+		providers, err := ReadProvidersFile(cfg.ProvidersConfigPath)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Providers = providers
+	} else {
+		if err := r.ensureRequired(hasEnv); err != nil {
+			return cfg, err
+		}
+
+		cfg.Providers = providersFromEnv(hasEnv.Getenv("providers"),
+			strings.Trim(hasEnv.Getenv("default_provider"), " "),
+			parseProviderLabels(hasEnv.Getenv("provider_labels")))
+		if err := ValidateProviders(cfg.Providers); err != nil {
+			return cfg, err
+		}
+	}
+
+	cfg.CacheReconcileInterval = parseIntOrDurationValue(hasEnv.Getenv("cache_reconcile_interval"), time.Second*30)
+	cfg.CacheSnapshotPath = hasEnv.Getenv("cache_snapshot_path")
+	cfg.CacheMissNegativeTTL = parseIntOrDurationValue(hasEnv.Getenv("cache_miss_negative_ttl"), time.Second*5)
+	cfg.CacheMissReloadInterval = parseIntOrDurationValue(hasEnv.Getenv("cache_miss_reload_interval"), time.Second)
+
+	cfg.HealthCheckInterval = parseIntOrDurationValue(hasEnv.Getenv("health_check_interval"), time.Second*10)
+	cfg.HealthCheckTimeout = parseIntOrDurationValue(hasEnv.Getenv("health_check_timeout"), time.Second*5)
+	cfg.HealthCheckFailureThreshold = parseIntValue(hasEnv.Getenv("health_check_failure_threshold"), 3)
+
+	cfg.CircuitBreakerFailureThreshold = parseIntValue(hasEnv.Getenv("circuit_breaker_failure_threshold"), 5)
+	cfg.CircuitBreakerOpenTimeout = parseIntOrDurationValue(hasEnv.Getenv("circuit_breaker_open_timeout"), time.Second*30)
+	cfg.CircuitBreakerHalfOpenRequests = parseIntValue(hasEnv.Getenv("circuit_breaker_half_open_requests"), 1)
+
+	cfg.RetryMaxAttempts = parseIntValue(hasEnv.Getenv("retry_max_attempts"), 2)
+	cfg.RetryMaxBodyBytes = int64(parseIntValue(hasEnv.Getenv("retry_max_body_bytes"), 1024*1024))
+	cfg.RetryBudgetRatio = parseFloatValue(hasEnv.Getenv("retry_budget_ratio"), 0.1)
+
+	cfg.ReplicaDistribution = parseString(hasEnv.Getenv("replica_distribution"), "even")
+
+	cfg.MetricsMaxFunctions = parseIntValue(hasEnv.Getenv("metrics_max_functions"), 500)
+	cfg.MetricsMaxSeries = parseIntValue(hasEnv.Getenv("metrics_max_series"), 5000)
+	return cfg, nil
 }
 
-func (r ReadConfig) ensureRequired() {
+func (r ReadConfig) ensureRequired(hasEnv HasEnv) error {
 	var required = []string{"providers", "default_provider"}
 
 	for _, v := range required {
-		if _, ok := os.LookupEnv(v); !ok {
-			panic(fmt.Sprintf("the environment variable `%s` must be set", v))
+		if len(strings.TrimSpace(hasEnv.Getenv(v))) == 0 {
+			return fmt.Errorf("the environment variable `%s` must be set, or `providers_config` given", v)
 		}
 	}
+
+	return nil
 }
 
 // BootstrapConfig for the process.
 type BootstrapConfig struct {
-	Port            int
-	ReadTimeout     time.Duration
-	WriteTimeout    time.Duration
-	Providers       []string
-	DefaultProvider string
+	Port         int
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// EnableBasicAuth on the /system endpoints, using the credentials in SecretMountPath
+	EnableBasicAuth bool
+	// SecretMountPath containing basic-auth-user and basic-auth-password
+	SecretMountPath string
+	// JWTJWKS is a path or URL of the JWKS used to verify bearer tokens, JWT auth is enabled when set
+	JWTJWKS string
+	// JWTIssuer and JWTAudience the tokens must have, not checked when empty
+	JWTIssuer   string
+	JWTAudience string
+	// JWTPermissionsClaim of the tokens listing the permissions of the caller
+	JWTPermissionsClaim string
+	// TLSCertFile and TLSKeyFile are served when set, they are reloaded when they change
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Providers of the federation, exactly one of which is the default
+	Providers []ProviderConfig
+	// ProvidersConfigPath of the file the providers were read from, empty when read from env-vars
+	ProvidersConfigPath string
+	// ProvidersConfigReloadInterval between checks of the providers config for changes, 0 disables the check
+	ProvidersConfigReloadInterval time.Duration
+	// ProviderCredentialsDir holds the credential and TLS files of providers registered through the API
+	ProviderCredentialsDir string
+
+	// CacheReconcileInterval between reconciliations of the function cache with the providers, 0 disables reconciliation
+	CacheReconcileInterval time.Duration
+	// CacheSnapshotPath the function cache is written to after each reconciliation and restored from at startup, empty disables snapshots
+	CacheSnapshotPath string
+	// CacheMissNegativeTTL for which a function found missing after a reload is not looked up again, 0 disables the negative cache
+	CacheMissNegativeTTL time.Duration
+	// CacheMissReloadInterval is the minimum interval between reloads of the function cache triggered by lookups of unknown functions
+	CacheMissReloadInterval time.Duration
+
+	// HealthCheckInterval between provider health probes, 0 disables health checking
+	HealthCheckInterval time.Duration
+	// HealthCheckTimeout for each provider health probe
+	HealthCheckTimeout time.Duration
+	// HealthCheckFailureThreshold consecutive failed probes before a provider is marked down
+	HealthCheckFailureThreshold int
+
+	// CircuitBreakerFailureThreshold consecutive failed invocations which open a provider's circuit, 0 disables circuit breaking
+	CircuitBreakerFailureThreshold int
+	// CircuitBreakerOpenTimeout before an open circuit lets probe invocations through
+	CircuitBreakerOpenTimeout time.Duration
+	// CircuitBreakerHalfOpenRequests concurrent probe invocations allowed while a circuit is half-open
+	CircuitBreakerHalfOpenRequests int
+
+	// RetryMaxAttempts for an invocation of a function which opted in to retries, including the first
+	RetryMaxAttempts int
+	// RetryMaxBodyBytes buffered so that an invocation can be retried, larger requests are not retried
+	RetryMaxBodyBytes int64
+	// RetryBudgetRatio of invocations which may be retried
+	RetryBudgetRatio float64
+
+	// ReplicaDistribution is the default policy splitting the replicas of a function across its providers
+	ReplicaDistribution string
+
+	// MetricsMaxFunctions is the number of distinct functions labelled in the metrics, later functions are labelled "other"
+	MetricsMaxFunctions int
+	// MetricsMaxSeries of each metric, observations of new label values beyond it are dropped, 0 removes the limit
+	MetricsMaxSeries int
 }